@@ -21,6 +21,13 @@ const (
 	Version   = "v1alpha1"
 
 	SpaceClaimParametersKind = "SpaceClaimParameters"
+	SpaceClassParametersKind = "SpaceClassParameters"
+	SpaceKind                = "Space"
+
+	// SpaceServiceAccountName is the ServiceAccount the controller creates
+	// in every allocated namespace. Containers consuming the claim get
+	// credentials for it.
+	SpaceServiceAccountName = "space"
 )
 
 func DefaultSpaceClaimParametersSpec() *SpaceClaimParametersSpec {
@@ -28,3 +35,10 @@ func DefaultSpaceClaimParametersSpec() *SpaceClaimParametersSpec {
 		GenerateName: "space-",
 	}
 }
+
+func DefaultSpaceClassParametersSpec() *SpaceClassParametersSpec {
+	shareable := true
+	return &SpaceClassParametersSpec{
+		Shareable: &shareable,
+	}
+}
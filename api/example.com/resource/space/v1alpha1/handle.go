@@ -0,0 +1,269 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha1
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ResourceHandleVersion is the version of the ResourceHandle schema written
+// by this package.
+const ResourceHandleVersion = "v1"
+
+// ResourceHandle is what the controller passes to the kubelet plugin in
+// ResourceHandle.Data for every allocated space. It is encoded as JSON.
+// The kubelet expects a single result per claim, so the handles of a claim
+// with several spaces are encoded together by EncodeResourceHandles.
+//
+// Handles written by older controllers are the plain namespace name, or
+// "<space name>/<namespace>" for named spaces; DecodeResourceHandle still
+// accepts those and returns a handle with only SpaceName and Namespace set.
+type ResourceHandle struct {
+	Version string `json:"version"`
+
+	// SpaceName is the name of the space within the claim. It is empty for
+	// claims with a single, unnamed space.
+	SpaceName string `json:"spaceName,omitempty"`
+	// Namespace is the namespace backing the space.
+	Namespace string `json:"namespace"`
+	// Cluster is the URL of the API server serving Namespace, if the class
+	// parameters override it. Otherwise containers use the API server of
+	// the kubelet plugin.
+	Cluster string `json:"cluster,omitempty"`
+	// ExpirationTime is when the space stops being usable, if its class
+	// bounds the lifetime of spaces. The kubelet plugin issues no
+	// credentials for the space beyond it.
+	ExpirationTime *metav1.Time `json:"expirationTime,omitempty"`
+
+	// ServiceAccount is the ServiceAccount in Namespace which credentials
+	// are issued for.
+	ServiceAccount string `json:"serviceAccount,omitempty"`
+	// Role is the ClusterRole the ServiceAccount is bound to in Namespace.
+	Role string `json:"role,omitempty"`
+	// Adopted is true if the namespace existed before the allocation.
+	Adopted bool `json:"adopted,omitempty"`
+
+	// CredentialLayout and ServiceAccountMountPath are copied from the
+	// SpaceClaimParameters the claim was allocated with.
+	CredentialLayout        CredentialLayout `json:"credentialLayout,omitempty"`
+	ServiceAccountMountPath string           `json:"serviceAccountMountPath,omitempty"`
+
+	// KeyID names the key the handle was signed with and Signature is the
+	// HMAC-SHA256 computed by SignResourceHandle. Both are empty if the
+	// controller does not sign handles.
+	KeyID     string `json:"keyID,omitempty"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+// EncodeResourceHandle serializes a handle for ResourceHandle.Data. The
+// version is filled in if unset.
+func EncodeResourceHandle(handle *ResourceHandle) (string, error) {
+	if handle.Version == "" {
+		handle.Version = ResourceHandleVersion
+	}
+	err := validateResourceHandle(handle)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(handle)
+	if err != nil {
+		return "", fmt.Errorf("unable to encode resource handle: %v", err)
+	}
+	return string(data), nil
+}
+
+// DecodeResourceHandle parses ResourceHandle.Data as written by any version
+// of the controller.
+func DecodeResourceHandle(data string) (*ResourceHandle, error) {
+	if !strings.HasPrefix(data, "{") {
+		spaceName, ns, found := strings.Cut(data, "/")
+		if !found {
+			spaceName, ns = "", data
+		}
+		handle := &ResourceHandle{SpaceName: spaceName, Namespace: ns}
+		err := validateResourceHandle(handle)
+		if err != nil {
+			return nil, err
+		}
+		return handle, nil
+	}
+
+	var handle ResourceHandle
+	err := json.Unmarshal([]byte(data), &handle)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode resource handle: %v", err)
+	}
+	if handle.Version != ResourceHandleVersion {
+		return nil, fmt.Errorf("unsupported resource handle version: %q", handle.Version)
+	}
+	err = validateResourceHandle(&handle)
+	if err != nil {
+		return nil, err
+	}
+	return &handle, nil
+}
+
+// EncodeResourceHandles serializes the handles of all spaces of a claim for
+// a single ResourceHandle.Data. A single handle is encoded as by
+// EncodeResourceHandle, several as a JSON list.
+func EncodeResourceHandles(handles []*ResourceHandle) (string, error) {
+	if len(handles) == 0 {
+		return "", fmt.Errorf("no resource handles to encode")
+	}
+	if len(handles) == 1 {
+		return EncodeResourceHandle(handles[0])
+	}
+	for _, handle := range handles {
+		if handle.Version == "" {
+			handle.Version = ResourceHandleVersion
+		}
+		err := validateResourceHandle(handle)
+		if err != nil {
+			return "", err
+		}
+	}
+	data, err := json.Marshal(handles)
+	if err != nil {
+		return "", fmt.Errorf("unable to encode resource handles: %v", err)
+	}
+	return string(data), nil
+}
+
+// DecodeResourceHandles parses ResourceHandle.Data as written by
+// EncodeResourceHandles or DecodeResourceHandle and returns the handles of
+// all spaces in it.
+func DecodeResourceHandles(data string) ([]*ResourceHandle, error) {
+	if !strings.HasPrefix(data, "[") {
+		handle, err := DecodeResourceHandle(data)
+		if err != nil {
+			return nil, err
+		}
+		return []*ResourceHandle{handle}, nil
+	}
+
+	var handles []*ResourceHandle
+	err := json.Unmarshal([]byte(data), &handles)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode resource handles: %v", err)
+	}
+	if len(handles) == 0 {
+		return nil, fmt.Errorf("resource handle lists no spaces")
+	}
+	for _, handle := range handles {
+		if handle == nil {
+			return nil, fmt.Errorf("resource handle lists an empty space")
+		}
+		if handle.Version != ResourceHandleVersion {
+			return nil, fmt.Errorf("unsupported resource handle version: %q", handle.Version)
+		}
+		err = validateResourceHandle(handle)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return handles, nil
+}
+
+// validateResourceHandle checks the names in a handle. The kubelet plugin
+// uses them in paths on the node, and handles are not necessarily signed,
+// so anything the controller could not have written is rejected.
+func validateResourceHandle(handle *ResourceHandle) error {
+	if handle.Namespace == "" {
+		return fmt.Errorf("resource handle has no namespace")
+	}
+	if errs := validation.IsDNS1123Label(handle.Namespace); len(errs) > 0 {
+		return fmt.Errorf("invalid namespace %q in resource handle: %s", handle.Namespace, strings.Join(errs, ", "))
+	}
+	if handle.SpaceName != "" {
+		if errs := validation.IsDNS1123Label(handle.SpaceName); len(errs) > 0 {
+			return fmt.Errorf("invalid space name %q in resource handle: %s", handle.SpaceName, strings.Join(errs, ", "))
+		}
+	}
+	if handle.Cluster != "" {
+		u, err := url.Parse(handle.Cluster)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("invalid cluster %q in resource handle: must be an https URL", handle.Cluster)
+		}
+	}
+	return nil
+}
+
+// SignResourceHandle signs a handle for the claim with the given UID, so
+// that it cannot be altered or copied to another claim without the key.
+// The version is filled in if unset, since it is part of what is signed.
+func SignResourceHandle(handle *ResourceHandle, claimUID string, keyID string, key []byte) error {
+	if handle.Version == "" {
+		handle.Version = ResourceHandleVersion
+	}
+	handle.KeyID = keyID
+	signature, err := resourceHandleSignature(handle, claimUID, key)
+	if err != nil {
+		return err
+	}
+	handle.Signature = signature
+	return nil
+}
+
+// VerifyResourceHandle checks that a handle was signed for the claim with
+// the given UID by one of keys, which are indexed by key ID. Unsigned
+// handles are rejected.
+func VerifyResourceHandle(handle *ResourceHandle, claimUID string, keys map[string][]byte) error {
+	if len(handle.Signature) == 0 {
+		return fmt.Errorf("resource handle is not signed")
+	}
+	key, ok := keys[handle.KeyID]
+	if !ok {
+		return fmt.Errorf("resource handle is signed with unknown key %q", handle.KeyID)
+	}
+	signature, err := resourceHandleSignature(handle, claimUID, key)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(signature, handle.Signature) {
+		return fmt.Errorf("resource handle signature does not match")
+	}
+	return nil
+}
+
+// resourceHandleSignature computes the HMAC of the claim UID and the
+// handle without its signature. The handle is re-encoded rather than
+// signed as received, so fields may only be added without a new version if
+// they are omitted when empty: handles without them then sign the same, and
+// plugins which do not know them drop them and fail verification instead of
+// ignoring them.
+func resourceHandleSignature(handle *ResourceHandle, claimUID string, key []byte) ([]byte, error) {
+	unsigned := *handle
+	unsigned.Signature = nil
+	payload, err := json.Marshal(&unsigned)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode resource handle: %v", err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(claimUID))
+	mac.Write([]byte{0})
+	mac.Write(payload)
+	return mac.Sum(nil), nil
+}
@@ -0,0 +1,378 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha1
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDecodeResourceHandle(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    *ResourceHandle
+		wantErr string
+	}{
+		{
+			name: "legacy namespace",
+			data: "ns-1",
+			want: &ResourceHandle{Namespace: "ns-1"},
+		},
+		{
+			name: "legacy named space",
+			data: "dev/ns-1",
+			want: &ResourceHandle{SpaceName: "dev", Namespace: "ns-1"},
+		},
+		{
+			name: "json",
+			data: `{"version":"v1","spaceName":"dev","namespace":"ns-1","role":"admin"}`,
+			want: &ResourceHandle{Version: "v1", SpaceName: "dev", Namespace: "ns-1", Role: "admin"},
+		},
+		{
+			name: "json with cluster and expiration",
+			data: `{"version":"v1","namespace":"ns-1","cluster":"https://lb.example.com:6443","expirationTime":"2024-01-02T03:04:05Z"}`,
+			want: &ResourceHandle{
+				Version:        "v1",
+				Namespace:      "ns-1",
+				Cluster:        "https://lb.example.com:6443",
+				ExpirationTime: &metav1.Time{Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC).Local()},
+			},
+		},
+		{
+			name:    "cluster which is no https URL",
+			data:    `{"version":"v1","namespace":"ns-1","cluster":"http://lb.example.com:6443"}`,
+			wantErr: "invalid cluster",
+		},
+		{
+			name:    "legacy without namespace",
+			data:    "dev/",
+			wantErr: "no namespace",
+		},
+		{
+			name:    "json without namespace",
+			data:    `{"version":"v1"}`,
+			wantErr: "no namespace",
+		},
+		{
+			name:    "unsupported version",
+			data:    `{"version":"v2","namespace":"ns-1"}`,
+			wantErr: "unsupported resource handle version",
+		},
+		{
+			name:    "malformed json",
+			data:    `{"version":`,
+			wantErr: "unable to decode",
+		},
+		{
+			name:    "legacy space name traversal",
+			data:    "../ns-1",
+			wantErr: "invalid space name",
+		},
+		{
+			name:    "legacy namespace traversal",
+			data:    "dev/../../x",
+			wantErr: "invalid namespace",
+		},
+		{
+			name:    "json space name traversal",
+			data:    `{"version":"v1","spaceName":"..","namespace":"ns-1"}`,
+			wantErr: "invalid space name",
+		},
+		{
+			name:    "json namespace traversal",
+			data:    `{"version":"v1","namespace":"../../x"}`,
+			wantErr: "invalid namespace",
+		},
+		{
+			name:    "upper case namespace",
+			data:    `{"version":"v1","namespace":"NS"}`,
+			wantErr: "invalid namespace",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := DecodeResourceHandle(tc.data)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("expected %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestEncodeResourceHandle(t *testing.T) {
+	expiration := metav1.NewTime(time.Now().Add(time.Hour).Truncate(time.Second))
+	handle := &ResourceHandle{
+		SpaceName:      "dev",
+		Namespace:      "ns-1",
+		Cluster:        "https://lb.example.com:6443",
+		ServiceAccount: "space",
+		ExpirationTime: &expiration,
+	}
+	data, err := EncodeResourceHandle(handle)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if handle.Version != ResourceHandleVersion {
+		t.Errorf("expected version %q to be filled in, got %q", ResourceHandleVersion, handle.Version)
+	}
+	decoded, err := DecodeResourceHandle(data)
+	if err != nil {
+		t.Fatalf("unable to decode %s: %v", data, err)
+	}
+	if !reflect.DeepEqual(decoded, handle) {
+		t.Errorf("expected %+v after round trip, got %+v", handle, decoded)
+	}
+
+	_, err = EncodeResourceHandle(&ResourceHandle{SpaceName: "..", Namespace: "ns-1"})
+	if err == nil {
+		t.Errorf("expected an invalid space name to be rejected")
+	}
+}
+
+func TestResourceHandles(t *testing.T) {
+	handles := []*ResourceHandle{
+		{SpaceName: "dev", Namespace: "ns-1"},
+		{SpaceName: "prod", Namespace: "ns-2"},
+	}
+	data, err := EncodeResourceHandles(handles)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	decoded, err := DecodeResourceHandles(data)
+	if err != nil {
+		t.Fatalf("unable to decode %s: %v", data, err)
+	}
+	if !reflect.DeepEqual(decoded, handles) {
+		t.Errorf("expected %+v after round trip, got %+v", handles, decoded)
+	}
+
+	// A single space is encoded as a plain handle, which older kubelet
+	// plugins understand.
+	data, err = EncodeResourceHandles(handles[:1])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := DecodeResourceHandle(data); err != nil {
+		t.Errorf("expected a single space to decode as one handle, got %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		want    []*ResourceHandle
+		wantErr string
+	}{
+		{
+			name: "legacy namespace",
+			data: "ns-1",
+			want: []*ResourceHandle{{Namespace: "ns-1"}},
+		},
+		{
+			name:    "empty list",
+			data:    `[]`,
+			wantErr: "no spaces",
+		},
+		{
+			name:    "null space",
+			data:    `[null]`,
+			wantErr: "empty space",
+		},
+		{
+			name:    "unsupported version",
+			data:    `[{"version":"v1","namespace":"ns-1"},{"namespace":"ns-2"}]`,
+			wantErr: "unsupported resource handle version",
+		},
+		{
+			name:    "namespace traversal",
+			data:    `[{"version":"v1","namespace":"ns-1"},{"version":"v1","namespace":"../x"}]`,
+			wantErr: "invalid namespace",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := DecodeResourceHandles(tc.data)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("expected %+v, got %+v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestVerifyResourceHandle(t *testing.T) {
+	keys := map[string][]byte{
+		"key-1": []byte("secret-1"),
+		"key-2": []byte("secret-2"),
+	}
+
+	// Handles are signed before they are encoded, like the controller does.
+	signed := func(keyID string, key []byte) *ResourceHandle {
+		expiration := metav1.NewTime(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC))
+		handle := &ResourceHandle{
+			SpaceName:      "dev",
+			Namespace:      "ns-1",
+			Cluster:        "https://lb.example.com:6443",
+			Role:           "admin",
+			ExpirationTime: &expiration,
+		}
+		err := SignResourceHandle(handle, "uid-1", keyID, key)
+		if err != nil {
+			t.Fatalf("unable to sign handle: %v", err)
+		}
+		data, err := EncodeResourceHandle(handle)
+		if err != nil {
+			t.Fatalf("unable to encode handle: %v", err)
+		}
+		decoded, err := DecodeResourceHandle(data)
+		if err != nil {
+			t.Fatalf("unable to decode handle: %v", err)
+		}
+		return decoded
+	}
+
+	tests := []struct {
+		name     string
+		handle   *ResourceHandle
+		claimUID string
+		wantErr  string
+	}{
+		{
+			name:     "signed",
+			handle:   signed("key-1", keys["key-1"]),
+			claimUID: "uid-1",
+		},
+		{
+			name:     "signed with another known key",
+			handle:   signed("key-2", keys["key-2"]),
+			claimUID: "uid-1",
+		},
+		{
+			name:     "unsigned",
+			handle:   &ResourceHandle{Version: "v1", Namespace: "ns-1"},
+			claimUID: "uid-1",
+			wantErr:  "not signed",
+		},
+		{
+			name:     "unknown key",
+			handle:   signed("key-3", []byte("secret-3")),
+			claimUID: "uid-1",
+			wantErr:  "unknown key",
+		},
+		{
+			name:     "wrong key",
+			handle:   signed("key-1", keys["key-2"]),
+			claimUID: "uid-1",
+			wantErr:  "does not match",
+		},
+		{
+			name:     "other claim",
+			handle:   signed("key-1", keys["key-1"]),
+			claimUID: "uid-2",
+			wantErr:  "does not match",
+		},
+		{
+			name: "altered namespace",
+			handle: func() *ResourceHandle {
+				handle := signed("key-1", keys["key-1"])
+				handle.Namespace = "kube-system"
+				return handle
+			}(),
+			claimUID: "uid-1",
+			wantErr:  "does not match",
+		},
+		{
+			name: "altered role",
+			handle: func() *ResourceHandle {
+				handle := signed("key-1", keys["key-1"])
+				handle.Role = "cluster-admin"
+				return handle
+			}(),
+			claimUID: "uid-1",
+			wantErr:  "does not match",
+		},
+		{
+			name: "altered cluster",
+			handle: func() *ResourceHandle {
+				handle := signed("key-1", keys["key-1"])
+				handle.Cluster = "https://attacker.example.com:6443"
+				return handle
+			}(),
+			claimUID: "uid-1",
+			wantErr:  "does not match",
+		},
+		{
+			name: "extended expiration",
+			handle: func() *ResourceHandle {
+				handle := signed("key-1", keys["key-1"])
+				extended := metav1.NewTime(handle.ExpirationTime.Add(24 * time.Hour))
+				handle.ExpirationTime = &extended
+				return handle
+			}(),
+			claimUID: "uid-1",
+			wantErr:  "does not match",
+		},
+		{
+			name: "removed expiration",
+			handle: func() *ResourceHandle {
+				handle := signed("key-1", keys["key-1"])
+				handle.ExpirationTime = nil
+				return handle
+			}(),
+			claimUID: "uid-1",
+			wantErr:  "does not match",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := VerifyResourceHandle(tc.handle, tc.claimUID, keys)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SpacesManifestVersion is the version of the SpacesManifest schema written
+// by the kubelet plugin.
+const SpacesManifestVersion = "v1"
+
+// SpacesManifestPath is where the kubelet plugin mounts the SpacesManifest
+// into containers, if enabled.
+const SpacesManifestPath = "/etc/dra/spaces.json"
+
+// SpacesManifest lists the spaces available to the containers of a pod, so
+// that they can be discovered without knowing the environment variables
+// of each claim. It is encoded as JSON.
+type SpacesManifest struct {
+	Version string `json:"version"`
+
+	Spaces []SpaceManifestEntry `json:"spaces"`
+}
+
+// SpaceManifestEntry describes one space of a claim as seen from inside a
+// container.
+type SpaceManifestEntry struct {
+	// ClaimName is the name the pod uses for the claim. It is empty in the
+	// descriptor of a single claim.
+	ClaimName string `json:"claimName,omitempty"`
+	// ResourceClaimName is the name of the ResourceClaim object.
+	ResourceClaimName string `json:"resourceClaimName"`
+	// SpaceName is the name of the space within the claim. It is empty for
+	// claims with a single, unnamed space.
+	SpaceName string `json:"spaceName,omitempty"`
+	// Namespace is the namespace backing the space.
+	Namespace string `json:"namespace"`
+	// Server is the URL of the API server.
+	Server string `json:"server"`
+
+	// ServiceAccount and Role are who the credentials are issued for and
+	// what they are allowed to do in Namespace.
+	ServiceAccount string `json:"serviceAccount,omitempty"`
+	Role           string `json:"role,omitempty"`
+
+	// ExpirationTime is when the mounted credentials expire, or the space
+	// itself if that is earlier. It moves forward whenever the kubelet
+	// plugin refreshes the token.
+	ExpirationTime *metav1.Time `json:"expirationTime,omitempty"`
+
+	Credentials SpaceCredentialPaths `json:"credentials"`
+}
+
+// SpaceCredentialPaths are the paths inside the container of the
+// credentials for a space. Paths which are not mounted are empty.
+type SpaceCredentialPaths struct {
+	Kubeconfig     string `json:"kubeconfig,omitempty"`
+	Token          string `json:"token,omitempty"`
+	CACert         string `json:"caCert,omitempty"`
+	MetadataSocket string `json:"metadataSocket,omitempty"`
+}
@@ -43,6 +43,10 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
 		&SpaceClaimParameters{},
 		&SpaceClaimParametersList{},
+		&SpaceClassParameters{},
+		&SpaceClassParametersList{},
+		&Space{},
+		&SpaceList{},
 	)
 	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
@@ -0,0 +1,103 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// SpacePhase is a label for the lifecycle stage of a Space.
+type SpacePhase string
+
+const (
+	// SpacePhaseAllocating means the controller is provisioning the namespace.
+	SpacePhaseAllocating SpacePhase = "Allocating"
+	// SpacePhaseReady means the namespace exists and can be consumed.
+	SpacePhaseReady SpacePhase = "Ready"
+	// SpacePhaseTerminating means the claim is being deallocated.
+	SpacePhaseTerminating SpacePhase = "Terminating"
+	// SpacePhaseFailed means the last allocation attempt failed.
+	SpacePhaseFailed SpacePhase = "Failed"
+)
+
+const (
+	// SpaceConditionReady indicates whether the namespace backing the Space is usable.
+	SpaceConditionReady = "Ready"
+)
+
+// SpaceClaimReference identifies the ResourceClaim a Space was allocated for.
+type SpaceClaimReference struct {
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	UID       types.UID `json:"uid"`
+}
+
+// SpaceStatus is the observed state of a Space.
+type SpaceStatus struct {
+	ClaimRef  *SpaceClaimReference `json:"claimRef,omitempty"`
+	Namespace string               `json:"namespace,omitempty"`
+	Phase     SpacePhase           `json:"phase,omitempty"`
+
+	// Adopted is true if the namespace existed before the allocation and
+	// is released rather than deleted when the claim is deallocated.
+	Adopted bool `json:"adopted,omitempty"`
+
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// CreationTime is when the namespace backing the Space was created.
+	CreationTime *metav1.Time `json:"creationTime,omitempty"`
+
+	// ExpirationTime is when the Space stops being usable, if its class
+	// bounds the lifetime of spaces.
+	ExpirationTime *metav1.Time `json:"expirationTime,omitempty"`
+}
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +k8s:openapi-gen=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Claim",type=string,JSONPath=`.status.claimRef.name`
+// +kubebuilder:printcolumn:name="Claim Namespace",type=string,JSONPath=`.status.claimRef.namespace`
+// +kubebuilder:printcolumn:name="Namespace",type=string,JSONPath=`.status.namespace`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// Space records a namespace allocated by the driver for a resource claim.
+// The controller creates one for every allocation and deletes it once
+// deallocation has finished.
+type Space struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Status SpaceStatus `json:"status,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// SpaceList represents the "plural" of a Space CRD object.
+type SpaceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []Space `json:"items"`
+}
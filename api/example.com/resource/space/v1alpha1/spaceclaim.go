@@ -20,9 +20,85 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+// CredentialLayout selects how the credentials for a space are exposed to
+// containers.
+type CredentialLayout string
+
+const (
+	// CredentialLayoutKubeconfig mounts a kubeconfig for the space and
+	// points an environment variable at it.
+	CredentialLayoutKubeconfig CredentialLayout = "kubeconfig"
+	// CredentialLayoutServiceAccount mounts the token, ca.crt and namespace
+	// files for the space where client-go expects in-cluster credentials.
+	CredentialLayoutServiceAccount CredentialLayout = "serviceaccount"
+	// CredentialLayoutBoth combines both layouts.
+	CredentialLayoutBoth CredentialLayout = "both"
+)
+
+// DefaultServiceAccountMountPath is where in-cluster clients look for
+// ServiceAccount credentials.
+const DefaultServiceAccountMountPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+
 // SpaceClaimParametersSpec is the spec for the SpaceClaimParameters CRD.
 type SpaceClaimParametersSpec struct {
 	GenerateName string `json:"generateName,omitempty"`
+
+	// EnvPrefix overrides the prefix of the environment variables exposed
+	// to containers. By default the name the pod uses to refer to the claim
+	// is used, upper-cased with dashes replaced by underscores.
+	// +optional
+	// +kubebuilder:validation:Pattern=`^[A-Z_][A-Z0-9_]*$`
+	EnvPrefix string `json:"envPrefix,omitempty"`
+
+	// CredentialLayout selects how the credentials for the space are
+	// exposed to containers. Defaults to "kubeconfig". The "serviceaccount"
+	// layout lets unmodified in-cluster clients target the space; pods
+	// using it should disable automountServiceAccountToken.
+	// +optional
+	// +kubebuilder:validation:Enum=kubeconfig;serviceaccount;both
+	CredentialLayout CredentialLayout `json:"credentialLayout,omitempty"`
+
+	// ServiceAccountMountPath overrides where the "serviceaccount" layout
+	// mounts the credentials. Defaults to
+	// /var/run/secrets/kubernetes.io/serviceaccount.
+	// +optional
+	ServiceAccountMountPath string `json:"serviceAccountMountPath,omitempty"`
+
+	// NamespaceSelector requests an existing, pre-approved namespace
+	// matching the selector instead of generating a new one. Adopted
+	// namespaces are released back to the pool on deallocation rather than
+	// deleted.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Spaces requests a set of related namespaces which are allocated and
+	// deallocated together under a single claim. When empty, the claim is
+	// allocated exactly one space.
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Spaces []SpaceConfig `json:"spaces,omitempty"`
+
+	// Shareable controls whether more than one pod may reserve the claim.
+	// When unset, the default from the class parameters applies.
+	// +optional
+	Shareable *bool `json:"shareable,omitempty"`
+}
+
+// SpaceConfig describes one of several spaces requested by a claim.
+type SpaceConfig struct {
+	// Name identifies the space within the claim. It is appended to the
+	// generated namespace name and to the environment variables and mount
+	// paths exposed to containers.
+	Name string `json:"name"`
+
+	// GenerateName overrides the claim-level prefix for this space.
+	// +optional
+	GenerateName string `json:"generateName,omitempty"`
+
+	// NamespaceSelector overrides the claim-level selector for this space.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
 }
 
 // +genclient
@@ -0,0 +1,80 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SpaceClassParametersSpec is the spec for the SpaceClassParameters CRD.
+type SpaceClassParametersSpec struct {
+	// Shareable is the default for claims of this class which don't set it
+	// themselves. Spaces are shareable unless configured otherwise.
+	// +optional
+	Shareable *bool `json:"shareable,omitempty"`
+
+	// APIServer overrides how containers consuming claims of this class
+	// reach the API server, e.g. through a load balancer or in a remote
+	// target cluster. By default the kubelet plugin uses the endpoint and
+	// CA of its own connection.
+	// +optional
+	APIServer *APIServerConfig `json:"apiServer,omitempty"`
+
+	// Lifetime bounds how long spaces of this class can be used after they
+	// are allocated, as recorded in the expiration time of their Space.
+	// The kubelet plugin issues no credentials for a space beyond it, and no
+	// token outlives it by more than the ten minutes the API server requires
+	// at least. Spaces have no bounded lifetime by default.
+	// +optional
+	Lifetime *metav1.Duration `json:"lifetime,omitempty"`
+}
+
+// APIServerConfig describes an API server endpoint as seen from containers.
+type APIServerConfig struct {
+	// Endpoint is the URL of the API server, e.g. https://lb.example.com:6443.
+	Endpoint string `json:"endpoint"`
+
+	// CAData holds PEM-encoded certificate authority certificates for the
+	// endpoint. When empty, the CA of the kubelet plugin's own connection
+	// is used.
+	// +optional
+	CAData []byte `json:"caData,omitempty"`
+}
+
+// +genclient
+// +genclient:nonNamespaced
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +k8s:openapi-gen=true
+// +kubebuilder:resource:scope=Cluster
+
+// SpaceClassParameters holds the set of parameters provided when creating a resource class for [name]spaces.
+type SpaceClassParameters struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec SpaceClassParametersSpec `json:"spec,omitempty"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// SpaceClassParametersList represents the "plural" of a SpaceClassParameters CRD object.
+type SpaceClassParametersList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+
+	Items []SpaceClassParameters `json:"items"`
+}
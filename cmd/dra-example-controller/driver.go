@@ -19,40 +19,177 @@ package main
 import (
 	"context"
 	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	corev1 "k8s.io/api/core/v1"
 	resourcev1 "k8s.io/api/resource/v1alpha2"
 	"k8s.io/dynamic-resource-allocation/controller"
 	"k8s.io/klog/v2"
 
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/validation"
 	spacecrd "sigs.k8s.io/dra-example-driver/api/example.com/resource/space/v1alpha1"
 	"sigs.k8s.io/dra-example-driver/pkg/flags"
 )
 
 const (
-	DriverAPIGroup     = spacecrd.GroupName
-	ResourceClaimLabel = DriverAPIGroup + "/resourceclaim"
+	// DriverAPIGroup is the API group of the parameters objects. It is the
+	// same for all instances of the driver, unlike the driver name.
+	DriverAPIGroup = spacecrd.GroupName
+
+	resourceClaimLabelName = "resourceclaim"
 )
 
+// The driver name and the label keys derived from it are set from the
+// --driver-name flag by setDriverName before the controller starts.
+// Distinct names give distinct label keys, so several instances of the
+// driver can manage namespaces in the same cluster.
+var (
+	DriverName string
+
+	ResourceClaimLabel string
+	SpaceNameLabel     string
+
+	// AdoptableLabel marks a pre-approved namespace which claims may adopt
+	// by selector. AdoptedLabel marks one which is currently adopted.
+	AdoptableLabel string
+	AdoptedLabel   string
+)
+
+func setDriverName(name string) {
+	DriverName = name
+
+	ResourceClaimLabel = DriverName + "/" + resourceClaimLabelName
+	SpaceNameLabel = DriverName + "/space"
+	AdoptableLabel = DriverName + "/adoptable"
+	AdoptedLabel = DriverName + "/adopted"
+}
+
 type driver struct {
 	lock       *PerClaimMutex
 	clientsets flags.ClientSets
+
+	// handleKeys and signingKeyID are used to sign resource handles, if
+	// signing is enabled.
+	handleKeys   *flags.HandleKeysConfig
+	signingKeyID string
+
+	// Allocate and Deallocate run with opCtx rather than the context of the
+	// controller, so that stopping the controller does not cut them off
+	// half way. Drain waits for them via ops and cancels opCtx once the
+	// drain timeout has passed. Abort cancels opCtx right away.
+	opCtx     context.Context
+	cancelOps context.CancelFunc
+	opsLock   sync.Mutex
+	ops       sync.WaitGroup
+	draining  bool
 }
 
 var _ controller.Driver = &driver{}
 
 func NewDriver(config *Config) *driver {
+	opCtx, cancelOps := context.WithCancel(context.Background())
 	return &driver{
-		lock:       NewPerClaimMutex(),
-		clientsets: config.clientSets,
+		lock:         NewPerClaimMutex(),
+		clientsets:   config.clientSets,
+		handleKeys:   &config.flags.handleKeys,
+		signingKeyID: config.flags.handleSigningKeyID,
+		opCtx:        opCtx,
+		cancelOps:    cancelOps,
+	}
+}
+
+// startOperation registers an operation which modifies the cluster and
+// returns the context to run it with, which keeps the logger of ctx. The
+// returned function must be called once the operation is done. Operations
+// are refused once Drain or Abort has been called.
+func (d *driver) startOperation(ctx context.Context) (context.Context, func(), error) {
+	d.opsLock.Lock()
+	defer d.opsLock.Unlock()
+	if d.draining {
+		return nil, nil, fmt.Errorf("controller is shutting down")
+	}
+	d.ops.Add(1)
+	return klog.NewContext(d.opCtx, klog.FromContext(ctx)), d.ops.Done, nil
+}
+
+// Drain waits for running operations to finish after the controller has
+// stopped handing out work. Operations still running after the timeout are
+// canceled, and Drain returns once they have given up.
+func (d *driver) Drain(ctx context.Context, timeout time.Duration) error {
+	logger := klog.FromContext(ctx)
+	d.stopOperations()
+
+	done := make(chan struct{})
+	go func() {
+		d.ops.Wait()
+		close(done)
+	}()
+
+	logger.Info("Draining running operations", "timeout", timeout)
+	select {
+	case <-done:
+		d.cancelOps()
+		return nil
+	case <-time.After(timeout):
+		d.cancelOps()
+		<-done
+		return fmt.Errorf("operations did not finish within %v and were canceled", timeout)
 	}
 }
 
+// Abort cancels running operations without waiting for them to finish, and
+// returns once they have given up. It is used instead of Drain when
+// leadership is lost, since another instance may already be allocating.
+func (d *driver) Abort(ctx context.Context) {
+	logger := klog.FromContext(ctx)
+	d.stopOperations()
+
+	logger.Info("Canceling running operations")
+	d.cancelOps()
+	d.ops.Wait()
+}
+
+// stopOperations makes startOperation refuse new operations.
+func (d *driver) stopOperations() {
+	d.opsLock.Lock()
+	d.draining = true
+	d.opsLock.Unlock()
+}
+
 func (d *driver) GetClassParameters(ctx context.Context, class *resourcev1.ResourceClass) (interface{}, error) {
 	logger := klog.FromContext(ctx)
 	logger.Info("GetClassParameters", "class", class.Name)
-	return nil, nil
+	if class.ParametersRef == nil {
+		return spacecrd.DefaultSpaceClassParametersSpec(), nil
+	}
+	if class.ParametersRef.APIGroup != DriverAPIGroup {
+		return nil, fmt.Errorf("incorrect API group: %v", class.ParametersRef.APIGroup)
+	}
+
+	switch class.ParametersRef.Kind {
+	case spacecrd.SpaceClassParametersKind:
+		params, err := d.clientsets.Example.SpaceV1alpha1().SpaceClassParameters().Get(ctx, class.ParametersRef.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("error getting SpaceClassParameters called '%v': %v", class.ParametersRef.Name, err)
+		}
+		err = validateClassParameters(&params.Spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SpaceClassParameters called '%v': %v", class.ParametersRef.Name, err)
+		}
+		return &params.Spec, nil
+	default:
+		return nil, fmt.Errorf("unknown ResourceClass.ParametersRef.Kind: %v", class.ParametersRef.Kind)
+	}
 }
 
 func (d *driver) GetClaimParameters(ctx context.Context, claim *resourcev1.ResourceClaim, class *resourcev1.ResourceClass, classParameters interface{}) (interface{}, error) {
@@ -71,7 +208,10 @@ func (d *driver) GetClaimParameters(ctx context.Context, claim *resourcev1.Resou
 		if err != nil {
 			return nil, fmt.Errorf("error getting SpaceClaimParameters called '%v' in namespace '%v': %v", claim.Spec.ParametersRef.Name, claim.Namespace, err)
 		}
-		// TODO validate the claim params
+		err = validateClaimParameters(&params.Spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SpaceClaimParameters called '%v' in namespace '%v': %v", claim.Spec.ParametersRef.Name, claim.Namespace, err)
+		}
 		return &params.Spec, nil
 	default:
 		return nil, fmt.Errorf("unknown ResourceClaim.ParametersRef.Kind: %v", claim.Spec.ParametersRef.Kind)
@@ -82,6 +222,15 @@ func (d *driver) Allocate(ctx context.Context, cas []*controller.ClaimAllocation
 	logger := klog.FromContext(ctx)
 	logger.Info("Allocate", "numClaims", len(cas))
 
+	ctx, done, err := d.startOperation(ctx)
+	if err != nil {
+		for _, ca := range cas {
+			ca.Error = err
+		}
+		return
+	}
+	defer done()
+
 	for _, ca := range cas {
 		ca.Allocation, ca.Error = d.allocate(ctx, ca.Claim, ca.ClaimParameters, ca.Class, ca.ClassParameters, selectedNode)
 	}
@@ -92,64 +241,219 @@ func (d *driver) allocate(ctx context.Context, claim *resourcev1.ResourceClaim,
 		return nil, fmt.Errorf("TODO: immediate allocations is not yet supported")
 	}
 
-	logger := klog.FromContext(ctx)
-
 	claimUid := string(claim.GetUID())
 
 	d.lock.Get(claimUid).Lock()
 	defer d.lock.Get(claimUid).Unlock()
 
-	result := &resourcev1.AllocationResult{Shareable: true}
+	claimParams, ok := claimParameters.(*spacecrd.SpaceClaimParametersSpec)
+	if !ok {
+		return nil, fmt.Errorf("unknown ResourceClaim.ParametersRef.Kind: %v", claim.Spec.ParametersRef.Kind)
+	}
 
-	ns, err := d.getNamespace(ctx, claimUid)
+	classParams, ok := classParameters.(*spacecrd.SpaceClassParametersSpec)
+	if !ok {
+		return nil, fmt.Errorf("unknown ResourceClass.ParametersRef.Kind: %v", class.ParametersRef.Kind)
+	}
+
+	// The kubelet sends every resource handle of a claim to the plugin but
+	// expects a single result per claim, so all spaces share one handle.
+	var handles []*spacecrd.ResourceHandle
+	for _, config := range spaceConfigs(claimParams) {
+		handle, err := d.allocateSpace(ctx, claim, claimParams, classParams, config)
+		if err != nil {
+			return nil, err
+		}
+		handles = append(handles, handle)
+	}
+
+	data, err := spacecrd.EncodeResourceHandles(handles)
 	if err != nil {
-		return nil, fmt.Errorf("unable to get namespace for claim: %v", err)
+		return nil, err
+	}
+
+	return &resourcev1.AllocationResult{
+		ResourceHandles: []resourcev1.ResourceHandle{{
+			DriverName: DriverName,
+			Data:       data,
+		}},
+		Shareable: shareable(claimParams, classParams),
+	}, nil
+}
+
+func (d *driver) allocateSpace(ctx context.Context, claim *resourcev1.ResourceClaim, claimParams *spacecrd.SpaceClaimParametersSpec, classParams *spacecrd.SpaceClassParametersSpec, config spacecrd.SpaceConfig) (*spacecrd.ResourceHandle, error) {
+	logger := klog.FromContext(ctx)
+
+	claimUid := string(claim.GetUID())
+
+	space, err := d.getOrCreateSpace(ctx, claim, config.Name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to record space for claim: %v", err)
 	}
 
-	if ns == nil {
-		claimParams, ok := claimParameters.(*spacecrd.SpaceClaimParametersSpec)
-		if !ok {
-			return nil, fmt.Errorf("unknown ResourceClaim.ParametersRef.Kind: %v", claim.Spec.ParametersRef.Kind)
+	ns, err := d.allocateNamespace(ctx, claimUid, config)
+	if err != nil {
+		if serr := d.setSpaceFailed(ctx, space, err); serr != nil {
+			logger.Error(serr, "unable to mark space as failed", "claimUid", claimUid, "space", config.Name)
 		}
+		return nil, err
+	}
+
+	err = d.ensureServiceAccount(ctx, claimUid, ns)
+	if err != nil {
+		if serr := d.setSpaceFailed(ctx, space, err); serr != nil {
+			logger.Error(serr, "unable to mark space as failed", "claimUid", claimUid, "space", config.Name)
+		}
+		return nil, err
+	}
+
+	err = d.setSpaceReady(ctx, space, ns, classParams.Lifetime)
+	if err != nil {
+		return nil, fmt.Errorf("unable to record space for claim: %v", err)
+	}
+
+	// Pass the allocation to the kubelet plugin. The namespace name will be used as a "device" identifier for CDI.
+	handle := &spacecrd.ResourceHandle{
+		SpaceName:               config.Name,
+		Namespace:               ns.GetName(),
+		ExpirationTime:          space.Status.ExpirationTime,
+		ServiceAccount:          spacecrd.SpaceServiceAccountName,
+		Role:                    spaceRoleName,
+		Adopted:                 space.Status.Adopted,
+		CredentialLayout:        claimParams.CredentialLayout,
+		ServiceAccountMountPath: claimParams.ServiceAccountMountPath,
+	}
+	if classParams.APIServer != nil {
+		handle.Cluster = classParams.APIServer.Endpoint
+	}
+	err = d.signResourceHandle(handle, claimUid)
+	if err != nil {
+		return nil, err
+	}
+
+	return handle, nil
+}
+
+// signResourceHandle signs the handle with the current signing key so the
+// kubelet plugin can tell it apart from one written by anyone else with
+// access to the claim status. It does nothing if signing is disabled.
+func (d *driver) signResourceHandle(handle *spacecrd.ResourceHandle, claimUid string) error {
+	if !d.handleKeys.Enabled() {
+		return nil
+	}
+	keys, err := d.handleKeys.LoadKeys()
+	if err != nil {
+		return err
+	}
+	key, ok := keys[d.signingKeyID]
+	if !ok {
+		return fmt.Errorf("handle signing key %q not found", d.signingKeyID)
+	}
+	return spacecrd.SignResourceHandle(handle, claimUid, d.signingKeyID, key)
+}
 
-		ns, err = d.createNamespace(ctx, claimUid, claimParams.GenerateName)
+func (d *driver) allocateNamespace(ctx context.Context, claimUid string, config spacecrd.SpaceConfig) (*corev1.Namespace, error) {
+	logger := klog.FromContext(ctx)
+
+	ns, err := d.getNamespace(ctx, claimUid, config.Name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get namespace for claim: %v", err)
+	}
+
+	if ns != nil {
+		logger.Info("found an existing namespace for a claim", "claimUid", claimUid, "space", config.Name)
+		return ns, nil
+	}
+
+	if config.NamespaceSelector != nil {
+		ns, err = d.adoptNamespace(ctx, claimUid, config)
 		if err != nil {
-			return nil, fmt.Errorf("namespace creation failed: %v", err)
+			return nil, fmt.Errorf("namespace adoption failed: %v", err)
 		}
-	} else {
-		logger.Info("found an existing namespace for a claim", "claimUid", claimUid)
+		return ns, nil
 	}
 
-	// Pass the namespace name to the kubelet plugin. This will be used as a "device" identifier for CDI.
-	result.ResourceHandles = []resourcev1.ResourceHandle{
-		{
-			DriverName: spacecrd.GroupName,
-			Data:       ns.GetName(),
-		},
+	ns, err = d.createNamespace(ctx, claimUid, config)
+	if err != nil {
+		return nil, fmt.Errorf("namespace creation failed: %v", err)
 	}
 
-	return result, nil
+	return ns, nil
 }
 
 func (d *driver) Deallocate(ctx context.Context, claim *resourcev1.ResourceClaim) error {
 	logger := klog.FromContext(ctx)
 	logger.Info("Deallocate", "claim", claim.Name)
 
+	ctx, done, err := d.startOperation(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
 	claimUid := string(claim.GetUID())
 
 	d.lock.Get(claimUid).Lock()
 	defer d.lock.Get(claimUid).Unlock()
 
-	ns, err := d.getNamespace(ctx, claimUid)
+	spaces, err := d.getSpaces(ctx, claimUid)
 	if err != nil {
-		return fmt.Errorf("unable to get namespace for claim: %v", err)
+		return fmt.Errorf("unable to get spaces for claim: %v", err)
 	}
 
-	if ns != nil {
+	for i := range spaces {
+		if spaces[i].Status.Phase == spacecrd.SpacePhaseTerminating {
+			continue
+		}
+		err = d.setSpaceTerminating(ctx, &spaces[i])
+		if err != nil {
+			return fmt.Errorf("unable to record space for claim: %v", err)
+		}
+	}
+
+	namespaces, err := d.getNamespaces(ctx, claimUid)
+	if err != nil {
+		return fmt.Errorf("unable to get namespaces for claim: %v", err)
+	}
+
+	// Deleted namespaces keep their label until they are gone, so a claim
+	// with remaining namespaces is deallocated again later and its Spaces
+	// stay Terminating until then.
+	terminating := 0
+	for i := range namespaces {
+		ns := &namespaces[i]
+		if ns.DeletionTimestamp != nil {
+			terminating++
+			continue
+		}
+		if ns.Labels[AdoptedLabel] == "true" {
+			err = d.deleteServiceAccount(ctx, ns)
+			if err != nil {
+				return fmt.Errorf("unable to delete service account for claim: %v", err)
+			}
+			err = d.releaseNamespace(ctx, ns)
+			if err != nil {
+				return fmt.Errorf("unable to release namespace for claim: %v", err)
+			}
+			continue
+		}
+
 		err = d.deleteNamespace(ctx, ns)
 		if err != nil {
 			return fmt.Errorf("unable to delete namespace for claim: %v", err)
 		}
+		terminating++
+	}
+
+	if terminating > 0 {
+		return fmt.Errorf("waiting for %d namespaces of claim to be deleted", terminating)
+	}
+
+	for i := range spaces {
+		err = d.deleteSpace(ctx, &spaces[i])
+		if err != nil {
+			return fmt.Errorf("unable to delete space for claim: %v", err)
+		}
 	}
 
 	return nil
@@ -167,9 +471,112 @@ func (d *driver) UnsuitableNodes(ctx context.Context, pod *corev1.Pod, cas []*co
 	return nil
 }
 
-func (d *driver) getNamespace(ctx context.Context, claimUid string) (*corev1.Namespace, error) {
+// spaceConfigs returns the spaces requested by a claim. Claims which don't
+// list any spaces are allocated a single, unnamed space.
+func spaceConfigs(params *spacecrd.SpaceClaimParametersSpec) []spacecrd.SpaceConfig {
+	if len(params.Spaces) == 0 {
+		return []spacecrd.SpaceConfig{{
+			GenerateName:      params.GenerateName,
+			NamespaceSelector: params.NamespaceSelector,
+		}}
+	}
+
+	configs := make([]spacecrd.SpaceConfig, 0, len(params.Spaces))
+	for _, config := range params.Spaces {
+		if config.GenerateName == "" {
+			config.GenerateName = params.GenerateName
+		}
+		if config.NamespaceSelector == nil {
+			config.NamespaceSelector = params.NamespaceSelector
+		}
+		configs = append(configs, config)
+	}
+	return configs
+}
+
+// shareable resolves whether an allocation may be reserved by more than one
+// pod. The claim setting takes precedence over the class default.
+func shareable(claimParams *spacecrd.SpaceClaimParametersSpec, classParams *spacecrd.SpaceClassParametersSpec) bool {
+	if claimParams.Shareable != nil {
+		return *claimParams.Shareable
+	}
+	if classParams.Shareable != nil {
+		return *classParams.Shareable
+	}
+	return true
+}
+
+func validateClassParameters(params *spacecrd.SpaceClassParametersSpec) error {
+	if params.APIServer != nil {
+		u, err := url.Parse(params.APIServer.Endpoint)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("invalid API server endpoint '%v': must be an https URL", params.APIServer.Endpoint)
+		}
+	}
+	if params.Lifetime != nil && params.Lifetime.Duration <= 0 {
+		return fmt.Errorf("invalid lifetime '%v': must be positive", params.Lifetime.Duration)
+	}
+	return nil
+}
+
+// envPrefixRegexp matches the prefixes allowed for the environment variables
+// the kubelet plugin exposes to containers.
+var envPrefixRegexp = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+
+func validateClaimParameters(params *spacecrd.SpaceClaimParametersSpec) error {
+	if params.EnvPrefix != "" && !envPrefixRegexp.MatchString(params.EnvPrefix) {
+		return fmt.Errorf("invalid env prefix '%v': must consist of upper case letters, digits and underscores and must not start with a digit", params.EnvPrefix)
+	}
+
+	switch params.CredentialLayout {
+	case "", spacecrd.CredentialLayoutKubeconfig:
+	case spacecrd.CredentialLayoutServiceAccount, spacecrd.CredentialLayoutBoth:
+		// Every space would be mounted at the same path.
+		if len(params.Spaces) > 1 {
+			return fmt.Errorf("credential layout '%v' cannot be used with more than one space", params.CredentialLayout)
+		}
+	default:
+		return fmt.Errorf("invalid credential layout '%v'", params.CredentialLayout)
+	}
+	if params.ServiceAccountMountPath != "" && !path.IsAbs(params.ServiceAccountMountPath) {
+		return fmt.Errorf("invalid service account mount path '%v': must be absolute", params.ServiceAccountMountPath)
+	}
+
+	if params.NamespaceSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(params.NamespaceSelector); err != nil {
+			return fmt.Errorf("invalid namespace selector: %v", err)
+		}
+	}
+
+	names := make(map[string]bool)
+	for _, config := range params.Spaces {
+		if config.NamespaceSelector != nil {
+			if _, err := metav1.LabelSelectorAsSelector(config.NamespaceSelector); err != nil {
+				return fmt.Errorf("invalid namespace selector for space '%v': %v", config.Name, err)
+			}
+		}
+		if errs := validation.IsDNS1123Label(config.Name); len(errs) > 0 {
+			return fmt.Errorf("invalid space name '%v': %v", config.Name, strings.Join(errs, ", "))
+		}
+		if names[config.Name] {
+			return fmt.Errorf("duplicate space name '%v'", config.Name)
+		}
+		names[config.Name] = true
+	}
+	return nil
+}
+
+// getNamespace returns the namespace allocated for one of a claim's spaces.
+// The unnamed space of a single-space claim carries no space label.
+func (d *driver) getNamespace(ctx context.Context, claimUid string, spaceName string) (*corev1.Namespace, error) {
+	selector := ResourceClaimLabel + "=" + claimUid
+	if spaceName == "" {
+		selector += ",!" + SpaceNameLabel
+	} else {
+		selector += "," + SpaceNameLabel + "=" + spaceName
+	}
+
 	api := d.clientsets.Core.CoreV1().Namespaces()
-	selector := ResourceClaimLabel + "=" + string(claimUid)
 	namespaces, err := api.List(ctx, metav1.ListOptions{LabelSelector: selector})
 	if err != nil {
 		return nil, fmt.Errorf("unable to list namespaces: %v", err)
@@ -178,16 +585,33 @@ func (d *driver) getNamespace(ctx context.Context, claimUid string) (*corev1.Nam
 	if len(namespaces.Items) == 0 {
 		return nil, nil
 	} else if len(namespaces.Items) > 1 {
-		return nil, fmt.Errorf("more than one namespace found for claimUid: %s", claimUid)
+		return nil, fmt.Errorf("more than one namespace found for claimUid %s and space '%s'", claimUid, spaceName)
 	}
 
 	return &namespaces.Items[0], nil
 }
 
-func (d *driver) createNamespace(ctx context.Context, claimUid string, generateName string) (*corev1.Namespace, error) {
+func (d *driver) getNamespaces(ctx context.Context, claimUid string) ([]corev1.Namespace, error) {
+	api := d.clientsets.Core.CoreV1().Namespaces()
+	selector := ResourceClaimLabel + "=" + claimUid
+	namespaces, err := api.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list namespaces: %v", err)
+	}
+
+	return namespaces.Items, nil
+}
+
+func (d *driver) createNamespace(ctx context.Context, claimUid string, config spacecrd.SpaceConfig) (*corev1.Namespace, error) {
 	logger := klog.FromContext(ctx)
 
-	labels := map[string]string{ResourceClaimLabel: string(claimUid)}
+	labels := map[string]string{ResourceClaimLabel: claimUid}
+	generateName := config.GenerateName
+	if config.Name != "" {
+		labels[SpaceNameLabel] = config.Name
+		generateName += config.Name + "-"
+	}
+
 	spec := &corev1.Namespace{
 		ObjectMeta: metav1.ObjectMeta{
 			GenerateName: generateName,
@@ -201,10 +625,112 @@ func (d *driver) createNamespace(ctx context.Context, claimUid string, generateN
 		return nil, fmt.Errorf("unable to create namespace: %v", err)
 	}
 
-	logger.Info("created namespace", "claimUid", claimUid, "namespace", ns.Name)
+	logger.Info("created namespace", "claimUid", claimUid, "space", config.Name, "namespace", ns.Name)
 	return ns, nil
 }
 
+// adoptNamespace claims one of the pre-approved namespaces matching the
+// selector. Only namespaces labelled as adoptable and not yet held by another
+// claim are considered. The claim label acts as an exclusive lease: it is
+// written with an optimistic-concurrency update, so of two claims racing
+// for the same namespace only one wins and the other moves on.
+func (d *driver) adoptNamespace(ctx context.Context, claimUid string, config spacecrd.SpaceConfig) (*corev1.Namespace, error) {
+	logger := klog.FromContext(ctx)
+
+	selector, err := metav1.LabelSelectorAsSelector(config.NamespaceSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace selector: %v", err)
+	}
+	adoptable, err := labels.NewRequirement(AdoptableLabel, selection.Equals, []string{"true"})
+	if err != nil {
+		return nil, err
+	}
+	unclaimed, err := labels.NewRequirement(ResourceClaimLabel, selection.DoesNotExist, nil)
+	if err != nil {
+		return nil, err
+	}
+	selector = selector.Add(*adoptable, *unclaimed)
+
+	api := d.clientsets.Core.CoreV1().Namespaces()
+	namespaces, err := api.List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list namespaces: %v", err)
+	}
+
+	sort.Slice(namespaces.Items, func(i, j int) bool {
+		return namespaces.Items[i].Name < namespaces.Items[j].Name
+	})
+
+	for i := range namespaces.Items {
+		ns := &namespaces.Items[i]
+		if ns.Status.Phase == corev1.NamespaceTerminating {
+			continue
+		}
+		if heldByOtherDriver(ns) {
+			logger.V(4).Info("namespace is held by another instance of the driver", "namespace", ns.Name)
+			continue
+		}
+
+		ns.Labels[ResourceClaimLabel] = claimUid
+		ns.Labels[AdoptedLabel] = "true"
+		if config.Name != "" {
+			ns.Labels[SpaceNameLabel] = config.Name
+		}
+
+		adopted, err := api.Update(ctx, ns, metav1.UpdateOptions{})
+		if errors.IsConflict(err) {
+			logger.V(4).Info("namespace was modified concurrently, trying the next one", "namespace", ns.Name)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to adopt namespace %s: %v", ns.Name, err)
+		}
+
+		logger.Info("adopted namespace", "claimUid", claimUid, "space", config.Name, "namespace", adopted.Name)
+		return adopted, nil
+	}
+
+	return nil, fmt.Errorf("no available namespace matches selector '%v'", selector)
+}
+
+// heldByOtherDriver reports whether an instance of the driver with another
+// name holds the namespace. Its labels have a different prefix, so the
+// selector of adoptNamespace does not exclude the namespace if it is
+// adoptable for both instances. Only keys which setDriverName could have
+// produced for some valid driver name count.
+func heldByOtherDriver(ns *corev1.Namespace) bool {
+	for key := range ns.Labels {
+		prefix, name, found := strings.Cut(key, "/")
+		if !found || name != resourceClaimLabelName || prefix == DriverName {
+			continue
+		}
+		other := flags.DriverNameConfig{Name: prefix}
+		if other.Validate() == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseNamespace returns an adopted namespace to the pool.
+func (d *driver) releaseNamespace(ctx context.Context, ns *corev1.Namespace) error {
+	logger := klog.FromContext(ctx)
+
+	released := ns.DeepCopy()
+	delete(released.Labels, ResourceClaimLabel)
+	delete(released.Labels, SpaceNameLabel)
+	delete(released.Labels, AdoptedLabel)
+
+	namespaces := d.clientsets.Core.CoreV1().Namespaces()
+	_, err := namespaces.Update(ctx, released, metav1.UpdateOptions{})
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Released namespace", "namespace", ns.Name)
+	return nil
+}
+
 func (d *driver) deleteNamespace(ctx context.Context, ns *corev1.Namespace) error {
 	logger := klog.FromContext(ctx)
 
@@ -0,0 +1,380 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	resourcev1 "k8s.io/api/resource/v1alpha2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/rand"
+	corefake "k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/ptr"
+
+	spacecrd "sigs.k8s.io/dra-example-driver/api/example.com/resource/space/v1alpha1"
+	examplefake "sigs.k8s.io/dra-example-driver/pkg/example.com/resource/clientset/versioned/fake"
+	"sigs.k8s.io/dra-example-driver/pkg/flags"
+)
+
+func TestHeldByOtherDriver(t *testing.T) {
+	const (
+		driverA = "space.resource.example.com"
+		driverB = "team-b.space.resource.example.com"
+	)
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		// held is whether driverA and driverB each see the namespace as
+		// held by the other.
+		held map[string]bool
+	}{
+		{
+			name:   "unlabeled",
+			labels: nil,
+			held:   map[string]bool{driverA: false, driverB: false},
+		},
+		{
+			name: "adoptable by both",
+			labels: map[string]string{
+				driverA + "/adoptable": "true",
+				driverB + "/adoptable": "true",
+			},
+			held: map[string]bool{driverA: false, driverB: false},
+		},
+		{
+			name: "held by A",
+			labels: map[string]string{
+				driverA + "/adoptable":     "true",
+				driverB + "/adoptable":     "true",
+				driverA + "/resourceclaim": "uid-a",
+				driverA + "/adopted":       "true",
+			},
+			held: map[string]bool{driverA: false, driverB: true},
+		},
+		{
+			name: "held by B",
+			labels: map[string]string{
+				driverB + "/resourceclaim": "uid-b",
+				driverB + "/space":         "dev",
+			},
+			held: map[string]bool{driverA: true, driverB: false},
+		},
+		{
+			name: "similar label of another tool",
+			labels: map[string]string{
+				driverB + "/resourceclaims":                 "uid-b",
+				driverB + "/resourceclaim-old":              "uid-b",
+				"resourceclaim":                             "uid-b",
+				"example.com/" + driverB + "/resourceclaim": "uid-b",
+			},
+			held: map[string]bool{driverA: false, driverB: false},
+		},
+		{
+			name: "prefix which is no driver name",
+			labels: map[string]string{
+				"Team_B/resourceclaim": "uid-b",
+			},
+			held: map[string]bool{driverA: false, driverB: false},
+		},
+	}
+
+	previous := DriverName
+	defer setDriverName(previous)
+	for _, tc := range tests {
+		for _, driverName := range []string{driverA, driverB} {
+			t.Run(tc.name+"/"+driverName, func(t *testing.T) {
+				setDriverName(driverName)
+				ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns", Labels: tc.labels}}
+				if held := heldByOtherDriver(ns); held != tc.held[driverName] {
+					t.Errorf("expected held by another driver to be %v, got %v", tc.held[driverName], held)
+				}
+			})
+		}
+	}
+}
+
+func TestAbort(t *testing.T) {
+	opCtx, cancelOps := context.WithCancel(context.Background())
+	d := &driver{opCtx: opCtx, cancelOps: cancelOps}
+	ctx := context.Background()
+
+	// An operation which would outlast any drain timeout.
+	opCtx, done, err := d.startOperation(ctx)
+	if err != nil {
+		t.Fatalf("unable to start operation: %v", err)
+	}
+	canceled := make(chan struct{})
+	go func() {
+		<-opCtx.Done()
+		close(canceled)
+		done()
+	}()
+
+	aborted := make(chan struct{})
+	go func() {
+		d.Abort(ctx)
+		close(aborted)
+	}()
+	select {
+	case <-aborted:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Abort did not cancel the running operation")
+	}
+	select {
+	case <-canceled:
+	default:
+		t.Errorf("Abort returned before the operation gave up")
+	}
+
+	_, _, err = d.startOperation(ctx)
+	if err == nil {
+		t.Errorf("expected new operations to be refused after Abort")
+	}
+}
+
+func TestValidateClaimParametersEnvPrefix(t *testing.T) {
+	tests := []struct {
+		envPrefix string
+		valid     bool
+	}{
+		{envPrefix: "", valid: true},
+		{envPrefix: "SPACE", valid: true},
+		{envPrefix: "MY_SPACE_2", valid: true},
+		{envPrefix: "_SPACE", valid: true},
+		{envPrefix: "2SPACE", valid: false},
+		{envPrefix: "space", valid: false},
+		{envPrefix: "MY-SPACE", valid: false},
+		{envPrefix: "MY SPACE", valid: false},
+		{envPrefix: "SPACE=X", valid: false},
+	}
+	for _, tc := range tests {
+		params := spacecrd.DefaultSpaceClaimParametersSpec()
+		params.EnvPrefix = tc.envPrefix
+		err := validateClaimParameters(params)
+		if (err == nil) != tc.valid {
+			t.Errorf("env prefix %q: expected valid=%v, got %v", tc.envPrefix, tc.valid, err)
+		}
+	}
+}
+
+func TestShareable(t *testing.T) {
+	tests := []struct {
+		name      string
+		claim     *bool
+		class     *bool
+		shareable bool
+	}{
+		{name: "unset", shareable: true},
+		{name: "class exclusive", class: ptr.To(false), shareable: false},
+		{name: "class shareable", class: ptr.To(true), shareable: true},
+		{name: "claim exclusive", claim: ptr.To(false), shareable: false},
+		{name: "claim exclusive in shareable class", claim: ptr.To(false), class: ptr.To(true), shareable: false},
+		{name: "claim shareable in exclusive class", claim: ptr.To(true), class: ptr.To(false), shareable: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			claimParams := &spacecrd.SpaceClaimParametersSpec{Shareable: tc.claim}
+			classParams := &spacecrd.SpaceClassParametersSpec{Shareable: tc.class}
+			if shareable := shareable(claimParams, classParams); shareable != tc.shareable {
+				t.Errorf("expected shareable to be %v, got %v", tc.shareable, shareable)
+			}
+		})
+	}
+}
+
+// newTestDriver returns a driver which uses fake clientsets. The fake
+// clientset does not generate names, so namespaces get a random suffix
+// here, and SpaceClassParameters are created through the client since
+// the object tracker guesses the wrong resource for them.
+func newTestDriver(t *testing.T, classParams ...*spacecrd.SpaceClassParameters) *driver {
+	t.Helper()
+	setDriverName(spacecrd.GroupName)
+
+	core := corefake.NewSimpleClientset()
+	core.PrependReactor("create", "namespaces", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		ns := action.(k8stesting.CreateAction).GetObject().(*corev1.Namespace)
+		if ns.Name == "" && ns.GenerateName != "" {
+			ns.Name = ns.GenerateName + rand.String(5)
+		}
+		return false, nil, nil
+	})
+
+	example := examplefake.NewSimpleClientset()
+	for _, params := range classParams {
+		_, err := example.SpaceV1alpha1().SpaceClassParameters().Create(context.Background(), params, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return NewDriver(&Config{
+		flags:      &Flags{},
+		clientSets: flags.ClientSets{Core: core, Example: example},
+	})
+}
+
+func TestGetClassParameters(t *testing.T) {
+	exclusive := &spacecrd.SpaceClassParameters{
+		ObjectMeta: metav1.ObjectMeta{Name: "exclusive"},
+		Spec:       spacecrd.SpaceClassParametersSpec{Shareable: ptr.To(false)},
+	}
+	invalid := &spacecrd.SpaceClassParameters{
+		ObjectMeta: metav1.ObjectMeta{Name: "invalid"},
+		Spec:       spacecrd.SpaceClassParametersSpec{Lifetime: &metav1.Duration{}},
+	}
+	ref := func(group, kind, name string) *resourcev1.ResourceClassParametersReference {
+		return &resourcev1.ResourceClassParametersReference{APIGroup: group, Kind: kind, Name: name}
+	}
+
+	tests := []struct {
+		name      string
+		ref       *resourcev1.ResourceClassParametersReference
+		shareable bool
+		valid     bool
+	}{
+		{
+			name:      "defaults",
+			shareable: true,
+			valid:     true,
+		},
+		{
+			name:      "SpaceClassParameters",
+			ref:       ref(DriverAPIGroup, spacecrd.SpaceClassParametersKind, "exclusive"),
+			shareable: false,
+			valid:     true,
+		},
+		{
+			name: "missing SpaceClassParameters",
+			ref:  ref(DriverAPIGroup, spacecrd.SpaceClassParametersKind, "missing"),
+		},
+		{
+			name: "invalid SpaceClassParameters",
+			ref:  ref(DriverAPIGroup, spacecrd.SpaceClassParametersKind, "invalid"),
+		},
+		{
+			name: "other API group",
+			ref:  ref("gpu.resource.example.com", spacecrd.SpaceClassParametersKind, "exclusive"),
+		},
+		{
+			name: "unknown kind",
+			ref:  ref(DriverAPIGroup, "GpuClassParameters", "exclusive"),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := newTestDriver(t, exclusive, invalid)
+			class := &resourcev1.ResourceClass{
+				ObjectMeta:    metav1.ObjectMeta{Name: "space.example.com"},
+				DriverName:    DriverName,
+				ParametersRef: tc.ref,
+			}
+
+			params, err := d.GetClassParameters(context.Background(), class)
+			switch {
+			case !tc.valid && err == nil:
+				t.Fatalf("expected class parameters to be rejected, got %+v", params)
+			case tc.valid && err != nil:
+				t.Fatalf("unable to get class parameters: %v", err)
+			}
+			if !tc.valid {
+				return
+			}
+			if shareable := ptr.Deref(params.(*spacecrd.SpaceClassParametersSpec).Shareable, true); shareable != tc.shareable {
+				t.Errorf("expected shareable to be %v, got %v", tc.shareable, shareable)
+			}
+		})
+	}
+}
+
+func TestAllocateShareable(t *testing.T) {
+	tests := []struct {
+		name      string
+		claim     *bool
+		class     *bool
+		shareable bool
+	}{
+		{name: "default", shareable: true},
+		{name: "exclusive class", class: ptr.To(false), shareable: false},
+		{name: "exclusive claim", claim: ptr.To(false), shareable: false},
+		{name: "shareable claim in exclusive class", claim: ptr.To(true), class: ptr.To(false), shareable: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			classParams := &spacecrd.SpaceClassParameters{
+				ObjectMeta: metav1.ObjectMeta{Name: "space"},
+				Spec:       spacecrd.SpaceClassParametersSpec{Shareable: tc.class},
+			}
+			d := newTestDriver(t, classParams)
+
+			claimParams := &spacecrd.SpaceClaimParameters{
+				ObjectMeta: metav1.ObjectMeta{Name: "space", Namespace: "default"},
+				Spec:       *spacecrd.DefaultSpaceClaimParametersSpec(),
+			}
+			claimParams.Spec.Shareable = tc.claim
+			_, err := d.clientsets.Example.SpaceV1alpha1().SpaceClaimParameters("default").Create(ctx, claimParams, metav1.CreateOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			class := &resourcev1.ResourceClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "space.example.com"},
+				DriverName: DriverName,
+				ParametersRef: &resourcev1.ResourceClassParametersReference{
+					APIGroup: DriverAPIGroup,
+					Kind:     spacecrd.SpaceClassParametersKind,
+					Name:     "space",
+				},
+			}
+			claim := &resourcev1.ResourceClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "claim", Namespace: "default", UID: "uid"},
+				Spec: resourcev1.ResourceClaimSpec{
+					ResourceClassName: class.Name,
+					ParametersRef: &resourcev1.ResourceClaimParametersReference{
+						APIGroup: DriverAPIGroup,
+						Kind:     spacecrd.SpaceClaimParametersKind,
+						Name:     "space",
+					},
+				},
+			}
+
+			classParameters, err := d.GetClassParameters(ctx, class)
+			if err != nil {
+				t.Fatalf("unable to get class parameters: %v", err)
+			}
+			claimParameters, err := d.GetClaimParameters(ctx, claim, class, classParameters)
+			if err != nil {
+				t.Fatalf("unable to get claim parameters: %v", err)
+			}
+			result, err := d.allocate(ctx, claim, claimParameters, class, classParameters, "node")
+			if err != nil {
+				t.Fatalf("unable to allocate claim: %v", err)
+			}
+			if result.Shareable != tc.shareable {
+				t.Errorf("expected shareable to be %v, got %v", tc.shareable, result.Shareable)
+			}
+		})
+	}
+}
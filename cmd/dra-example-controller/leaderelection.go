@@ -0,0 +1,127 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+const (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// errLostLeadership is the cause of the cancellation of the context passed
+// to run by RunLeaderElected when leadership is lost.
+var errLostLeadership = errors.New("lost leadership")
+
+// RunLeaderElected calls run once this instance holds the lease named after
+// the driver, with a context which is canceled when ctx is or leadership is
+// lost, in which case its cause is errLostLeadership. The lease is only
+// released after run has returned, so that the next leader does not start
+// allocating while this one is still draining.
+func RunLeaderElected(ctx context.Context, config *Config, run func(ctx context.Context)) error {
+	logger := klog.FromContext(ctx)
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("unable to get hostname: %v", err)
+	}
+	id := hostname + "_" + string(uuid.NewUUID())
+
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Namespace: config.flags.leaderElectionNamespace,
+			Name:      DriverName,
+		},
+		Client: config.clientSets.Core.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: id,
+		},
+	}
+
+	leading := make(chan context.Context, 1)
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   renewDeadline,
+		RetryPeriod:     retryPeriod,
+		ReleaseOnCancel: true,
+		Name:            DriverName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(leaderCtx context.Context) {
+				leading <- leaderCtx
+			},
+			OnStoppedLeading: func() {
+				logger.Info("Stopped leading", "identity", id)
+			},
+			OnNewLeader: func(identity string) {
+				logger.Info("New leader elected", "identity", identity)
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to create leader elector: %v", err)
+	}
+
+	// The elector gets its own context, which is canceled once run has
+	// returned or if this instance never became the leader.
+	electorCtx, stopElector := context.WithCancel(klog.NewContext(context.Background(), logger))
+	defer stopElector()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		elector.Run(electorCtx)
+	}()
+
+	logger.Info("Waiting for leadership", "lease", klog.KObj(&lock.LeaseMeta), "identity", id)
+	select {
+	case <-ctx.Done():
+		stopElector()
+		<-stopped
+		return nil
+	case leaderCtx := <-leading:
+		runCtx, cancel := context.WithCancelCause(ctx)
+		go func() {
+			select {
+			case <-leaderCtx.Done():
+				cancel(errLostLeadership)
+			case <-runCtx.Done():
+			}
+		}()
+		run(runCtx)
+		cancel(nil)
+
+		stopElector()
+		<-stopped
+		if ctx.Err() == nil {
+			return errLostLeadership
+		}
+		return nil
+	}
+}
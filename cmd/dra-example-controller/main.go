@@ -18,12 +18,16 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/http/pprof"
 	"os"
+	"os/signal"
 	"path"
+	"syscall"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -44,8 +48,16 @@ import (
 type Flags struct {
 	kubeClientConfig flags.KubeClientConfig
 	loggingConfig    *flags.LoggingConfig
+	handleKeys       flags.HandleKeysConfig
+	driverName       flags.DriverNameConfig
 
-	workers int
+	workers      int
+	drainTimeout time.Duration
+
+	leaderElect             bool
+	leaderElectionNamespace string
+
+	handleSigningKeyID string
 
 	httpEndpoint string
 	metricsPath  string
@@ -77,6 +89,34 @@ func newApp() *cli.App {
 			Destination: &flags.workers,
 			EnvVars:     []string{"WORKERS"},
 		},
+		&cli.DurationFlag{
+			Name:        "drain-timeout",
+			Usage:       "How long to wait on shutdown for running allocations and deallocations to finish before they are canceled. They are canceled right away if leadership is lost.",
+			Value:       20 * time.Second,
+			Destination: &flags.drainTimeout,
+			EnvVars:     []string{"DRAIN_TIMEOUT"},
+		},
+		&cli.BoolFlag{
+			Category:    "Leader election:",
+			Name:        "leader-elect",
+			Usage:       "Only run the controller while holding a lease named after the driver, so that several replicas can be deployed.",
+			Destination: &flags.leaderElect,
+			EnvVars:     []string{"LEADER_ELECT"},
+		},
+		&cli.StringFlag{
+			Category:    "Leader election:",
+			Name:        "leader-elect-namespace",
+			Usage:       "The `namespace` of the leader election lease. Defaults to the namespace the controller runs in.",
+			Destination: &flags.leaderElectionNamespace,
+			EnvVars:     []string{"LEADER_ELECT_NAMESPACE", "NAMESPACE"},
+		},
+		&cli.StringFlag{
+			Category:    "Resource handle signing:",
+			Name:        "handle-signing-key-id",
+			Usage:       "ID of the key in the handle keys directory which new resource handles are signed with. Required if handle signing is enabled.",
+			Destination: &flags.handleSigningKeyID,
+			EnvVars:     []string{"HANDLE_SIGNING_KEY_ID"},
+		},
 
 		&cli.StringFlag{
 			Category:    "HTTP server:",
@@ -102,7 +142,9 @@ func newApp() *cli.App {
 		},
 	}
 
+	cliFlags = append(cliFlags, flags.driverName.Flags()...)
 	cliFlags = append(cliFlags, flags.kubeClientConfig.Flags()...)
+	cliFlags = append(cliFlags, flags.handleKeys.Flags()...)
 	cliFlags = append(cliFlags, flags.loggingConfig.Flags()...)
 
 	app := &cli.App{
@@ -115,10 +157,31 @@ func newApp() *cli.App {
 			if c.Args().Len() > 0 {
 				return fmt.Errorf("arguments not supported: %v", c.Args().Slice())
 			}
+			err := flags.driverName.Validate()
+			if err != nil {
+				return err
+			}
+			setDriverName(flags.driverName.Name)
+			if flags.drainTimeout < 0 {
+				return fmt.Errorf("drain-timeout must not be negative: %v", flags.drainTimeout)
+			}
+			if flags.leaderElect && flags.leaderElectionNamespace == "" {
+				return fmt.Errorf("--leader-elect-namespace is required with --leader-elect")
+			}
+			if flags.handleKeys.Enabled() {
+				keys, err := flags.handleKeys.LoadKeys()
+				if err != nil {
+					return err
+				}
+				if _, ok := keys[flags.handleSigningKeyID]; !ok {
+					return fmt.Errorf("--handle-signing-key-id must name one of the handle keys")
+				}
+			}
 			return flags.loggingConfig.Apply()
 		},
 		Action: func(c *cli.Context) error {
-			ctx := c.Context
+			ctx, stop := signal.NotifyContext(c.Context, syscall.SIGINT, syscall.SIGTERM)
+			defer stop()
 			mux := http.NewServeMux()
 
 			clientSets, err := flags.kubeClientConfig.NewClientSets()
@@ -132,8 +195,9 @@ func newApp() *cli.App {
 				clientSets: clientSets,
 			}
 
+			var server *http.Server
 			if flags.httpEndpoint != "" {
-				err = SetupHTTPEndpoint(ctx, config)
+				server, err = SetupHTTPEndpoint(ctx, config)
 				if err != nil {
 					return fmt.Errorf("create http endpoint: %v", err)
 				}
@@ -144,6 +208,17 @@ func newApp() *cli.App {
 				return fmt.Errorf("start controller: %v", err)
 			}
 
+			// Metrics stay available while draining, so the server is
+			// only shut down once the controller is done.
+			if server != nil {
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+				defer cancel()
+				err = server.Shutdown(shutdownCtx)
+				if err != nil {
+					return fmt.Errorf("shut down http endpoint: %v", err)
+				}
+			}
+
 			return nil
 		},
 	}
@@ -151,7 +226,11 @@ func newApp() *cli.App {
 	return app
 }
 
-func SetupHTTPEndpoint(ctx context.Context, config *Config) error {
+// httpShutdownTimeout bounds how long the HTTP server waits for open
+// requests on shutdown.
+const httpShutdownTimeout = 5 * time.Second
+
+func SetupHTTPEndpoint(ctx context.Context, config *Config) (*http.Server, error) {
 	logger := klog.FromContext(ctx)
 	logger = klog.LoggerWithName(logger, "http-server")
 	if config.flags.metricsPath != "" {
@@ -187,26 +266,56 @@ func SetupHTTPEndpoint(ctx context.Context, config *Config) error {
 
 	listener, err := net.Listen("tcp", config.flags.httpEndpoint)
 	if err != nil {
-		return fmt.Errorf("listen on HTTP endpoint: %v", err)
+		return nil, fmt.Errorf("listen on HTTP endpoint: %v", err)
 	}
 
+	server := &http.Server{Handler: config.mux}
 	go func() {
 		logger.Info("Starting HTTP server", "endpoint", config.flags.httpEndpoint)
-		err := http.Serve(listener, config.mux)
-		if err != nil {
+		err := server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			logger.Error(err, "HTTP server failed")
 			klog.FlushAndExit(klog.ExitFlushTimeout, 1)
 		}
 	}()
 
-	return nil
+	return server, nil
 }
 
+// StartController runs the controller until ctx is canceled, then drains
+// the operations it has started. With leader election, it only runs while
+// this instance is the leader, and cancels its operations right away once
+// it is not.
 func StartController(ctx context.Context, config *Config) error {
 	driver := NewDriver(config)
-	informerFactory := informers.NewSharedInformerFactory(config.clientSets.Core, 0 /* resync period */)
-	ctrl := controller.New(ctx, DriverAPIGroup, driver, config.clientSets.Core, informerFactory)
-	informerFactory.Start(ctx.Done())
-	ctrl.Run(config.flags.workers)
-	return nil
+
+	var drainErr error
+	run := func(ctx context.Context) {
+		informerFactory := informers.NewSharedInformerFactory(config.clientSets.Core, 0 /* resync period */)
+		ctrl := controller.New(ctx, DriverName, driver, config.clientSets.Core, informerFactory)
+		informerFactory.Start(ctx.Done())
+		ctrl.Run(config.flags.workers)
+		informerFactory.Shutdown()
+
+		// The controller has stopped handing out work, but allocations
+		// already handed to the driver may still be running. Without
+		// leadership they are canceled, since the lease may expire and
+		// another instance take over while they finish.
+		if errors.Is(context.Cause(ctx), errLostLeadership) {
+			driver.Abort(ctx)
+			return
+		}
+		drainErr = driver.Drain(ctx, config.flags.drainTimeout)
+	}
+
+	if !config.flags.leaderElect {
+		run(ctx)
+		return drainErr
+	}
+
+	err := RunLeaderElected(ctx, config, run)
+	if err != nil {
+		return err
+	}
+	return drainErr
 }
@@ -0,0 +1,181 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	spacecrd "sigs.k8s.io/dra-example-driver/api/example.com/resource/space/v1alpha1"
+)
+
+// spaceRoleName is the ClusterRole granted to the space's ServiceAccount
+// within its namespace.
+const spaceRoleName = "admin"
+
+// ensureServiceAccount creates the ServiceAccount for which the kubelet
+// plugin requests tokens, and binds it to the admin role of the namespace.
+// Both are labelled with the claim so that they can be told apart from
+// objects already present in adopted namespaces. Existing objects of the
+// same name are only accepted if they were created for the claim. Those
+// left behind by an earlier claim are replaced, any others are an error.
+func (d *driver) ensureServiceAccount(ctx context.Context, claimUid string, ns *corev1.Namespace) error {
+	logger := klog.FromContext(ctx)
+
+	meta := metav1.ObjectMeta{
+		Name:      spacecrd.SpaceServiceAccountName,
+		Namespace: ns.Name,
+		Labels:    map[string]string{ResourceClaimLabel: claimUid},
+	}
+
+	sa := &corev1.ServiceAccount{ObjectMeta: meta}
+	serviceAccounts := d.clientsets.Core.CoreV1().ServiceAccounts(ns.Name)
+	_, err := serviceAccounts.Create(ctx, sa, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		var existing *corev1.ServiceAccount
+		existing, err = serviceAccounts.Get(ctx, sa.Name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to get service account: %v", err)
+		}
+		var replace bool
+		replace, err = spaceObjectReplaceable(claimUid, "service account", &existing.ObjectMeta)
+		if err != nil {
+			return err
+		}
+		if replace {
+			logger.Info("Replacing service account of an earlier claim", "namespace", ns.Name, "previousClaimUid", existing.Labels[ResourceClaimLabel])
+			err = serviceAccounts.Delete(ctx, sa.Name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &existing.UID}})
+			if err == nil || errors.IsNotFound(err) {
+				_, err = serviceAccounts.Create(ctx, sa, metav1.CreateOptions{})
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("unable to create service account: %v", err)
+	}
+
+	binding := &rbacv1.RoleBinding{
+		ObjectMeta: meta,
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "ClusterRole",
+			Name:     spaceRoleName,
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      spacecrd.SpaceServiceAccountName,
+				Namespace: ns.Name,
+			},
+		},
+	}
+	bindings := d.clientsets.Core.RbacV1().RoleBindings(ns.Name)
+	_, err = bindings.Create(ctx, binding, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		var existing *rbacv1.RoleBinding
+		existing, err = bindings.Get(ctx, binding.Name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("unable to get role binding: %v", err)
+		}
+		var replace bool
+		replace, err = spaceObjectReplaceable(claimUid, "role binding", &existing.ObjectMeta)
+		if err != nil {
+			return err
+		}
+		// The role of a binding cannot be changed, so one of the claim
+		// which grants something else is replaced as well.
+		if !replace && (existing.RoleRef != binding.RoleRef || !reflect.DeepEqual(existing.Subjects, binding.Subjects)) {
+			replace = true
+		}
+		if replace {
+			logger.Info("Replacing role binding", "namespace", ns.Name, "previousClaimUid", existing.Labels[ResourceClaimLabel])
+			err = bindings.Delete(ctx, binding.Name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{UID: &existing.UID}})
+			if err == nil || errors.IsNotFound(err) {
+				_, err = bindings.Create(ctx, binding, metav1.CreateOptions{})
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("unable to create role binding: %v", err)
+	}
+
+	logger.V(4).Info("ensured service account", "claimUid", claimUid, "namespace", ns.Name)
+	return nil
+}
+
+// spaceObjectReplaceable checks an object which is in the way of one to be
+// created for a claim. Objects created for the claim are kept and those
+// created for another claim are replaced. Objects which the driver did not
+// create are an error, since they may be in use.
+func spaceObjectReplaceable(claimUid string, kind string, existing *metav1.ObjectMeta) (bool, error) {
+	owner, ok := existing.Labels[ResourceClaimLabel]
+	switch {
+	case !ok:
+		return false, fmt.Errorf("%s %s/%s already exists and was not created for claim %s", kind, existing.Namespace, existing.Name, claimUid)
+	case owner == claimUid:
+		return false, nil
+	default:
+		return true, nil
+	}
+}
+
+// deleteServiceAccount removes the ServiceAccount and RoleBinding from a
+// namespace which outlives the claim. Deleted namespaces take them along.
+// Objects of the same name which were not created for the claim are left
+// alone.
+func (d *driver) deleteServiceAccount(ctx context.Context, ns *corev1.Namespace) error {
+	logger := klog.FromContext(ctx)
+
+	claimUid := ns.Labels[ResourceClaimLabel]
+	name := spacecrd.SpaceServiceAccountName
+
+	bindings := d.clientsets.Core.RbacV1().RoleBindings(ns.Name)
+	binding, err := bindings.Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+	case err != nil:
+		return fmt.Errorf("unable to get role binding: %v", err)
+	case binding.Labels[ResourceClaimLabel] == claimUid:
+		err = bindings.Delete(ctx, name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("unable to delete role binding: %v", err)
+		}
+	}
+
+	serviceAccounts := d.clientsets.Core.CoreV1().ServiceAccounts(ns.Name)
+	sa, err := serviceAccounts.Get(ctx, name, metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+	case err != nil:
+		return fmt.Errorf("unable to get service account: %v", err)
+	case sa.Labels[ResourceClaimLabel] == claimUid:
+		err = serviceAccounts.Delete(ctx, name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("unable to delete service account: %v", err)
+		}
+	}
+
+	logger.Info("Deleted service account", "namespace", ns.Name)
+	return nil
+}
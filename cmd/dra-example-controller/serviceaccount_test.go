@@ -0,0 +1,160 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	corefake "k8s.io/client-go/kubernetes/fake"
+
+	spacecrd "sigs.k8s.io/dra-example-driver/api/example.com/resource/space/v1alpha1"
+	"sigs.k8s.io/dra-example-driver/pkg/flags"
+)
+
+func TestEnsureServiceAccount(t *testing.T) {
+	const (
+		claimUid = "uid"
+		nsName   = "space-ns"
+	)
+	ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: nsName}}
+	previous := DriverName
+	setDriverName(spacecrd.GroupName)
+	defer setDriverName(previous)
+
+	objectMeta := func(labels map[string]string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{
+			Name:      spacecrd.SpaceServiceAccountName,
+			Namespace: nsName,
+			UID:       types.UID("existing"),
+			Labels:    labels,
+		}
+	}
+	serviceAccount := func(labels map[string]string) *corev1.ServiceAccount {
+		return &corev1.ServiceAccount{ObjectMeta: objectMeta(labels)}
+	}
+	roleBinding := func(labels map[string]string, role string) *rbacv1.RoleBinding {
+		return &rbacv1.RoleBinding{
+			ObjectMeta: objectMeta(labels),
+			RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "ClusterRole", Name: role},
+			Subjects: []rbacv1.Subject{{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      spacecrd.SpaceServiceAccountName,
+				Namespace: nsName,
+			}},
+		}
+	}
+	ours := map[string]string{ResourceClaimLabel: claimUid}
+	earlier := map[string]string{ResourceClaimLabel: "earlier-uid"}
+
+	tests := []struct {
+		name     string
+		existing []runtime.Object
+		wantErr  string
+		// wantKept is true if the existing objects are expected to
+		// be left in place.
+		wantKept bool
+	}{
+		{
+			name: "new objects",
+		},
+		{
+			name:     "objects of the claim",
+			existing: []runtime.Object{serviceAccount(ours), roleBinding(ours, spaceRoleName)},
+			wantKept: true,
+		},
+		{
+			name:     "objects of an earlier claim",
+			existing: []runtime.Object{serviceAccount(earlier), roleBinding(earlier, spaceRoleName)},
+		},
+		{
+			name:     "role binding of the claim for another role",
+			existing: []runtime.Object{roleBinding(ours, "edit")},
+		},
+		{
+			name:     "foreign service account",
+			existing: []runtime.Object{serviceAccount(nil)},
+			wantErr:  "service account space-ns/" + spacecrd.SpaceServiceAccountName + " already exists",
+		},
+		{
+			name:     "foreign role binding",
+			existing: []runtime.Object{roleBinding(map[string]string{"app": "other"}, "view")},
+			wantErr:  "role binding space-ns/" + spacecrd.SpaceServiceAccountName + " already exists",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			core := corefake.NewSimpleClientset(tc.existing...)
+			d := &driver{clientsets: flags.ClientSets{Core: core}}
+
+			err := d.ensureServiceAccount(ctx, claimUid, ns)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tc.wantErr, err)
+				}
+				// Objects the driver did not create are left alone.
+				for _, obj := range tc.existing {
+					var meta metav1.Object
+					switch obj.(type) {
+					case *corev1.ServiceAccount:
+						meta, err = core.CoreV1().ServiceAccounts(nsName).Get(ctx, spacecrd.SpaceServiceAccountName, metav1.GetOptions{})
+					case *rbacv1.RoleBinding:
+						meta, err = core.RbacV1().RoleBindings(nsName).Get(ctx, spacecrd.SpaceServiceAccountName, metav1.GetOptions{})
+					}
+					if err != nil {
+						t.Fatalf("unable to get foreign object: %v", err)
+					}
+					if meta.GetUID() != "existing" || meta.GetLabels()[ResourceClaimLabel] != "" {
+						t.Errorf("expected foreign object to be kept, got %+v", meta)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unable to ensure service account: %v", err)
+			}
+
+			sa, err := core.CoreV1().ServiceAccounts(nsName).Get(ctx, spacecrd.SpaceServiceAccountName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unable to get service account: %v", err)
+			}
+			binding, err := core.RbacV1().RoleBindings(nsName).Get(ctx, spacecrd.SpaceServiceAccountName, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("unable to get role binding: %v", err)
+			}
+			for _, meta := range []metav1.ObjectMeta{sa.ObjectMeta, binding.ObjectMeta} {
+				if got := meta.Labels[ResourceClaimLabel]; got != claimUid {
+					t.Errorf("expected %s to be labelled with the claim, got %q", meta.Name, got)
+				}
+				if kept := meta.UID == "existing"; kept != tc.wantKept {
+					t.Errorf("expected existing object kept: %v, got %v", tc.wantKept, kept)
+				}
+			}
+			if binding.RoleRef.Name != spaceRoleName {
+				t.Errorf("expected binding to role %s, got %s", spaceRoleName, binding.RoleRef.Name)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	resourcev1 "k8s.io/api/resource/v1alpha2"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+
+	spacecrd "sigs.k8s.io/dra-example-driver/api/example.com/resource/space/v1alpha1"
+)
+
+// The Space inventory object for a claim is named after the claim's UID so
+// that it can be looked up directly during allocation. Named spaces of a
+// multi-space claim get the space name appended.
+func spaceObjectName(claimUid string, spaceName string) string {
+	if spaceName == "" {
+		return claimUid
+	}
+	return claimUid + "-" + spaceName
+}
+
+func (d *driver) getOrCreateSpace(ctx context.Context, claim *resourcev1.ResourceClaim, spaceName string) (*spacecrd.Space, error) {
+	logger := klog.FromContext(ctx)
+
+	claimUid := string(claim.GetUID())
+	api := d.clientsets.Example.SpaceV1alpha1().Spaces()
+
+	space, err := api.Get(ctx, spaceObjectName(claimUid, spaceName), metav1.GetOptions{})
+	if err == nil {
+		return space, nil
+	}
+	if !errors.IsNotFound(err) {
+		return nil, fmt.Errorf("unable to get space: %v", err)
+	}
+
+	labels := map[string]string{ResourceClaimLabel: claimUid}
+	if spaceName != "" {
+		labels[SpaceNameLabel] = spaceName
+	}
+
+	spec := &spacecrd.Space{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   spaceObjectName(claimUid, spaceName),
+			Labels: labels,
+		},
+	}
+	space, err = api.Create(ctx, spec, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create space: %v", err)
+	}
+
+	space.Status.ClaimRef = &spacecrd.SpaceClaimReference{
+		Namespace: claim.Namespace,
+		Name:      claim.Name,
+		UID:       claim.UID,
+	}
+	space.Status.Phase = spacecrd.SpacePhaseAllocating
+	meta.SetStatusCondition(&space.Status.Conditions, metav1.Condition{
+		Type:    spacecrd.SpaceConditionReady,
+		Status:  metav1.ConditionFalse,
+		Reason:  "Allocating",
+		Message: "waiting for the namespace to be created",
+	})
+	space, err = api.UpdateStatus(ctx, space, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to update space status: %v", err)
+	}
+
+	logger.Info("created space", "claimUid", claimUid, "space", space.Name)
+	return space, nil
+}
+
+// setSpaceReady records the namespace of an allocated space. A bounded
+// lifetime starts with the first successful allocation, not with the
+// namespace, which may have been adopted long before; retries keep the
+// expiration time recorded then.
+func (d *driver) setSpaceReady(ctx context.Context, space *spacecrd.Space, ns *corev1.Namespace, lifetime *metav1.Duration) error {
+	space.Status.Namespace = ns.Name
+	space.Status.Phase = spacecrd.SpacePhaseReady
+	space.Status.Adopted = ns.Labels[AdoptedLabel] == "true"
+	space.Status.CreationTime = ns.CreationTimestamp.DeepCopy()
+	if lifetime != nil && space.Status.ExpirationTime == nil {
+		expiration := metav1.NewTime(time.Now().Add(lifetime.Duration))
+		space.Status.ExpirationTime = &expiration
+	}
+	meta.SetStatusCondition(&space.Status.Conditions, metav1.Condition{
+		Type:    spacecrd.SpaceConditionReady,
+		Status:  metav1.ConditionTrue,
+		Reason:  "NamespaceReady",
+		Message: fmt.Sprintf("namespace %s is ready", ns.Name),
+	})
+	return d.updateSpaceStatus(ctx, space)
+}
+
+func (d *driver) setSpaceFailed(ctx context.Context, space *spacecrd.Space, cause error) error {
+	space.Status.Phase = spacecrd.SpacePhaseFailed
+	meta.SetStatusCondition(&space.Status.Conditions, metav1.Condition{
+		Type:    spacecrd.SpaceConditionReady,
+		Status:  metav1.ConditionFalse,
+		Reason:  "AllocationFailed",
+		Message: cause.Error(),
+	})
+	return d.updateSpaceStatus(ctx, space)
+}
+
+func (d *driver) setSpaceTerminating(ctx context.Context, space *spacecrd.Space) error {
+	space.Status.Phase = spacecrd.SpacePhaseTerminating
+	meta.SetStatusCondition(&space.Status.Conditions, metav1.Condition{
+		Type:    spacecrd.SpaceConditionReady,
+		Status:  metav1.ConditionFalse,
+		Reason:  "Deallocating",
+		Message: "the claim is being deallocated",
+	})
+	return d.updateSpaceStatus(ctx, space)
+}
+
+func (d *driver) updateSpaceStatus(ctx context.Context, space *spacecrd.Space) error {
+	api := d.clientsets.Example.SpaceV1alpha1().Spaces()
+	updated, err := api.UpdateStatus(ctx, space, metav1.UpdateOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to update space status: %v", err)
+	}
+	*space = *updated
+	return nil
+}
+
+func (d *driver) getSpaces(ctx context.Context, claimUid string) ([]spacecrd.Space, error) {
+	api := d.clientsets.Example.SpaceV1alpha1().Spaces()
+	selector := ResourceClaimLabel + "=" + claimUid
+	spaces, err := api.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list spaces: %v", err)
+	}
+	return spaces.Items, nil
+}
+
+func (d *driver) deleteSpace(ctx context.Context, space *spacecrd.Space) error {
+	logger := klog.FromContext(ctx)
+
+	api := d.clientsets.Example.SpaceV1alpha1().Spaces()
+	err := api.Delete(ctx, space.Name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	logger.Info("Deleted space", "space", space.Name)
+	return nil
+}
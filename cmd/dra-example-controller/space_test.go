@@ -0,0 +1,115 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	resourcev1 "k8s.io/api/resource/v1alpha2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	spacecrd "sigs.k8s.io/dra-example-driver/api/example.com/resource/space/v1alpha1"
+	examplefake "sigs.k8s.io/dra-example-driver/pkg/example.com/resource/clientset/versioned/fake"
+	"sigs.k8s.io/dra-example-driver/pkg/flags"
+)
+
+func TestSetSpaceReadyExpiration(t *testing.T) {
+	ctx := context.Background()
+	claim := &resourcev1.ResourceClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "claim", Namespace: "default", UID: types.UID("uid")},
+	}
+	ns := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "space-ns",
+			// An adopted namespace may be much older than the allocation.
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-24 * time.Hour)),
+		},
+	}
+
+	tests := []struct {
+		name     string
+		lifetime *metav1.Duration
+	}{
+		{name: "unbounded", lifetime: nil},
+		{name: "bounded", lifetime: &metav1.Duration{Duration: time.Hour}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &driver{clientsets: flags.ClientSets{Example: examplefake.NewSimpleClientset()}}
+			space, err := d.getOrCreateSpace(ctx, claim, "")
+			if err != nil {
+				t.Fatalf("unable to create space: %v", err)
+			}
+
+			before := time.Now().Truncate(time.Second)
+			err = d.setSpaceReady(ctx, space, ns, tc.lifetime)
+			if err != nil {
+				t.Fatalf("unable to set space ready: %v", err)
+			}
+			after := time.Now()
+
+			if tc.lifetime == nil {
+				if space.Status.ExpirationTime != nil {
+					t.Errorf("expected no expiration time, got %v", space.Status.ExpirationTime)
+				}
+				return
+			}
+			exp := space.Status.ExpirationTime
+			if exp == nil {
+				t.Fatalf("expected an expiration time")
+			}
+			if exp.Time.Before(before.Add(tc.lifetime.Duration)) || exp.Time.After(after.Add(tc.lifetime.Duration)) {
+				t.Errorf("expected expiration one lifetime after allocation, got %v", exp.Time)
+			}
+
+			// Allocation is retried until it succeeds for all spaces of
+			// the claim; that must not extend the lifetime.
+			previous := *exp
+			err = d.setSpaceReady(ctx, space, ns, &metav1.Duration{Duration: 2 * time.Hour})
+			if err != nil {
+				t.Fatalf("unable to set space ready again: %v", err)
+			}
+			if !space.Status.ExpirationTime.Equal(&previous) {
+				t.Errorf("expected expiration time %v to be kept, got %v", previous, space.Status.ExpirationTime)
+			}
+		})
+	}
+}
+
+func TestValidateClassParametersLifetime(t *testing.T) {
+	tests := []struct {
+		lifetime *metav1.Duration
+		valid    bool
+	}{
+		{lifetime: nil, valid: true},
+		{lifetime: &metav1.Duration{Duration: time.Hour}, valid: true},
+		{lifetime: &metav1.Duration{Duration: 0}, valid: false},
+		{lifetime: &metav1.Duration{Duration: -time.Minute}, valid: false},
+	}
+	for _, tc := range tests {
+		params := spacecrd.DefaultSpaceClassParametersSpec()
+		params.Lifetime = tc.lifetime
+		err := validateClassParameters(params)
+		if (err == nil) != tc.valid {
+			t.Errorf("lifetime %v: expected valid=%v, got %v", tc.lifetime, tc.valid, err)
+		}
+	}
+}
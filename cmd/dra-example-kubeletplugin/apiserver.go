@@ -0,0 +1,104 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+
+	resourcev1 "k8s.io/api/resource/v1alpha2"
+	"k8s.io/client-go/rest"
+)
+
+// APIServerInfo describes how containers consuming a claim reach the API
+// server.
+type APIServerInfo struct {
+	Endpoint string
+	CAData   []byte
+}
+
+// NewAPIServerInfo determines the default API server endpoint and CA for
+// containers from the plugin's own client configuration. Both can be
+// overridden by flags for clusters where pods reach the API server through
+// a different address.
+func NewAPIServerInfo(config *Config) (*APIServerInfo, error) {
+	restConfig := rest.CopyConfig(config.restConfig)
+	err := rest.LoadTLSFiles(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load API server CA: %v", err)
+	}
+
+	host, _, err := rest.DefaultServerUrlFor(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to determine API server endpoint: %v", err)
+	}
+
+	info := &APIServerInfo{
+		Endpoint: host.String(),
+		CAData:   restConfig.CAData,
+	}
+
+	if config.flags.apiServerEndpoint != "" {
+		info.Endpoint = config.flags.apiServerEndpoint
+	}
+	if config.flags.apiServerCAFile != "" {
+		info.CAData, err = os.ReadFile(config.flags.apiServerCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read API server CA file: %v", err)
+		}
+	}
+
+	err = validateAPIServerEndpoint(info.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func validateAPIServerEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("invalid API server endpoint '%v': must be an https URL", endpoint)
+	}
+	return nil
+}
+
+// claimAPIServer returns the API server info for a claim, taking overrides
+// from the parameters of the claim's resource class into account.
+func (d *driver) claimAPIServer(claim *resourcev1.ResourceClaim) (*APIServerInfo, error) {
+	params, err := d.cache.GetClassParameters(claim.Spec.ResourceClassName)
+	if err != nil {
+		return nil, err
+	}
+	if params == nil || params.APIServer == nil {
+		return d.apiServer, nil
+	}
+
+	info := &APIServerInfo{
+		Endpoint: params.APIServer.Endpoint,
+		CAData:   params.APIServer.CAData,
+	}
+	if len(info.CAData) == 0 {
+		info.CAData = d.apiServer.CAData
+	}
+	err = validateAPIServerEndpoint(info.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
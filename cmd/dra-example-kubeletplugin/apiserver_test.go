@@ -0,0 +1,245 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	resourcev1 "k8s.io/api/resource/v1alpha2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	spacecrd "sigs.k8s.io/dra-example-driver/api/example.com/resource/space/v1alpha1"
+)
+
+func TestNewAPIServerInfo(t *testing.T) {
+	dir := t.TempDir()
+	pluginCA := filepath.Join(dir, "plugin-ca.crt")
+	if err := os.WriteFile(pluginCA, []byte("plugin CA"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	containerCA := filepath.Join(dir, "container-ca.crt")
+	if err := os.WriteFile(containerCA, []byte("container CA"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		restConfig *rest.Config
+		flags      Flags
+		endpoint   string
+		caData     string
+		valid      bool
+	}{
+		{
+			name:       "in-cluster",
+			restConfig: &rest.Config{Host: "https://10.96.0.1:443", TLSClientConfig: rest.TLSClientConfig{CAFile: pluginCA}},
+			endpoint:   "https://10.96.0.1:443",
+			caData:     "plugin CA",
+			valid:      true,
+		},
+		{
+			name:       "CA data",
+			restConfig: &rest.Config{Host: "https://10.96.0.1:443", TLSClientConfig: rest.TLSClientConfig{CAData: []byte("plugin CA")}},
+			endpoint:   "https://10.96.0.1:443",
+			caData:     "plugin CA",
+			valid:      true,
+		},
+		{
+			name:       "host without scheme",
+			restConfig: &rest.Config{Host: "10.96.0.1:6443", TLSClientConfig: rest.TLSClientConfig{CAFile: pluginCA}},
+			endpoint:   "https://10.96.0.1:6443",
+			caData:     "plugin CA",
+			valid:      true,
+		},
+		{
+			name:       "endpoint overridden",
+			restConfig: &rest.Config{Host: "https://10.96.0.1:443", TLSClientConfig: rest.TLSClientConfig{CAFile: pluginCA}},
+			flags:      Flags{apiServerEndpoint: "https://lb.example.com:6443"},
+			endpoint:   "https://lb.example.com:6443",
+			caData:     "plugin CA",
+			valid:      true,
+		},
+		{
+			name:       "endpoint and CA overridden",
+			restConfig: &rest.Config{Host: "https://10.96.0.1:443", TLSClientConfig: rest.TLSClientConfig{CAFile: pluginCA}},
+			flags:      Flags{apiServerEndpoint: "https://lb.example.com:6443", apiServerCAFile: containerCA},
+			endpoint:   "https://lb.example.com:6443",
+			caData:     "container CA",
+			valid:      true,
+		},
+		{
+			name:       "plugin CA missing",
+			restConfig: &rest.Config{Host: "https://10.96.0.1:443", TLSClientConfig: rest.TLSClientConfig{CAFile: filepath.Join(dir, "missing.crt")}},
+		},
+		{
+			name:       "overriding CA missing",
+			restConfig: &rest.Config{Host: "https://10.96.0.1:443"},
+			flags:      Flags{apiServerCAFile: filepath.Join(dir, "missing.crt")},
+		},
+		{
+			name:       "plain HTTP",
+			restConfig: &rest.Config{Host: "http://127.0.0.1:8080"},
+		},
+		{
+			name:       "plain HTTP override",
+			restConfig: &rest.Config{Host: "https://10.96.0.1:443"},
+			flags:      Flags{apiServerEndpoint: "http://lb.example.com"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			info, err := NewAPIServerInfo(&Config{flags: &tc.flags, restConfig: tc.restConfig})
+			switch {
+			case !tc.valid && err == nil:
+				t.Fatalf("expected API server info to be rejected, got %+v", info)
+			case tc.valid && err != nil:
+				t.Fatalf("unable to determine API server info: %v", err)
+			}
+			if !tc.valid {
+				return
+			}
+			if info.Endpoint != tc.endpoint {
+				t.Errorf("expected endpoint %s, got %s", tc.endpoint, info.Endpoint)
+			}
+			if string(info.CAData) != tc.caData {
+				t.Errorf("expected CA %q, got %q", tc.caData, info.CAData)
+			}
+		})
+	}
+}
+
+// setClassAPIServer makes the test class reference SpaceClassParameters
+// with the given API server and waits until the cache of the driver has
+// them.
+func (td *testDriver) setClassAPIServer(t *testing.T, apiServer *spacecrd.APIServerConfig) {
+	t.Helper()
+	ctx := context.Background()
+
+	params := &spacecrd.SpaceClassParameters{
+		ObjectMeta: metav1.ObjectMeta{Name: testClassName},
+		Spec:       spacecrd.SpaceClassParametersSpec{APIServer: apiServer},
+	}
+	_, err := td.clientsets.Example.SpaceV1alpha1().SpaceClassParameters().Create(ctx, params, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	class, err := td.core.ResourceV1alpha2().ResourceClasses().Get(ctx, testClassName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	class.ParametersRef = &resourcev1.ResourceClassParametersReference{
+		APIGroup: spacecrd.GroupName,
+		Kind:     spacecrd.SpaceClassParametersKind,
+		Name:     testClassName,
+	}
+	_, err = td.core.ResourceV1alpha2().ResourceClasses().Update(ctx, class, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 10*time.Second, true, func(context.Context) (bool, error) {
+		params, err := td.cache.GetClassParameters(testClassName)
+		return err == nil && params != nil, nil
+	})
+	if err != nil {
+		t.Fatalf("class parameters did not show up in the cache: %v", err)
+	}
+}
+
+func TestClaimAPIServer(t *testing.T) {
+	tests := []struct {
+		name      string
+		apiServer *spacecrd.APIServerConfig
+		endpoint  string
+		caData    string
+		wantErr   string
+	}{
+		{
+			name:     "plugin connection",
+			endpoint: testAPIServer,
+			caData:   "plugin CA",
+		},
+		{
+			name:      "class endpoint",
+			apiServer: &spacecrd.APIServerConfig{Endpoint: "https://lb.example.com:6443"},
+			endpoint:  "https://lb.example.com:6443",
+			caData:    "plugin CA",
+		},
+		{
+			name:      "class endpoint and CA",
+			apiServer: &spacecrd.APIServerConfig{Endpoint: "https://lb.example.com:6443", CAData: []byte("class CA")},
+			endpoint:  "https://lb.example.com:6443",
+			caData:    "class CA",
+		},
+		{
+			name:      "plain HTTP class endpoint",
+			apiServer: &spacecrd.APIServerConfig{Endpoint: "http://lb.example.com"},
+			wantErr:   "must be an https URL",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			td := newTestDriver(t, 1)
+			td.apiServer.CAData = []byte("plugin CA")
+			if tc.apiServer != nil {
+				td.setClassAPIServer(t, tc.apiServer)
+			}
+			claim := td.addClaim(t, "test")
+
+			result := td.prepareClaims(context.Background(), []*nodeClaim{claim})[claim.Uid]
+			if tc.wantErr != "" {
+				if !strings.Contains(result.Error, tc.wantErr) {
+					t.Fatalf("expected error containing %q, got %+v", tc.wantErr, result)
+				}
+				return
+			}
+			if result.Error != "" {
+				t.Fatalf("unable to prepare claim: %s", result.Error)
+			}
+
+			kubeconfig, err := clientcmd.LoadFromFile(filepath.Join(td.cdi.artifacts.ClaimPath(claim.Uid, ""), "kubeconfig"))
+			if err != nil {
+				t.Fatalf("unable to load kubeconfig of claim: %v", err)
+			}
+			cluster := kubeconfig.Clusters[kubeconfig.Contexts[kubeconfig.CurrentContext].Cluster]
+			if cluster.Server != tc.endpoint {
+				t.Errorf("expected kubeconfig for %s, got %s", tc.endpoint, cluster.Server)
+			}
+			if !bytes.Equal(cluster.CertificateAuthorityData, []byte(tc.caData)) {
+				t.Errorf("expected kubeconfig with CA %q, got %q", tc.caData, cluster.CertificateAuthorityData)
+			}
+			ca, err := os.ReadFile(filepath.Join(td.cdi.artifacts.ClaimPath(claim.Uid, ""), "ca.crt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(ca) != tc.caData {
+				t.Errorf("expected ca.crt %q, got %q", tc.caData, ca)
+			}
+		})
+	}
+}
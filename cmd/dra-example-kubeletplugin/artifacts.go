@@ -0,0 +1,251 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	artifactDirPerm  os.FileMode = 0700
+	artifactFilePerm os.FileMode = 0600
+
+	// podArtifactsDir holds one directory per pod for artifacts which
+	// combine several claims of the pod.
+	podArtifactsDir = "pods"
+
+	// driverNameFile in the root records the name of the driver instance
+	// which owns the store.
+	driverNameFile = ".driver-name"
+)
+
+// ArtifactStore manages the files on the host which are mounted into
+// containers consuming a claim. Artifacts live in one directory per claim
+// below the root, with a subdirectory per space for multi-space claims.
+// Artifacts of a pod rather than a claim live below the pods directory.
+type ArtifactStore struct {
+	root string
+}
+
+func NewArtifactStore(root string) (*ArtifactStore, error) {
+	if !filepath.IsAbs(root) {
+		return nil, fmt.Errorf("claim artifacts root must be an absolute path: %q", root)
+	}
+	err := os.MkdirAll(root, artifactDirPerm)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create claim artifacts root: %v", err)
+	}
+	err = claimRoot(root, DriverName)
+	if err != nil {
+		return nil, err
+	}
+	return &ArtifactStore{root: root}, nil
+}
+
+// claimRoot records the driver name in the root, or checks that it is the
+// one recorded already. Artifacts which no checkpointed claim refers to are
+// removed on startup, so instances sharing a root would remove each other's.
+func claimRoot(root string, driverName string) error {
+	path := filepath.Join(root, driverNameFile)
+	owner, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		err := writeFileAtomic(path, []byte(driverName), artifactFilePerm)
+		if err != nil {
+			return fmt.Errorf("unable to claim artifacts root: %v", err)
+		}
+		return nil
+	case err != nil:
+		return fmt.Errorf("unable to read owner of claim artifacts root: %v", err)
+	case string(owner) != driverName:
+		return fmt.Errorf("claim artifacts root %s is used by driver %s, each driver instance needs its own", root, owner)
+	}
+	return nil
+}
+
+// ClaimPath returns the directory holding the artifacts for one space of a
+// claim.
+func (s *ArtifactStore) ClaimPath(claimUid string, spaceName string) string {
+	if spaceName == "" {
+		return filepath.Join(s.root, claimUid)
+	}
+	return filepath.Join(s.root, claimUid, spaceName)
+}
+
+// WriteFile atomically replaces the named artifact of one space of a claim
+// and returns its path on the host. The artifact and the directory holding
+// it are made accessible to the owner.
+func (s *ArtifactStore) WriteFile(claimUid string, spaceName string, name string, content []byte, owner *ArtifactOwner) (string, error) {
+	return s.writeFile(s.ClaimPath(claimUid, spaceName), name, content, owner)
+}
+
+// PodPath returns the directory holding the artifacts for a pod.
+func (s *ArtifactStore) PodPath(podUid string) string {
+	return filepath.Join(s.root, podArtifactsDir, podUid)
+}
+
+// WritePodFile atomically replaces the named artifact of a pod and returns
+// its path on the host.
+func (s *ArtifactStore) WritePodFile(podUid string, name string, content []byte, owner *ArtifactOwner) (string, error) {
+	return s.writeFile(s.PodPath(podUid), name, content, owner)
+}
+
+// DeletePod removes the artifacts of a pod.
+func (s *ArtifactStore) DeletePod(podUid string) error {
+	dir := s.PodPath(podUid)
+	err := s.checkBelowRoot(dir)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// checkBelowRoot fails unless path is strictly below the root. Claim UIDs
+// and space names end up in paths, so this keeps a bad name from making the
+// store write or remove anything outside of it, or the root itself.
+func (s *ArtifactStore) checkBelowRoot(path string) error {
+	rel, err := filepath.Rel(s.root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("artifacts path %s is not below the claim artifacts root", path)
+	}
+	return nil
+}
+
+// ListPods returns the UIDs of all pods with artifacts in the store.
+func (s *ArtifactStore) ListPods() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.root, podArtifactsDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to list pod artifacts: %v", err)
+	}
+	var podUids []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			podUids = append(podUids, entry.Name())
+		}
+	}
+	return podUids, nil
+}
+
+func (s *ArtifactStore) writeFile(dir string, name string, content []byte, owner *ArtifactOwner) (string, error) {
+	path := filepath.Join(dir, name)
+	err := s.checkBelowRoot(dir)
+	if err == nil && filepath.Dir(path) != dir {
+		err = fmt.Errorf("invalid artifact name %q", name)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(dir, artifactDirPerm)
+	if err != nil {
+		return "", fmt.Errorf("unable to create artifacts directory: %v", err)
+	}
+
+	// MkdirAll leaves existing directories alone, so tighten the
+	// permissions of directories created by older versions. Only the
+	// directory which gets mounted is opened up to the owner.
+	for d := filepath.Dir(dir); d != s.root; d = filepath.Dir(d) {
+		err := os.Chmod(d, artifactDirPerm)
+		if err != nil {
+			return "", fmt.Errorf("unable to set permissions of artifacts directory: %v", err)
+		}
+	}
+	err = owner.apply(dir, owner.mode(artifactDirPerm, 0050))
+	if err != nil {
+		return "", err
+	}
+
+	err = writeFileAtomicFunc(path, content, func(tmp string) error {
+		return owner.apply(tmp, owner.mode(artifactFilePerm, 0040))
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to write artifact %s: %v", name, err)
+	}
+	return path, nil
+}
+
+// Delete removes the artifacts for one space of a claim. The claim directory
+// itself is removed together with its last space.
+func (s *ArtifactStore) Delete(claimUid string, spaceName string) error {
+	dir := s.ClaimPath(claimUid, spaceName)
+	err := s.checkBelowRoot(dir)
+	if err != nil {
+		return err
+	}
+	err = os.RemoveAll(dir)
+	if err != nil {
+		return err
+	}
+	if spaceName == "" {
+		return nil
+	}
+
+	// Other spaces of the claim may still be prepared.
+	claimDir := s.ClaimPath(claimUid, "")
+	err = s.checkBelowRoot(claimDir)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(claimDir)
+	if err != nil && !os.IsNotExist(err) && !errors.Is(err, syscall.ENOTEMPTY) {
+		return err
+	}
+	return nil
+}
+
+// InstallHelper copies an executable into the root of the store, next to
+// the claim directories, so that it can be mounted into containers. It
+// returns the host path of the copy.
+func (s *ArtifactStore) InstallHelper(src string) (string, error) {
+	content, err := os.ReadFile(src)
+	if err != nil {
+		return "", fmt.Errorf("unable to read helper: %v", err)
+	}
+	path := filepath.Join(s.root, filepath.Base(src))
+	// The helper is shared by all claims, so it gets the shared label.
+	var owner *ArtifactOwner
+	err = writeFileAtomicFunc(path, content, func(tmp string) error {
+		return owner.apply(tmp, 0755)
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to install helper: %v", err)
+	}
+	return path, nil
+}
+
+// List returns the UIDs of all claims with artifacts in the store.
+func (s *ArtifactStore) List() ([]string, error) {
+	entries, err := os.ReadDir(s.root)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list claim artifacts: %v", err)
+	}
+	var claimUids []string
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != podArtifactsDir {
+			claimUids = append(claimUids, entry.Name())
+		}
+	}
+	return claimUids, nil
+}
@@ -0,0 +1,312 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// newTestArtifactStore creates a store in a temporary directory, owned by
+// the given driver name.
+func newTestArtifactStore(t *testing.T, driverName string) (*ArtifactStore, string) {
+	t.Helper()
+	withDriverName(t, driverName)
+	root := filepath.Join(t.TempDir(), "artifacts")
+	store, err := NewArtifactStore(root)
+	if err != nil {
+		t.Fatalf("unable to create artifact store: %v", err)
+	}
+	return store, root
+}
+
+// withDriverName sets the driver name for the duration of a test.
+func withDriverName(t *testing.T, driverName string) {
+	previous := DriverName
+	setDriverName(driverName)
+	t.Cleanup(func() { setDriverName(previous) })
+}
+
+func checkPerm(t *testing.T, path string, want os.FileMode) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("unable to stat %s: %v", path, err)
+	}
+	if got := info.Mode().Perm(); got != want {
+		t.Errorf("expected permissions %v for %s, got %v", want, path, got)
+	}
+}
+
+func TestArtifactStoreWriteFile(t *testing.T) {
+	store, root := newTestArtifactStore(t, "space.resource.example.com")
+
+	path, err := store.WriteFile("uid-a", "dev", "token", []byte("first"), nil)
+	if err != nil {
+		t.Fatalf("unable to write artifact: %v", err)
+	}
+	if want := filepath.Join(root, "uid-a", "dev", "token"); path != want {
+		t.Errorf("expected artifact at %s, got %s", want, path)
+	}
+	checkPerm(t, root, artifactDirPerm)
+	checkPerm(t, filepath.Join(root, "uid-a"), artifactDirPerm)
+	checkPerm(t, filepath.Join(root, "uid-a", "dev"), artifactDirPerm)
+	checkPerm(t, path, artifactFilePerm)
+
+	// Directories left behind by older versions are tightened.
+	err = os.Chmod(filepath.Join(root, "uid-a"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.WriteFile("uid-a", "dev", "token", []byte("second"), nil)
+	if err != nil {
+		t.Fatalf("unable to replace artifact: %v", err)
+	}
+	checkPerm(t, filepath.Join(root, "uid-a"), artifactDirPerm)
+	checkPerm(t, path, artifactFilePerm)
+
+	// The artifact is replaced by renaming a new file over it, so
+	// containers reading it never see partial content.
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "second" {
+		t.Errorf("expected replaced content %q, got %q", "second", content)
+	}
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os.SameFile(before, after) {
+		t.Errorf("expected artifact to be replaced by a new file, it was written in place")
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the artifact in its directory, got %d entries", len(entries))
+	}
+}
+
+func TestArtifactStoreDelete(t *testing.T) {
+	store, root := newTestArtifactStore(t, "space.resource.example.com")
+
+	for _, space := range []string{"dev", "prod"} {
+		_, err := store.WriteFile("uid-a", space, "token", []byte(space), nil)
+		if err != nil {
+			t.Fatalf("unable to write artifact: %v", err)
+		}
+	}
+	_, err := store.WriteFile("uid-b", "", "token", []byte("b"), nil)
+	if err != nil {
+		t.Fatalf("unable to write artifact: %v", err)
+	}
+
+	err = store.Delete("uid-a", "dev")
+	if err != nil {
+		t.Fatalf("unable to delete space: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "uid-a", "dev")); !os.IsNotExist(err) {
+		t.Errorf("expected deleted space to be gone, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "uid-a", "prod", "token")); err != nil {
+		t.Errorf("expected other space of the claim to be kept: %v", err)
+	}
+
+	err = store.Delete("uid-a", "prod")
+	if err != nil {
+		t.Fatalf("unable to delete space: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "uid-a")); !os.IsNotExist(err) {
+		t.Errorf("expected claim directory to be removed with its last space, got %v", err)
+	}
+
+	err = store.Delete("uid-b", "")
+	if err != nil {
+		t.Fatalf("unable to delete claim: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "uid-b")); !os.IsNotExist(err) {
+		t.Errorf("expected claim directory to be removed, got %v", err)
+	}
+
+	// Deleting again is not an error.
+	err = store.Delete("uid-a", "prod")
+	if err != nil {
+		t.Errorf("unable to delete space again: %v", err)
+	}
+}
+
+func TestArtifactStoreList(t *testing.T) {
+	store, _ := newTestArtifactStore(t, "space.resource.example.com")
+
+	claims, err := store.List()
+	if err != nil || len(claims) != 0 {
+		t.Fatalf("expected no claims in a new store, got %v, %v", claims, err)
+	}
+	pods, err := store.ListPods()
+	if err != nil || len(pods) != 0 {
+		t.Fatalf("expected no pods in a new store, got %v, %v", pods, err)
+	}
+
+	for _, claim := range []struct{ uid, space string }{{"uid-a", ""}, {"uid-b", "dev"}, {"uid-b", "prod"}} {
+		_, err := store.WriteFile(claim.uid, claim.space, "token", []byte("token"), nil)
+		if err != nil {
+			t.Fatalf("unable to write artifact: %v", err)
+		}
+	}
+	for _, pod := range []string{"pod-a", "pod-b"} {
+		_, err := store.WritePodFile(pod, "kubeconfig", []byte("kubeconfig"), nil)
+		if err != nil {
+			t.Fatalf("unable to write pod artifact: %v", err)
+		}
+	}
+
+	claims, err = store.List()
+	if err != nil {
+		t.Fatalf("unable to list claims: %v", err)
+	}
+	sort.Strings(claims)
+	if want := []string{"uid-a", "uid-b"}; !reflect.DeepEqual(claims, want) {
+		t.Errorf("expected claims %v, got %v", want, claims)
+	}
+	pods, err = store.ListPods()
+	if err != nil {
+		t.Fatalf("unable to list pods: %v", err)
+	}
+	sort.Strings(pods)
+	if want := []string{"pod-a", "pod-b"}; !reflect.DeepEqual(pods, want) {
+		t.Errorf("expected pods %v, got %v", want, pods)
+	}
+
+	err = store.DeletePod("pod-a")
+	if err != nil {
+		t.Fatalf("unable to delete pod artifacts: %v", err)
+	}
+	pods, err = store.ListPods()
+	if err != nil {
+		t.Fatalf("unable to list pods: %v", err)
+	}
+	if want := []string{"pod-b"}; !reflect.DeepEqual(pods, want) {
+		t.Errorf("expected pods %v after delete, got %v", want, pods)
+	}
+}
+
+func TestArtifactStoreDriverName(t *testing.T) {
+	_, root := newTestArtifactStore(t, "space.resource.example.com")
+
+	// The same instance may reopen its root.
+	_, err := NewArtifactStore(root)
+	if err != nil {
+		t.Errorf("unable to reopen artifact store: %v", err)
+	}
+
+	withDriverName(t, "team-b.space.resource.example.com")
+	_, err = NewArtifactStore(root)
+	if err == nil {
+		t.Errorf("expected another driver instance to be refused the root")
+	}
+	owner, err := os.ReadFile(filepath.Join(root, driverNameFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(owner) != "space.resource.example.com" {
+		t.Errorf("expected root to remain owned by the first instance, got %q", owner)
+	}
+
+	_, err = NewArtifactStore("relative/artifacts")
+	if err == nil {
+		t.Errorf("expected a relative root to be rejected")
+	}
+}
+
+func TestArtifactStoreTraversal(t *testing.T) {
+	store, root := newTestArtifactStore(t, "space.resource.example.com")
+	outside := filepath.Dir(root)
+
+	tests := []struct {
+		name string
+		run  func() error
+	}{
+		{"claim UID ..", func() error {
+			_, err := store.WriteFile("..", "", "token", nil, nil)
+			return err
+		}},
+		{"claim UID with ../", func() error {
+			_, err := store.WriteFile("../escape", "", "token", nil, nil)
+			return err
+		}},
+		{"space name ..", func() error {
+			_, err := store.WriteFile("uid-a", "..", "token", nil, nil)
+			return err
+		}},
+		{"space name with ../..", func() error {
+			_, err := store.WriteFile("uid-a", "../../escape", "token", nil, nil)
+			return err
+		}},
+		{"artifact name with ../", func() error {
+			_, err := store.WriteFile("uid-a", "", "../token", nil, nil)
+			return err
+		}},
+		{"artifact name with a directory", func() error {
+			_, err := store.WriteFile("uid-a", "", "dir/token", nil, nil)
+			return err
+		}},
+		{"pod UID with ../", func() error {
+			_, err := store.WritePodFile("../../escape", "kubeconfig", nil, nil)
+			return err
+		}},
+		{"delete root", func() error {
+			return store.Delete("", "")
+		}},
+		{"delete claim UID ..", func() error {
+			return store.Delete("..", "")
+		}},
+		{"delete space name ..", func() error {
+			return store.Delete("uid-a", "../..")
+		}},
+		{"delete pod UID ../..", func() error {
+			return store.DeletePod("../..")
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.run(); err == nil {
+				t.Errorf("expected path outside of the root to be rejected")
+			}
+		})
+	}
+
+	entries, err := os.ReadDir(outside)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != filepath.Base(root) {
+		t.Errorf("expected nothing but the root in %s, got %v", outside, entries)
+	}
+	if _, err := os.Stat(filepath.Join(root, driverNameFile)); err != nil {
+		t.Errorf("expected root to survive: %v", err)
+	}
+}
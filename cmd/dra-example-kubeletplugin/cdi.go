@@ -18,25 +18,51 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
+	"sync"
 
 	cdiapi "github.com/container-orchestrated-devices/container-device-interface/pkg/cdi"
 	cdispec "github.com/container-orchestrated-devices/container-device-interface/specs-go"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 	"k8s.io/klog/v2"
+
+	spacecrd "sigs.k8s.io/dra-example-driver/api/example.com/resource/space/v1alpha1"
 )
 
 const (
-	cdiVendor = "k8s." + DriverName
-	cdiClass  = "space"
-	cdiKind   = cdiVendor + "/" + cdiClass
+	cdiClass = "space"
 
 	cdiCommonDeviceName = "common"
+
+	// credentialHelperContainerPath is where the ExecCredential helper is
+	// mounted into containers.
+	credentialHelperContainerPath = "/usr/local/bin/dra-space-credential"
+
+	// spaceDescriptorName is the artifact describing one space of a claim.
+	spaceDescriptorName = "space.json"
 )
 
+// claimMountOptions are used to mount the claim directory. A bind mount is
+// required since the host path is a directory rather than a device, and
+// containers only ever read from it. The SELinux label is set on the host,
+// see ArtifactOwner.relabel.
+var claimMountOptions = []string{"bind", "ro", "nosuid", "nodev", "noexec"}
+
 type CDIHandler struct {
-	registry cdiapi.Registry
+	// The registry does not synchronize writing and removing spec files
+	// with its own cache refreshes, so all modifications go through the
+	// writeSpec and removeSpec helpers below.
+	sync.Mutex
+	registry  cdiapi.Registry
+	artifacts *ArtifactStore
 }
 
 func NewCDIHandler(config *Config) (*CDIHandler, error) {
@@ -49,8 +75,14 @@ func NewCDIHandler(config *Config) (*CDIHandler, error) {
 		return nil, fmt.Errorf("unable to refresh the CDI registry: %v", err)
 	}
 
+	artifacts, err := NewArtifactStore(config.flags.claimArtifactsRoot)
+	if err != nil {
+		return nil, err
+	}
+
 	handler := &CDIHandler{
-		registry: registry,
+		registry:  registry,
+		artifacts: artifacts,
 	}
 
 	return handler, nil
@@ -82,57 +114,209 @@ func (cdi *CDIHandler) CreateCommonSpecFile() error {
 		return fmt.Errorf("failed to generate Spec name: %w", err)
 	}
 
+	return cdi.writeSpec(spec, specName)
+}
+
+func (cdi *CDIHandler) writeSpec(spec *cdispec.Spec, specName string) error {
+	cdi.Lock()
+	defer cdi.Unlock()
 	return cdi.registry.SpecDB().WriteSpec(spec, specName)
 }
 
-func (cdi *CDIHandler) CreateClaimSpecFile(claimUid string, claimName string, space string) error {
-	logger := klog.FromContext(context.TODO())
-	specName := cdiapi.GenerateTransientSpecName(cdiVendor, cdiClass, claimUid)
+func (cdi *CDIHandler) removeSpec(specName string) error {
+	cdi.Lock()
+	defer cdi.Unlock()
+	return cdi.registry.SpecDB().RemoveSpec(specName)
+}
 
-	// TODO look for an alternative naming convention which doesn't use the claim name.
-	// Claim names can be dynamic when using ResourceClaimTemplates.
-	envBase := strings.ReplaceAll(strings.ToUpper(claimName), "-", "_")
+// claimSpecName returns the name of the transient CDI spec for one space of a
+// claim. Each space of a multi-space claim gets its own spec.
+func claimSpecName(claimUid string, spaceName string) string {
+	transientID := claimUid
+	if spaceName != "" {
+		transientID = claimUid + "-" + spaceName
+	}
+	return cdiapi.GenerateTransientSpecName(cdiVendor, cdiClass, transientID)
+}
 
-	// TODO remove hard coded host path prefix
-	hostPath := fmt.Sprintf("/var/run/claim-artifacts/%s", claimUid)
-	kubeConfigPath := fmt.Sprintf("%s/kubeconfig", hostPath)
-	containerPath := fmt.Sprintf("/etc/%s", claimName)
+// podSpecName returns the name of the CDI spec for the artifacts of a pod.
+func podSpecName(podUid string) string {
+	return cdiapi.GenerateTransientSpecName(cdiVendor, cdiClass, podDeviceName(podUid))
+}
 
-	logger.Info("creating claim artifacts", "claimUid", claimUid, "hostPath", hostPath)
-	err := os.MkdirAll(hostPath, os.ModeDir)
-	if err != nil {
-		return err
+func podDeviceName(podUid string) string {
+	return "pod-" + podUid
+}
+
+// ClaimSpecInfo describes one space of a claim for which a CDI spec is
+// written.
+type ClaimSpecInfo struct {
+	ClaimUID  string
+	ClaimName string
+	EnvPrefix string
+	SpaceName string
+	Namespace string
+	APIServer *APIServerInfo
+	Token     []byte
+
+	CredentialLayout        spacecrd.CredentialLayout
+	ServiceAccountMountPath string
+
+	// CredentialHelper is the host path of the ExecCredential helper. When
+	// set, the kubeconfig runs the helper instead of reading the token file.
+	CredentialHelper string
+
+	// Owner is who the containers consuming the claim run as.
+	Owner *ArtifactOwner
+
+	// ServiceAccount and Role are passed through from the resource handle
+	// to the space descriptor.
+	ServiceAccount string
+	Role           string
+
+	// ExpirationTime is when Token expires, or the space if that is
+	// earlier, for the space descriptor.
+	ExpirationTime *metav1.Time
+
+	// MetadataSocket exposes the path of the metadata socket, which is
+	// served from the claim directory, to containers.
+	MetadataSocket bool
+}
+
+// CreateClaimSpecFile writes the artifacts and CDI spec for one space of a
+// claim and returns the host paths of the artifacts it created.
+func (cdi *CDIHandler) CreateClaimSpecFile(info *ClaimSpecInfo) ([]string, error) {
+	logger := klog.FromContext(context.TODO())
+	claimUid := info.ClaimUID
+	spaceName := info.SpaceName
+	space := info.Namespace
+	specName := claimSpecName(claimUid, spaceName)
+	envBase := spaceEnvPrefix(info.EnvPrefix, spaceName)
+
+	hostPath := cdi.artifacts.ClaimPath(claimUid, spaceName)
+	containerPath := fmt.Sprintf("/etc/%s", info.ClaimName)
+
+	// Spaces of a multi-space claim are distinguished by their name.
+	if spaceName != "" {
+		containerPath = fmt.Sprintf("%s/%s", containerPath, spaceName)
 	}
 
-	kubeconfig, err := os.Create(kubeConfigPath)
+	kubeconfig, err := claimKubeconfig(info, containerPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer kubeconfig.Close()
 
-	_, err = kubeconfig.WriteString("TODO")
-	if err != nil {
-		return err
+	// The claim directory holds the kubeconfig next to the files of the
+	// ServiceAccount layout, so that either can be mounted from it.
+	files := map[string][]byte{
+		"kubeconfig": kubeconfig,
+		"token":      info.Token,
+		"ca.crt":     info.APIServer.CAData,
+		"namespace":  []byte(space),
+	}
+	logger.Info("creating claim artifacts", "claimUid", claimUid, "hostPath", hostPath)
+	for name, content := range files {
+		_, err = cdi.artifacts.WriteFile(claimUid, spaceName, name, content, info.Owner)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	cdiDevice := cdispec.Device{
 		Name: space,
 		ContainerEdits: cdispec.ContainerEdits{
 			Env: []string{
-				fmt.Sprintf("%s_CLUSTER=%s", envBase, "https://cluster.todo"),
+				fmt.Sprintf("%s_CLUSTER=%s", envBase, info.APIServer.Endpoint),
 				fmt.Sprintf("%s_NAMESPACE=%s", envBase, space),
-				fmt.Sprintf("%s_KUBECONFIG=%s", envBase, kubeConfigPath),
-			},
-			Mounts: []*cdispec.Mount{
-				{
-					HostPath:      hostPath,
-					ContainerPath: containerPath,
-					Options:       []string{"bind"}, // TODO is this necessary?
-				},
 			},
 		},
 	}
 
+	layout := info.CredentialLayout
+	if layout == "" {
+		layout = spacecrd.CredentialLayoutKubeconfig
+	}
+
+	// The claim directory is mounted at containerPath unless only the
+	// ServiceAccount layout is used.
+	mountPath := containerPath
+
+	if layout == spacecrd.CredentialLayoutKubeconfig || layout == spacecrd.CredentialLayoutBoth {
+		edits := &cdiDevice.ContainerEdits
+		edits.Env = append(edits.Env, fmt.Sprintf("%s_KUBECONFIG=%s", envBase, path.Join(containerPath, "kubeconfig")))
+		edits.Mounts = append(edits.Mounts, &cdispec.Mount{
+			HostPath:      hostPath,
+			ContainerPath: containerPath,
+			Options:       claimMountOptions,
+		})
+		if info.CredentialHelper != "" {
+			edits.Mounts = append(edits.Mounts, &cdispec.Mount{
+				HostPath:      info.CredentialHelper,
+				ContainerPath: credentialHelperContainerPath,
+				Options:       []string{"bind", "ro", "nosuid", "nodev"},
+			})
+		}
+	}
+
+	if layout == spacecrd.CredentialLayoutServiceAccount || layout == spacecrd.CredentialLayoutBoth {
+		saMountPath := info.ServiceAccountMountPath
+		if saMountPath == "" {
+			saMountPath = spacecrd.DefaultServiceAccountMountPath
+		}
+		if layout == spacecrd.CredentialLayoutServiceAccount {
+			mountPath = saMountPath
+		}
+		endpoint, err := url.Parse(info.APIServer.Endpoint)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API server endpoint: %v", err)
+		}
+		port := endpoint.Port()
+		if port == "" {
+			port = "443"
+		}
+
+		// In-cluster clients find the API server through these variables.
+		edits := &cdiDevice.ContainerEdits
+		edits.Env = append(edits.Env,
+			fmt.Sprintf("KUBERNETES_SERVICE_HOST=%s", endpoint.Hostname()),
+			fmt.Sprintf("KUBERNETES_SERVICE_PORT=%s", port),
+		)
+		edits.Mounts = append(edits.Mounts, &cdispec.Mount{
+			HostPath:      hostPath,
+			ContainerPath: saMountPath,
+			Options:       claimMountOptions,
+		})
+	}
+
+	descriptor := &spacecrd.SpaceManifestEntry{
+		ResourceClaimName: info.ClaimName,
+		SpaceName:         spaceName,
+		Namespace:         space,
+		Server:            info.APIServer.Endpoint,
+		ServiceAccount:    info.ServiceAccount,
+		Role:              info.Role,
+		ExpirationTime:    info.ExpirationTime,
+		Credentials: spacecrd.SpaceCredentialPaths{
+			Token:  path.Join(mountPath, "token"),
+			CACert: path.Join(mountPath, "ca.crt"),
+		},
+	}
+	if layout == spacecrd.CredentialLayoutKubeconfig || layout == spacecrd.CredentialLayoutBoth {
+		descriptor.Credentials.Kubeconfig = path.Join(containerPath, "kubeconfig")
+	}
+
+	if info.MetadataSocket {
+		edits := &cdiDevice.ContainerEdits
+		edits.Env = append(edits.Env, fmt.Sprintf("%s_METADATA_SOCKET=%s", envBase, path.Join(mountPath, metadataSocketName)))
+		descriptor.Credentials.MetadataSocket = path.Join(mountPath, metadataSocketName)
+	}
+
+	// The descriptor is collected into the spaces manifest of pods.
+	err = cdi.writeSpaceDescriptor(claimUid, spaceName, descriptor, info.Owner)
+	if err != nil {
+		return nil, err
+	}
+
 	spec := &cdispec.Spec{
 		Kind:    cdiKind,
 		Devices: []cdispec.Device{cdiDevice},
@@ -140,28 +324,143 @@ func (cdi *CDIHandler) CreateClaimSpecFile(claimUid string, claimName string, sp
 
 	minVersion, err := cdiapi.MinimumRequiredVersion(spec)
 	if err != nil {
-		return fmt.Errorf("failed to get minimum required CDI spec version: %v", err)
+		return nil, fmt.Errorf("failed to get minimum required CDI spec version: %v", err)
 	}
 	spec.Version = minVersion
 
 	logger.Info("creating CDI spec", "claimUid", claimUid, "specName", specName)
-	return cdi.registry.SpecDB().WriteSpec(spec, specName)
+	err = cdi.writeSpec(spec, specName)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{hostPath}, nil
 }
 
-func (cdi *CDIHandler) DeleteClaimSpecFile(claimUid string) error {
+func (cdi *CDIHandler) writeSpaceDescriptor(claimUid string, spaceName string, descriptor *spacecrd.SpaceManifestEntry, owner *ArtifactOwner) error {
+	content, err := json.Marshal(descriptor)
+	if err != nil {
+		return fmt.Errorf("unable to encode space descriptor: %v", err)
+	}
+	_, err = cdi.artifacts.WriteFile(claimUid, spaceName, spaceDescriptorName, content, owner)
+	return err
+}
+
+// SetSpaceExpiration updates the expiration time in the descriptor of a
+// prepared space after its token was refreshed. Spaces prepared by older
+// versions have no descriptor, which is left alone.
+func (cdi *CDIHandler) SetSpaceExpiration(pc *PreparedClaim, expiration *metav1.Time) error {
+	path := filepath.Join(cdi.artifacts.ClaimPath(pc.ClaimUID, pc.SpaceName), spaceDescriptorName)
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read space descriptor: %v", err)
+	}
+	var descriptor spacecrd.SpaceManifestEntry
+	err = json.Unmarshal(content, &descriptor)
+	if err != nil {
+		return fmt.Errorf("unable to decode space descriptor %s: %v", path, err)
+	}
+	descriptor.ExpirationTime = expiration
+	return cdi.writeSpaceDescriptor(pc.ClaimUID, pc.SpaceName, &descriptor, pc.Owner)
+}
+
+// claimKubeconfig renders the kubeconfig for one space of a claim, pointing
+// at the API server as seen from containers with the space as the default
+// namespace. The token is read from a file, or obtained from the
+// ExecCredential helper, so that refreshed tokens are picked up without
+// rewriting the kubeconfig.
+func claimKubeconfig(info *ClaimSpecInfo, containerPath string) ([]byte, error) {
+	const name = "space"
+
+	kubeconfig := clientcmdapi.NewConfig()
+	kubeconfig.Clusters[name] = &clientcmdapi.Cluster{
+		Server:                   info.APIServer.Endpoint,
+		CertificateAuthorityData: info.APIServer.CAData,
+	}
+	kubeconfig.AuthInfos[name] = &clientcmdapi.AuthInfo{
+		TokenFile: path.Join(containerPath, "token"),
+	}
+	if info.CredentialHelper != "" {
+		kubeconfig.AuthInfos[name] = &clientcmdapi.AuthInfo{
+			Exec: &clientcmdapi.ExecConfig{
+				APIVersion:      "client.authentication.k8s.io/v1",
+				Command:         credentialHelperContainerPath,
+				Args:            []string{"--dir", containerPath},
+				InteractiveMode: clientcmdapi.NeverExecInteractiveMode,
+			},
+		}
+	}
+	kubeconfig.Contexts[name] = &clientcmdapi.Context{
+		Cluster:   name,
+		AuthInfo:  name,
+		Namespace: info.Namespace,
+	}
+	kubeconfig.CurrentContext = name
+
+	content, err := clientcmd.Write(*kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("unable to serialize kubeconfig: %v", err)
+	}
+	return content, nil
+}
+
+func (cdi *CDIHandler) DeleteClaimSpecFile(claimUid string, spaceName string) error {
 	logger := klog.FromContext(context.TODO())
 
-	hostPath := fmt.Sprintf("/tmp/%s", claimUid)
+	logger.Info("deleting claim artifacts", "claimUid", claimUid, "hostPath", cdi.artifacts.ClaimPath(claimUid, spaceName))
+	err := cdi.artifacts.Delete(claimUid, spaceName)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("deleting CDI spec", "claimUid", claimUid, "space", spaceName)
+	specName := claimSpecName(claimUid, spaceName)
+	return cdi.removeSpec(specName)
+}
+
+// DeletePreparedClaim removes the CDI spec and artifacts recorded in the
+// checkpoint for one space of a claim.
+func (cdi *CDIHandler) DeletePreparedClaim(pc *PreparedClaim) error {
+	logger := klog.FromContext(context.TODO())
 
-	logger.Info("deleting claim artifacts", "claimUid", claimUid, "hostPath", hostPath)
-	err := os.RemoveAll(hostPath)
+	logger.Info("deleting claim artifacts", "claimUid", pc.ClaimUID, "hostPath", cdi.artifacts.ClaimPath(pc.ClaimUID, pc.SpaceName))
+	err := cdi.artifacts.Delete(pc.ClaimUID, pc.SpaceName)
 	if err != nil {
 		return err
 	}
 
-	logger.Info("deleting CDI spec", "claimUid", claimUid)
-	specName := cdiapi.GenerateTransientSpecName(cdiVendor, cdiClass, claimUid)
-	return cdi.registry.SpecDB().RemoveSpec(specName)
+	logger.Info("deleting CDI spec", "claimUid", pc.ClaimUID, "specName", pc.CDISpecName)
+	return cdi.removeSpec(pc.CDISpecName)
+}
+
+// ClaimDevicesExist reports whether all of the given devices still resolve
+// to a CDI spec in the registry.
+func (cdi *CDIHandler) ClaimDevicesExist(devices []string) bool {
+	for _, device := range devices {
+		if cdi.registry.DeviceDB().GetDevice(device) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// ListClaimSpecNames returns the names of all claim specs written by the
+// driver, excluding the spec for common edits.
+func (cdi *CDIHandler) ListClaimSpecNames() []string {
+	var names []string
+	prefix := claimSpecName("", "")
+	for _, spec := range cdi.registry.SpecDB().GetVendorSpecs(cdiVendor) {
+		path := spec.GetPath()
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		if !strings.HasPrefix(name, prefix) || name == prefix+cdiCommonDeviceName || strings.HasPrefix(name, podSpecName("")) {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
 }
 
 func (cdi *CDIHandler) GetClaimDevices(claimUid string, space string) []string {
@@ -170,3 +469,65 @@ func (cdi *CDIHandler) GetClaimDevices(claimUid string, space string) []string {
 		cdiapi.QualifiedName(cdiVendor, cdiClass, space),
 	}
 }
+
+// GetPodDevice returns the CDI device for the artifacts of a pod.
+func (cdi *CDIHandler) GetPodDevice(podUid string) string {
+	return cdiapi.QualifiedName(cdiVendor, cdiClass, podDeviceName(podUid))
+}
+
+// CreatePodSpecFile writes the CDI spec which mounts the artifacts of a pod
+// and, if requested, points KUBECONFIG at its merged kubeconfig.
+func (cdi *CDIHandler) CreatePodSpecFile(podUid string, kubeconfig bool) error {
+	device := cdispec.Device{
+		Name: podDeviceName(podUid),
+		ContainerEdits: cdispec.ContainerEdits{
+			Mounts: []*cdispec.Mount{
+				{
+					HostPath:      cdi.artifacts.PodPath(podUid),
+					ContainerPath: podArtifactsContainerPath,
+					Options:       claimMountOptions,
+				},
+			},
+		},
+	}
+	if kubeconfig {
+		device.ContainerEdits.Env = append(device.ContainerEdits.Env,
+			fmt.Sprintf("KUBECONFIG=%s", path.Join(podArtifactsContainerPath, "kubeconfig")))
+	}
+
+	spec := &cdispec.Spec{
+		Kind:    cdiKind,
+		Devices: []cdispec.Device{device},
+	}
+
+	minVersion, err := cdiapi.MinimumRequiredVersion(spec)
+	if err != nil {
+		return fmt.Errorf("failed to get minimum required CDI spec version: %v", err)
+	}
+	spec.Version = minVersion
+
+	return cdi.writeSpec(spec, podSpecName(podUid))
+}
+
+// DeletePodSpecFile removes the CDI spec and artifacts of a pod.
+func (cdi *CDIHandler) DeletePodSpecFile(podUid string) error {
+	err := cdi.artifacts.DeletePod(podUid)
+	if err != nil {
+		return err
+	}
+	return cdi.removeSpec(podSpecName(podUid))
+}
+
+// ListPodSpecUids returns the UIDs of all pods with a CDI spec.
+func (cdi *CDIHandler) ListPodSpecUids() []string {
+	var podUids []string
+	prefix := podSpecName("")
+	for _, spec := range cdi.registry.SpecDB().GetVendorSpecs(cdiVendor) {
+		path := spec.GetPath()
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		if strings.HasPrefix(name, prefix) {
+			podUids = append(podUids, strings.TrimPrefix(name, prefix))
+		}
+	}
+	return podUids
+}
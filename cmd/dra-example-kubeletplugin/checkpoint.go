@@ -0,0 +1,233 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"sync"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	checkpointFileName = "checkpoint.json"
+	checkpointVersion  = "v1"
+)
+
+// PreparedClaim records everything the plugin created on the node for one
+// resource handle of a claim, so that it can be cleaned up after a restart.
+type PreparedClaim struct {
+	ClaimUID       string   `json:"claimUid"`
+	ClaimName      string   `json:"claimName"`
+	ClaimNamespace string   `json:"claimNamespace"`
+	SpaceName      string   `json:"spaceName,omitempty"`
+	Namespace      string   `json:"namespace"`
+	CDISpecName    string   `json:"cdiSpecName"`
+	CDIDevices     []string `json:"cdiDevices"`
+	ArtifactPaths  []string `json:"artifactPaths"`
+	// Owner is who the artifacts were made accessible to. It is reused
+	// when artifacts are rewritten.
+	Owner *ArtifactOwner `json:"owner,omitempty"`
+	// KubeconfigMounted is true if containers get the claim's kubeconfig.
+	KubeconfigMounted bool `json:"kubeconfigMounted,omitempty"`
+	// PodUIDs are the pods whose artifact directories include the claim.
+	// They are kept until the claim is unprepared, since running pods may
+	// have them mounted.
+	PodUIDs []string `json:"podUids,omitempty"`
+	// Consumers are the sorted UIDs of the pods on the node which reserved
+	// the claim when its pod artifacts were last built.
+	Consumers []string `json:"consumers,omitempty"`
+	// ExpirationTime is copied from the resource handle. No tokens are
+	// issued for the space beyond it.
+	ExpirationTime *metav1.Time `json:"expirationTime,omitempty"`
+	// Checksum covers the prepare request the entry was created for. A
+	// repeated request with the same checksum is answered from the entry.
+	Checksum string `json:"checksum"`
+}
+
+type checkpointData struct {
+	Version        string                    `json:"version"`
+	PreparedClaims map[string]*PreparedClaim `json:"preparedClaims"`
+}
+
+type checkpointFile struct {
+	Checksum uint32         `json:"checksum"`
+	Data     checkpointData `json:"data"`
+}
+
+// Checkpoint is the on-disk record of prepared claims. Every change is
+// written to a temporary file which is then renamed over the previous
+// checkpoint, so a crash never leaves a partially written file behind.
+type Checkpoint struct {
+	sync.Mutex
+	path string
+	data checkpointData
+}
+
+func NewCheckpoint(dir string) (*Checkpoint, error) {
+	c := &Checkpoint{
+		path: filepath.Join(dir, checkpointFileName),
+		data: checkpointData{
+			Version:        checkpointVersion,
+			PreparedClaims: make(map[string]*PreparedClaim),
+		},
+	}
+
+	content, err := os.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return c, c.write()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read checkpoint: %v", err)
+	}
+
+	var file checkpointFile
+	err = json.Unmarshal(content, &file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode checkpoint: %v", err)
+	}
+	if file.Data.Version != checkpointVersion {
+		return nil, fmt.Errorf("unsupported checkpoint version: %v", file.Data.Version)
+	}
+	checksum, err := checkpointChecksum(&file.Data)
+	if err != nil {
+		return nil, err
+	}
+	if checksum != file.Checksum {
+		return nil, fmt.Errorf("checkpoint %v is corrupt: checksum mismatch", c.path)
+	}
+
+	if file.Data.PreparedClaims != nil {
+		c.data.PreparedClaims = file.Data.PreparedClaims
+	}
+	return c, nil
+}
+
+// preparedClaimKey identifies the entry for one resource handle of a claim.
+func preparedClaimKey(claimUid string, spaceName string) string {
+	if spaceName == "" {
+		return claimUid
+	}
+	return claimUid + "/" + spaceName
+}
+
+// preparedClaimChecksum hashes the parts of a prepare request which
+// determine what gets written to the node.
+func preparedClaimChecksum(claim *nodeClaim) string {
+	h := sha256.New()
+	for _, field := range []string{claim.Uid, claim.Namespace, claim.Name, claim.ResourceHandle} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *Checkpoint) Get(key string) (*PreparedClaim, bool) {
+	c.Lock()
+	defer c.Unlock()
+	pc, ok := c.data.PreparedClaims[key]
+	return pc, ok
+}
+
+func (c *Checkpoint) List() map[string]*PreparedClaim {
+	c.Lock()
+	defer c.Unlock()
+	result := make(map[string]*PreparedClaim, len(c.data.PreparedClaims))
+	for key, pc := range c.data.PreparedClaims {
+		result[key] = pc
+	}
+	return result
+}
+
+func (c *Checkpoint) Add(key string, pc *PreparedClaim) error {
+	c.Lock()
+	defer c.Unlock()
+	c.data.PreparedClaims[key] = pc
+	return c.write()
+}
+
+func (c *Checkpoint) Remove(key string) error {
+	c.Lock()
+	defer c.Unlock()
+	if _, ok := c.data.PreparedClaims[key]; !ok {
+		return nil
+	}
+	delete(c.data.PreparedClaims, key)
+	return c.write()
+}
+
+func checkpointChecksum(data *checkpointData) (uint32, error) {
+	content, err := json.Marshal(data)
+	if err != nil {
+		return 0, fmt.Errorf("unable to encode checkpoint: %v", err)
+	}
+	return crc32.ChecksumIEEE(content), nil
+}
+
+func (c *Checkpoint) write() error {
+	checksum, err := checkpointChecksum(&c.data)
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(&checkpointFile{Checksum: checksum, Data: c.data})
+	if err != nil {
+		return fmt.Errorf("unable to encode checkpoint: %v", err)
+	}
+
+	return writeFileAtomic(c.path, content, 0600)
+}
+
+// writeFileAtomic replaces path with content by way of a synced temporary
+// file in the same directory.
+func writeFileAtomic(path string, content []byte, perm os.FileMode) error {
+	return writeFileAtomicFunc(path, content, func(name string) error {
+		return os.Chmod(name, perm)
+	})
+}
+
+// writeFileAtomicFunc is writeFileAtomic with a hook which sets up the
+// permissions of the temporary file before it replaces path.
+func writeFileAtomicFunc(path string, content []byte, setup func(name string) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(content)
+	if err == nil {
+		err = setup(tmp.Name())
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,197 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewCheckpoint(dir)
+	if err != nil {
+		t.Fatalf("unable to create checkpoint: %v", err)
+	}
+	pc := &PreparedClaim{
+		ClaimUID:   "uid-a",
+		ClaimName:  "claim-a",
+		Namespace:  "space-a",
+		CDIDevices: []string{"k8s.space.resource.example.com/claim=uid-a"},
+		Checksum:   "abc",
+	}
+	err = c.Add(preparedClaimKey("uid-a", "dev"), pc)
+	if err != nil {
+		t.Fatalf("unable to add claim: %v", err)
+	}
+	checkPerm(t, filepath.Join(dir, checkpointFileName), 0600)
+
+	reloaded, err := NewCheckpoint(dir)
+	if err != nil {
+		t.Fatalf("unable to reload checkpoint: %v", err)
+	}
+	got, ok := reloaded.Get("uid-a/dev")
+	if !ok || !reflect.DeepEqual(got, pc) {
+		t.Errorf("expected %+v after reload, got %+v", pc, got)
+	}
+
+	err = reloaded.Remove("uid-a/dev")
+	if err != nil {
+		t.Fatalf("unable to remove claim: %v", err)
+	}
+	reloaded, err = NewCheckpoint(dir)
+	if err != nil {
+		t.Fatalf("unable to reload checkpoint: %v", err)
+	}
+	if n := len(reloaded.List()); n != 0 {
+		t.Errorf("expected no claims after remove, got %d", n)
+	}
+}
+
+func TestCheckpointChecksum(t *testing.T) {
+	valid := checkpointData{
+		Version: checkpointVersion,
+		PreparedClaims: map[string]*PreparedClaim{
+			"uid-a": {ClaimUID: "uid-a", Namespace: "space-a", Checksum: "abc"},
+		},
+	}
+	validChecksum, err := checkpointChecksum(&valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		// content is written as the checkpoint file before it is loaded.
+		content func() []byte
+		valid   bool
+	}{
+		{
+			name: "valid",
+			content: func() []byte {
+				return mustMarshal(t, &checkpointFile{Checksum: validChecksum, Data: valid})
+			},
+			valid: true,
+		},
+		{
+			name: "modified data",
+			content: func() []byte {
+				data := valid
+				data.PreparedClaims = map[string]*PreparedClaim{
+					"uid-a": {ClaimUID: "uid-a", Namespace: "space-b", Checksum: "abc"},
+				}
+				return mustMarshal(t, &checkpointFile{Checksum: validChecksum, Data: data})
+			},
+		},
+		{
+			name: "modified checksum",
+			content: func() []byte {
+				return mustMarshal(t, &checkpointFile{Checksum: validChecksum + 1, Data: valid})
+			},
+		},
+		{
+			name: "unsupported version",
+			content: func() []byte {
+				data := valid
+				data.Version = "v0"
+				checksum, err := checkpointChecksum(&data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return mustMarshal(t, &checkpointFile{Checksum: checksum, Data: data})
+			},
+		},
+		{
+			name: "truncated",
+			content: func() []byte {
+				content := mustMarshal(t, &checkpointFile{Checksum: validChecksum, Data: valid})
+				return content[:len(content)/2]
+			},
+		},
+		{
+			name: "empty",
+			content: func() []byte {
+				return nil
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			err := os.WriteFile(filepath.Join(dir, checkpointFileName), tc.content(), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, err := NewCheckpoint(dir)
+			switch {
+			case !tc.valid && err == nil:
+				t.Errorf("expected checkpoint to be rejected")
+			case tc.valid && err != nil:
+				t.Errorf("unable to load checkpoint: %v", err)
+			case tc.valid:
+				if !reflect.DeepEqual(c.List(), valid.PreparedClaims) {
+					t.Errorf("expected claims %+v, got %+v", valid.PreparedClaims, c.List())
+				}
+			}
+		})
+	}
+}
+
+func TestPreparedClaimChecksum(t *testing.T) {
+	base := nodeClaim{Namespace: "default", Uid: "uid-a", Name: "claim-a", ResourceHandle: "space-a"}
+	checksum := preparedClaimChecksum(&base)
+	if again := preparedClaimChecksum(&base); again != checksum {
+		t.Errorf("expected the same checksum for the same request, got %s and %s", checksum, again)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(claim *nodeClaim)
+	}{
+		{"namespace", func(claim *nodeClaim) { claim.Namespace = "other" }},
+		{"uid", func(claim *nodeClaim) { claim.Uid = "uid-b" }},
+		{"name", func(claim *nodeClaim) { claim.Name = "claim-b" }},
+		{"resource handle", func(claim *nodeClaim) { claim.ResourceHandle = "space-b" }},
+		// Fields are separated, so moving text between them changes the
+		// checksum as well.
+		{"shifted fields", func(claim *nodeClaim) {
+			claim.Name, claim.ResourceHandle = "claim-as", "pace-a"
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			claim := base
+			tc.modify(&claim)
+			if preparedClaimChecksum(&claim) == checksum {
+				t.Errorf("expected a different checksum after changing the %s", tc.name)
+			}
+		})
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	t.Helper()
+	content, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
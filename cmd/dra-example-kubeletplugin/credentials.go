@@ -0,0 +1,137 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	spacecrd "sigs.k8s.io/dra-example-driver/api/example.com/resource/space/v1alpha1"
+)
+
+const (
+	// tokenExpirationSeconds is the lifetime requested for space tokens.
+	tokenExpirationSeconds = 3600
+	// minTokenExpirationSeconds is the shortest lifetime the API server
+	// accepts for a token.
+	minTokenExpirationSeconds = 600
+)
+
+// errSpaceExpired is returned when credentials are requested for a space
+// whose lifetime has passed.
+var errSpaceExpired = errors.New("space has expired")
+
+// tokenLifetime returns the lifetime to request for a token of a space
+// which expires at expiration, if its lifetime is bounded. Tokens do not
+// outlive the space by more than the shortest lifetime the API server
+// accepts.
+func tokenLifetime(now time.Time, expiration *metav1.Time) (int64, error) {
+	if expiration == nil {
+		return tokenExpirationSeconds, nil
+	}
+	remaining := expiration.Sub(now)
+	if remaining <= 0 {
+		return 0, fmt.Errorf("%w at %v", errSpaceExpired, expiration.Time)
+	}
+	seconds := int64((remaining + time.Second - 1) / time.Second)
+	if seconds > tokenExpirationSeconds {
+		seconds = tokenExpirationSeconds
+	}
+	if seconds < minTokenExpirationSeconds {
+		seconds = minTokenExpirationSeconds
+	}
+	return seconds, nil
+}
+
+// credentialExpiration returns when credentials with a token expiring at
+// tokenExpiration stop working for a space which expires at
+// spaceExpiration, if its lifetime is bounded.
+func credentialExpiration(tokenExpiration metav1.Time, spaceExpiration *metav1.Time) *metav1.Time {
+	if spaceExpiration != nil && spaceExpiration.Before(&tokenExpiration) {
+		return spaceExpiration.DeepCopy()
+	}
+	return tokenExpiration.DeepCopy()
+}
+
+// requestToken requests a token for the ServiceAccount the controller
+// created in the namespace of a space which expires at expiration, if its
+// lifetime is bounded. Without audiences, the token is valid for the API
+// server.
+func (d *driver) requestToken(ctx context.Context, namespace string, audiences []string, expiration *metav1.Time) (*authenticationv1.TokenRequest, error) {
+	expirationSeconds, err := tokenLifetime(time.Now(), expiration)
+	if err != nil {
+		return nil, err
+	}
+	request := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{
+			Audiences:         audiences,
+			ExpirationSeconds: &expirationSeconds,
+		},
+	}
+
+	serviceAccounts := d.clientsets.Core.CoreV1().ServiceAccounts(namespace)
+	token, err := serviceAccounts.CreateToken(ctx, spacecrd.SpaceServiceAccountName, request, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to request token for service account %s/%s: %v", namespace, spacecrd.SpaceServiceAccountName, err)
+	}
+	return token, nil
+}
+
+// refreshToken requests a new token for a prepared space and atomically
+// replaces the token file in its artifact directory. The kubeconfig refers
+// to the token file, so it needs no update, but the expiration time in the
+// space descriptor and the spaces manifests of pods does. The claim lock is
+// held so that a concurrent unprepare cannot race with the writes.
+func (d *driver) refreshToken(ctx context.Context, key string) (time.Time, error) {
+	pc, ok := d.checkpoint.Get(key)
+	if !ok {
+		return time.Time{}, fmt.Errorf("claim %s is no longer prepared", key)
+	}
+
+	lock := d.lock.Get(pc.ClaimUID)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// The claim may have been unprepared while waiting for the lock.
+	if _, ok := d.checkpoint.Get(key); !ok {
+		return time.Time{}, fmt.Errorf("claim %s is no longer prepared", key)
+	}
+
+	token, err := d.requestToken(ctx, pc.Namespace, nil, pc.ExpirationTime)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	_, err = d.cdi.artifacts.WriteFile(pc.ClaimUID, pc.SpaceName, "token", []byte(token.Status.Token), pc.Owner)
+	if err != nil {
+		return time.Time{}, err
+	}
+	err = d.cdi.SetSpaceExpiration(pc, credentialExpiration(token.Status.ExpirationTimestamp, pc.ExpirationTime))
+	if err != nil {
+		return time.Time{}, err
+	}
+	err = d.refreshSpacesManifests(ctx, pc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return token.Status.ExpirationTimestamp.Time, nil
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTokenLifetime(t *testing.T) {
+	now := time.Now()
+	at := func(d time.Duration) *metav1.Time {
+		expiration := metav1.NewTime(now.Add(d))
+		return &expiration
+	}
+
+	tests := []struct {
+		name       string
+		expiration *metav1.Time
+		want       int64
+		expired    bool
+	}{
+		{name: "unbounded", expiration: nil, want: tokenExpirationSeconds},
+		{name: "far away", expiration: at(24 * time.Hour), want: tokenExpirationSeconds},
+		{name: "within a token lifetime", expiration: at(30 * time.Minute), want: 1800},
+		{name: "rounded up", expiration: at(30*time.Minute + time.Millisecond), want: 1801},
+		{name: "below the minimum", expiration: at(time.Minute), want: minTokenExpirationSeconds},
+		{name: "now", expiration: at(0), expired: true},
+		{name: "past", expiration: at(-time.Minute), expired: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := tokenLifetime(now, tc.expiration)
+			if tc.expired {
+				if !errors.Is(err, errSpaceExpired) {
+					t.Fatalf("expected the space to have expired, got %d, %v", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("expected %d seconds, got %d", tc.want, got)
+			}
+		})
+	}
+}
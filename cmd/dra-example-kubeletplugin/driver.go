@@ -19,23 +19,39 @@ package main
 import (
 	"context"
 	"fmt"
-	"sync"
+	"time"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
-	dra "k8s.io/kubelet/pkg/apis/dra/v1alpha3"
 	spacecrd "sigs.k8s.io/dra-example-driver/api/example.com/resource/space/v1alpha1"
+	"sigs.k8s.io/dra-example-driver/pkg/flags"
 )
 
-const (
-	DriverAPIGroup     = spacecrd.GroupName
-	ResourceClaimLabel = DriverAPIGroup + "/resourceclaim"
-)
+type driver struct {
+	lock       *PerClaimMutex
+	workers    int
+	clientsets flags.ClientSets
+	nodeName   string
+	apiServer  *APIServerInfo
+	cache      *PodClaimCache
+	cdi        *CDIHandler
+	checkpoint *Checkpoint
+	refresher  *TokenRefresher
+	metadata   *MetadataServer
 
-var _ dra.NodeServer = &driver{}
+	// mergedKubeconfig enables the per-pod kubeconfig with a context for
+	// every space claim of the pod, and spacesManifest the per-pod list of
+	// all its spaces.
+	mergedKubeconfig bool
+	spacesManifest   bool
 
-type driver struct {
-	sync.Mutex
-	cdi *CDIHandler
+	// handleKeys verifies the signatures on resource handles, if enabled.
+	handleKeys *flags.HandleKeysConfig
+
+	// credentialHelper is the host path of the ExecCredential helper, if
+	// kubeconfigs use it.
+	credentialHelper string
 }
 
 func NewDriver(ctx context.Context, config *Config) (*driver, error) {
@@ -52,73 +68,458 @@ func NewDriver(ctx context.Context, config *Config) (*driver, error) {
 		return nil, fmt.Errorf("unable to create CDI spec file for common edits: %v", err)
 	}
 
-	d := &driver{cdi: cdi}
+	apiServer, err := NewAPIServerInfo(config)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Using API server endpoint for claims", "endpoint", apiServer.Endpoint)
+
+	cache, err := NewPodClaimCache(config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create informers: %v", err)
+	}
+	err = cache.Start(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	checkpoint, err := NewCheckpoint(DriverPluginPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to load checkpoint: %v", err)
+	}
+
+	d := &driver{
+		lock:       NewPerClaimMutex(),
+		workers:    config.flags.prepareWorkers,
+		clientsets: config.clientsets,
+		nodeName:   config.flags.nodeName,
+		apiServer:  apiServer,
+		cache:      cache,
+		cdi:        cdi,
+		checkpoint: checkpoint,
+		handleKeys: &config.flags.handleKeys,
+
+		mergedKubeconfig: config.flags.mergedKubeconfig,
+		spacesManifest:   config.flags.spacesManifest,
+	}
+
+	d.refresher = NewTokenRefresher(ctx, config.flags.tokenRefreshFraction, d.refreshToken)
+
+	// Failing to clean up is not fatal: leftovers only waste space and are
+	// retried on the next start.
+	err = d.reconcileStaleClaims(ctx)
+	if err != nil {
+		logger.Error(err, "Unable to reconcile stale claims")
+	}
+
+	if config.flags.metadataSocket {
+		d.metadata = NewMetadataServer(d)
+	}
+
+	if config.flags.kubeconfigExecCredential {
+		d.credentialHelper, err = cdi.artifacts.InstallHelper(config.flags.credentialHelperPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Tokens of claims prepared before a restart may be about to expire,
+	// and their metadata sockets went away with the previous process.
+	for key, pc := range d.checkpoint.List() {
+		d.refresher.Start(key, time.Time{}, time.Time{})
+		if d.metadata != nil {
+			err := d.restartMetadataServer(ctx, key, pc)
+			if err != nil {
+				logger.Error(err, "Unable to restart metadata socket", "claimUid", pc.ClaimUID, "space", pc.SpaceName)
+			}
+		}
+	}
 
 	return d, nil
 }
 
+// Shutdown stops the metadata sockets and token refreshes of all prepared
+// claims. Both are restarted from the checkpoint by the next instance.
 func (d *driver) Shutdown(ctx context.Context) error {
 	logger := klog.FromContext(ctx)
 	logger.Info("Shutdown")
+
+	if d.metadata != nil {
+		d.metadata.StopAll(ctx)
+	}
+	d.refresher.StopAll()
 	return nil
 }
 
-func (d *driver) NodePrepareResources(ctx context.Context, req *dra.NodePrepareResourcesRequest) (*dra.NodePrepareResourcesResponse, error) {
-	logger := klog.FromContext(ctx)
-	logger.Info("NodePrepareResources", "numClaims", len(req.Claims))
-	rsp := &dra.NodePrepareResourcesResponse{Claims: map[string]*dra.NodePrepareResourceResponse{}}
-	for _, claim := range req.Claims {
-		rsp.Claims[claim.Uid] = d.prepareResource(ctx, claim)
+// prepareClaims prepares a batch of claims concurrently and returns the
+// result for each claim UID.
+func (d *driver) prepareClaims(ctx context.Context, claims []*nodeClaim) map[string]*prepareResult {
+	results := make([]*prepareResult, len(claims))
+	workqueue.ParallelizeUntil(ctx, d.workers, len(claims), func(i int) {
+		results[i] = d.prepareResource(ctx, claims[i])
+	})
+
+	merged := make(map[string]*prepareResult)
+	for i, claim := range claims {
+		// Controllers before the handle list passed a claim with several
+		// spaces once per space, but the kubelet expects a single response
+		// per claim.
+		result := results[i]
+		if result == nil {
+			result = &prepareResult{Error: fmt.Sprintf("claim not prepared: %v", ctx.Err())}
+		}
+		if prev, ok := merged[claim.Uid]; ok {
+			mergePrepareResults(prev, result)
+		} else {
+			merged[claim.Uid] = result
+		}
 	}
-	return rsp, nil
+	return merged
 }
 
-func (d *driver) prepareResource(ctx context.Context, claim *dra.Claim) *dra.NodePrepareResourceResponse {
-	d.Lock()
-	defer d.Unlock()
+func mergePrepareResults(dst, src *prepareResult) {
+	if dst.Error == "" {
+		dst.Error = src.Error
+	}
+
+	seen := make(map[string]bool)
+	for _, device := range dst.CDIDevices {
+		seen[device] = true
+	}
+	for _, device := range src.CDIDevices {
+		if !seen[device] {
+			dst.CDIDevices = append(dst.CDIDevices, device)
+			seen[device] = true
+		}
+	}
+}
+
+// restartMetadataServer serves the metadata socket of a space prepared
+// before the plugin restarted.
+func (d *driver) restartMetadataServer(ctx context.Context, key string, pc *PreparedClaim) error {
+	rc, err := d.cache.GetClaim(ctx, pc.ClaimNamespace, pc.ClaimName, pc.ClaimUID)
+	if err != nil {
+		return err
+	}
+	apiServer, err := d.claimAPIServer(rc)
+	if err != nil {
+		return err
+	}
+	return d.metadata.Start(ctx, key, pc, apiServer)
+}
+
+// verifyResourceHandle checks that the handle was signed by the controller
+// for this claim. Once signing is enabled, unsigned handles and handles
+// which cannot be checked because the keys are unavailable are rejected.
+func (d *driver) verifyResourceHandle(handle *spacecrd.ResourceHandle, claimUid string) error {
+	if !d.handleKeys.Enabled() {
+		return nil
+	}
+	keys, err := d.handleKeys.LoadKeys()
+	if err != nil {
+		return err
+	}
+	return spacecrd.VerifyResourceHandle(handle, claimUid, keys)
+}
+
+func (d *driver) prepareResource(ctx context.Context, claim *nodeClaim) *prepareResult {
+	lock := d.lock.Get(claim.Uid)
+	lock.Lock()
+	defer lock.Unlock()
+
+	handles, err := spacecrd.DecodeResourceHandles(claim.ResourceHandle)
+	if err != nil {
+		return &prepareResult{Error: err.Error()}
+	}
+
+	// The handle of a claim with several spaces carries all of them, and
+	// the kubelet expects a single result for the claim.
+	rsp := &prepareResult{}
+	for _, handle := range handles {
+		mergePrepareResults(rsp, d.prepareSpace(ctx, claim, handle))
+		if rsp.Error != "" {
+			break
+		}
+	}
+	return rsp
+}
+
+func (d *driver) prepareSpace(ctx context.Context, claim *nodeClaim, handle *spacecrd.ResourceHandle) *prepareResult {
+	logger := klog.FromContext(ctx)
+
+	rsp := &prepareResult{}
+	err := d.verifyResourceHandle(handle, claim.Uid)
+	if err != nil {
+		rsp.Error = fmt.Sprintf("refusing to prepare claim: %v", err)
+		return rsp
+	}
+	if handle.ExpirationTime != nil && !time.Now().Before(handle.ExpirationTime.Time) {
+		rsp.Error = fmt.Sprintf("refusing to prepare claim: space expired at %v", handle.ExpirationTime.Time)
+		return rsp
+	}
+	spaceName, ns := handle.SpaceName, handle.Namespace
+	key := preparedClaimKey(claim.Uid, spaceName)
+	checksum := preparedClaimChecksum(claim)
+
+	// Repeated calls for an unchanged claim are answered from the checkpoint
+	// as long as what it recorded is still present on the node.
+	if pc, ok := d.checkpoint.Get(key); ok && pc.Checksum == checksum && d.cdi.ClaimDevicesExist(pc.CDIDevices) && !d.podConsumersChanged(ctx, pc) {
+		logger.V(4).Info("claim already prepared", "claimUid", claim.Uid, "space", spaceName)
+		rsp.CDIDevices = pc.CDIDevices
+		return rsp
+	}
+
+	rc, pods, err := d.cache.WaitForReservingPods(ctx, claim.Namespace, claim.Name, claim.Uid)
+	if err != nil {
+		rsp.Error = fmt.Sprintf("unable to look up pods for claim: %v", err)
+		return rsp
+	}
+
+	// The handle alone is not trusted to name the right namespace; it must
+	// also be labeled for this claim before credentials are issued for it.
+	err = d.cache.WaitForClaimNamespace(ctx, claim.Uid, ns)
+	if err != nil {
+		rsp.Error = fmt.Sprintf("refusing to prepare claim: %v", err)
+		return rsp
+	}
+
+	envPrefix, err := d.claimEnvPrefix(ctx, rc, pods)
+	if err != nil {
+		rsp.Error = fmt.Sprintf("unable to determine env prefix for claim: %v", err)
+		return rsp
+	}
+
+	apiServer, err := d.claimAPIServer(rc)
+	if err != nil {
+		rsp.Error = fmt.Sprintf("unable to determine API server for claim: %v", err)
+		return rsp
+	}
+	// The cluster was fixed when the claim was allocated; containers are
+	// not pointed elsewhere if the class has been changed since.
+	if handle.Cluster != "" && handle.Cluster != apiServer.Endpoint {
+		rsp.Error = fmt.Sprintf("refusing to prepare claim: allocated for cluster %s, but its class now names %s", handle.Cluster, apiServer.Endpoint)
+		return rsp
+	}
+
+	params, err := d.cache.GetClaimParametersFor(rc)
+	if err != nil {
+		rsp.Error = fmt.Sprintf("unable to get parameters for claim: %v", err)
+		return rsp
+	}
+
+	// Handles from older controllers carry no credential settings; take
+	// them from the claim parameters instead.
+	credentialLayout, serviceAccountMountPath := handle.CredentialLayout, handle.ServiceAccountMountPath
+	if credentialLayout == "" {
+		credentialLayout, serviceAccountMountPath = params.CredentialLayout, params.ServiceAccountMountPath
+	}
+
+	owner := claimArtifactOwner(pods)
 
-	rsp := &dra.NodePrepareResourceResponse{}
-	ns := claim.GetResourceHandle()
+	token, err := d.requestToken(ctx, ns, nil, handle.ExpirationTime)
+	if err != nil {
+		rsp.Error = fmt.Sprintf("unable to request credentials for claim: %v", err)
+		return rsp
+	}
 
-	err := d.cdi.CreateClaimSpecFile(claim.Uid, claim.Name, ns)
+	artifactPaths, err := d.cdi.CreateClaimSpecFile(&ClaimSpecInfo{
+		ClaimUID:                claim.Uid,
+		ClaimName:               claim.Name,
+		EnvPrefix:               envPrefix,
+		SpaceName:               spaceName,
+		Namespace:               ns,
+		APIServer:               apiServer,
+		Token:                   []byte(token.Status.Token),
+		CredentialLayout:        credentialLayout,
+		ServiceAccountMountPath: serviceAccountMountPath,
+		CredentialHelper:        d.credentialHelper,
+		Owner:                   owner,
+		ServiceAccount:          handle.ServiceAccount,
+		Role:                    handle.Role,
+		ExpirationTime:          credentialExpiration(token.Status.ExpirationTimestamp, handle.ExpirationTime),
+		MetadataSocket:          d.metadata != nil,
+	})
 	if err != nil {
 		rsp.Error = fmt.Sprintf("unable to create CDI spec file for claim: %v", err)
 		return rsp
 	}
 
 	cdiDevices := d.cdi.GetClaimDevices(claim.Uid, ns)
+
+	// The CDI devices of a claim are shared by all pods using it, so one
+	// artifact directory is mounted into all of them. It is rebuilt from
+	// the current consumers on every prepare. The directory mounted before
+	// is reused, and kept while it is no longer mounted, because running
+	// pods keep using it when the consumers change.
+	var artifactsPod *corev1.Pod
+	var podUids, consumers []string
+	if d.mergedKubeconfig || d.spacesManifest {
+		artifactsPod = podArtifactsPod(ctx, rc, pods)
+		consumers = reservingPodUids(pods)
+	}
+	prev, hasPrev := d.checkpoint.Get(key)
+	if hasPrev {
+		podUids = prev.PodUIDs
+	}
+	if artifactsPod != nil {
+		if len(podUids) == 0 {
+			podUids = []string{string(artifactsPod.UID)}
+		}
+		cdiDevices = append(cdiDevices, d.cdi.GetPodDevice(podUids[0]))
+	}
+
+	pc := &PreparedClaim{
+		ClaimUID:       claim.Uid,
+		ClaimName:      claim.Name,
+		ClaimNamespace: claim.Namespace,
+		SpaceName:      spaceName,
+		Namespace:      ns,
+		CDISpecName:    claimSpecName(claim.Uid, spaceName),
+		CDIDevices:     cdiDevices,
+		ArtifactPaths:  artifactPaths,
+		Owner:          owner,
+		Checksum:       checksum,
+
+		KubeconfigMounted: credentialLayout != spacecrd.CredentialLayoutServiceAccount,
+		PodUIDs:           podUids,
+		Consumers:         consumers,
+		ExpirationTime:    handle.ExpirationTime,
+	}
+	err = d.checkpoint.Add(key, pc)
 	if err != nil {
-		rsp.Error = fmt.Sprintf("unable to get CDI devices names: %v", err)
+		rsp.Error = fmt.Sprintf("unable to checkpoint prepared claim: %v", err)
 		return rsp
 	}
 
+	// Pod artifacts are built from the checkpoint, so the entry is written
+	// first. Until the steps below have all succeeded it is removed again
+	// on failure, so that the retry by the kubelet does all of them anew
+	// instead of being answered from the checkpoint. An entry from an
+	// earlier prepare is put back without its checksum instead, so that
+	// the pod artifact directories it records are still cleaned up.
+	defer func() {
+		if rsp.Error == "" {
+			return
+		}
+		d.refresher.Stop(key)
+		if d.metadata != nil {
+			d.metadata.Stop(ctx, key)
+		}
+		var err error
+		if hasPrev {
+			restored := *prev
+			restored.Checksum = ""
+			err = d.checkpoint.Add(key, &restored)
+		} else {
+			err = d.checkpoint.Remove(key)
+		}
+		if err != nil {
+			logger.Error(err, "Unable to reset checkpoint of claim which failed to prepare", "claimUid", claim.Uid, "space", spaceName)
+		}
+	}()
+
+	if d.metadata != nil {
+		err = d.metadata.Start(ctx, key, pc, apiServer)
+		if err != nil {
+			rsp.Error = fmt.Sprintf("unable to serve metadata socket for claim: %v", err)
+			return rsp
+		}
+	}
+
+	d.refresher.Start(key, time.Now(), token.Status.ExpirationTimestamp.Time)
+
+	if artifactsPod != nil {
+		err = d.updatePodArtifacts(ctx, podUids[0], artifactsPod, owner)
+		if err != nil {
+			rsp.Error = fmt.Sprintf("unable to write artifacts for pod: %v", err)
+			return rsp
+		}
+	}
+
 	rsp.CDIDevices = cdiDevices
 
 	return rsp
 }
 
-func (d *driver) NodeUnprepareResources(ctx context.Context, req *dra.NodeUnprepareResourcesRequest) (*dra.NodeUnprepareResourcesResponse, error) {
-	logger := klog.FromContext(ctx)
-	logger.Info("NodeUnprepareResources", "numClaims", len(req.Claims))
+// unprepareClaims unprepares a batch of claims concurrently and returns
+// the result for each claim UID.
+func (d *driver) unprepareClaims(ctx context.Context, claims []*nodeClaim) map[string]*unprepareResult {
+	results := make([]*unprepareResult, len(claims))
+	workqueue.ParallelizeUntil(ctx, d.workers, len(claims), func(i int) {
+		results[i] = d.unprepareResource(ctx, claims[i])
+	})
+
+	merged := make(map[string]*unprepareResult)
+	for i, claim := range claims {
+		result := results[i]
+		if result == nil {
+			result = &unprepareResult{Error: fmt.Sprintf("claim not unprepared: %v", ctx.Err())}
+		}
+		if prev, ok := merged[claim.Uid]; !ok || prev.Error == "" {
+			merged[claim.Uid] = result
+		}
+	}
+	return merged
+}
 
-	rsp := &dra.NodeUnprepareResourcesResponse{Claims: map[string]*dra.NodeUnprepareResourceResponse{}}
+func (d *driver) unprepareResource(ctx context.Context, claim *nodeClaim) *unprepareResult {
+	lock := d.lock.Get(claim.Uid)
+	lock.Lock()
+	defer lock.Unlock()
 
-	for _, claim := range req.Claims {
-		rsp.Claims[claim.Uid] = d.unprepareResource(ctx, claim)
+	handles, err := spacecrd.DecodeResourceHandles(claim.ResourceHandle)
+	if err != nil {
+		return &unprepareResult{Error: err.Error()}
 	}
 
-	return rsp, nil
+	// Every space is cleaned up even if another one fails, and the first
+	// error is reported so the kubelet retries.
+	rsp := &unprepareResult{}
+	for _, handle := range handles {
+		result := d.unprepareSpace(ctx, claim, handle)
+		if rsp.Error == "" {
+			rsp.Error = result.Error
+		}
+	}
+	return rsp
 }
 
-func (d *driver) unprepareResource(ctx context.Context, claim *dra.Claim) *dra.NodeUnprepareResourceResponse {
-	d.Lock()
-	defer d.Unlock()
+func (d *driver) unprepareSpace(ctx context.Context, claim *nodeClaim, handle *spacecrd.ResourceHandle) *unprepareResult {
+	rsp := &unprepareResult{}
+	spaceName := handle.SpaceName
+	key := preparedClaimKey(claim.Uid, spaceName)
+
+	d.refresher.Stop(key)
+	if d.metadata != nil {
+		d.metadata.Stop(ctx, key)
+	}
 
-	rsp := &dra.NodeUnprepareResourceResponse{}
+	// Claims prepared before the checkpoint existed have no entry; fall back
+	// to deriving what to delete from the claim.
+	pc, ok := d.checkpoint.Get(key)
+	if !ok {
+		err := d.cdi.DeleteClaimSpecFile(claim.Uid, spaceName)
+		if err != nil {
+			rsp.Error = fmt.Sprintf("unable to delete CDI spec file for claim: %v", err)
+		}
+		return rsp
+	}
 
-	err := d.cdi.DeleteClaimSpecFile(claim.Uid)
+	err := d.cdi.DeletePreparedClaim(pc)
 	if err != nil {
 		rsp.Error = fmt.Sprintf("unable to delete CDI spec file for claim: %v", err)
+		return rsp
+	}
+
+	err = d.checkpoint.Remove(key)
+	if err != nil {
+		rsp.Error = fmt.Sprintf("unable to update checkpoint for claim: %v", err)
+		return rsp
+	}
+
+	err = d.deletePodArtifacts(ctx, pc.PodUIDs)
+	if err != nil {
+		rsp.Error = fmt.Sprintf("unable to delete pod artifacts for claim: %v", err)
 	}
 
 	return rsp
@@ -0,0 +1,419 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	resourcev1 "k8s.io/api/resource/v1alpha2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	corefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+
+	spacecrd "sigs.k8s.io/dra-example-driver/api/example.com/resource/space/v1alpha1"
+	examplefake "sigs.k8s.io/dra-example-driver/pkg/example.com/resource/clientset/versioned/fake"
+	"sigs.k8s.io/dra-example-driver/pkg/flags"
+)
+
+const (
+	testNodeName   = "node-1"
+	testClassName  = "space"
+	testNamespace  = "default"
+	testAPIServer  = "https://10.96.0.1:443"
+	testTokenValue = "token"
+)
+
+// testDriver is a kubelet plugin driver backed by fake clientsets, with all
+// of its files below a temporary directory.
+type testDriver struct {
+	*driver
+	root string
+	core *corefake.Clientset
+}
+
+func newTestDriver(tb testing.TB, workers int) *testDriver {
+	tb.Helper()
+
+	root := tb.TempDir()
+	setDriverName(spacecrd.GroupName)
+	PluginRegistrationPath = filepath.Join(root, "registration.sock")
+	DriverPluginPath = filepath.Join(root, "plugin")
+	DriverPluginSocketPath = filepath.Join(DriverPluginPath, "plugin.sock")
+	err := os.MkdirAll(DriverPluginPath, 0750)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	core := corefake.NewSimpleClientset(&resourcev1.ResourceClass{
+		ObjectMeta: metav1.ObjectMeta{Name: testClassName},
+		DriverName: DriverName,
+	})
+	core.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		return true, &authenticationv1.TokenRequest{
+			Status: authenticationv1.TokenRequestStatus{
+				Token:               testTokenValue,
+				ExpirationTimestamp: metav1.NewTime(time.Now().Add(time.Hour)),
+			},
+		}, nil
+	})
+
+	config := &Config{
+		flags: &Flags{
+			nodeName:             testNodeName,
+			cdiRoot:              filepath.Join(root, "cdi"),
+			claimArtifactsRoot:   filepath.Join(root, "artifacts"),
+			prepareWorkers:       workers,
+			tokenRefreshFraction: 0.8,
+		},
+		restConfig: &rest.Config{Host: testAPIServer},
+		clientsets: flags.ClientSets{
+			Core:    core,
+			Example: examplefake.NewSimpleClientset(),
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tb.Cleanup(cancel)
+	d, err := NewDriver(ctx, config)
+	if err != nil {
+		tb.Fatalf("unable to create driver: %v", err)
+	}
+	return &testDriver{driver: d, root: root, core: core}
+}
+
+// addClaim creates an allocated claim with the given spaces, their
+// namespaces and a pod on the node which reserves the claim, and returns
+// the claim as the kubelet passes it to the plugin.
+func (td *testDriver) addClaim(tb testing.TB, name string, spaceNames ...string) *nodeClaim {
+	tb.Helper()
+	ctx := context.Background()
+	uid := types.UID("uid-" + name)
+
+	if len(spaceNames) == 0 {
+		spaceNames = []string{""}
+	}
+	var handles []*spacecrd.ResourceHandle
+	for _, spaceName := range spaceNames {
+		ns := "space-" + name
+		if spaceName != "" {
+			ns += "-" + spaceName
+		}
+		_, err := td.core.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   ns,
+				Labels: map[string]string{ResourceClaimLabel: string(uid)},
+			},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		handles = append(handles, &spacecrd.ResourceHandle{SpaceName: spaceName, Namespace: ns})
+	}
+	data, err := spacecrd.EncodeResourceHandles(handles)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod-" + name, Namespace: testNamespace, UID: types.UID("pod-uid-" + name)},
+		Spec: corev1.PodSpec{
+			NodeName: testNodeName,
+			ResourceClaims: []corev1.PodResourceClaim{{
+				Name:   "space",
+				Source: corev1.ClaimSource{ResourceClaimName: &name},
+			}},
+			Containers: []corev1.Container{{
+				Name: "ctr",
+				Resources: corev1.ResourceRequirements{
+					Claims: []corev1.ResourceClaim{{Name: "space"}},
+				},
+			}},
+		},
+	}
+	_, err = td.core.CoreV1().Pods(testNamespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	claim := &resourcev1.ResourceClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: testNamespace, UID: uid},
+		Spec:       resourcev1.ResourceClaimSpec{ResourceClassName: testClassName},
+		Status: resourcev1.ResourceClaimStatus{
+			DriverName: DriverName,
+			Allocation: &resourcev1.AllocationResult{
+				ResourceHandles: []resourcev1.ResourceHandle{{DriverName: DriverName, Data: data}},
+			},
+			ReservedFor: []resourcev1.ResourceClaimConsumerReference{{
+				Resource: "pods",
+				Name:     pod.Name,
+				UID:      pod.UID,
+			}},
+		},
+	}
+	_, err = td.core.ResourceV1alpha2().ResourceClaims(testNamespace).Create(ctx, claim, metav1.CreateOptions{})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return &nodeClaim{
+		Namespace:      testNamespace,
+		Uid:            string(uid),
+		Name:           name,
+		ResourceHandle: data,
+	}
+}
+
+func TestPrepareMultiSpaceClaim(t *testing.T) {
+	td := newTestDriver(t, 2)
+	claim := td.addClaim(t, "multi", "dev", "prod")
+	ctx := context.Background()
+
+	results := td.prepareClaims(ctx, []*nodeClaim{claim})
+	if len(results) != 1 {
+		t.Fatalf("expected one result for the claim, got %d", len(results))
+	}
+	result := results[claim.Uid]
+	if result == nil || result.Error != "" {
+		t.Fatalf("unable to prepare claim: %+v", result)
+	}
+	for _, ns := range []string{"space-multi-dev", "space-multi-prod"} {
+		device := td.cdi.GetClaimDevices(claim.Uid, ns)[1]
+		if !containsString(result.CDIDevices, device) {
+			t.Errorf("expected CDI device %s for namespace %s, got %v", device, ns, result.CDIDevices)
+		}
+	}
+	if n := len(td.checkpoint.List()); n != 2 {
+		t.Errorf("expected both spaces to be checkpointed, got %d entries", n)
+	}
+
+	unprepared := td.unprepareClaims(ctx, []*nodeClaim{claim})
+	if len(unprepared) != 1 || unprepared[claim.Uid].Error != "" {
+		t.Fatalf("unable to unprepare claim: %+v", unprepared)
+	}
+	if n := len(td.checkpoint.List()); n != 0 {
+		t.Errorf("expected no checkpointed spaces after unprepare, got %d", n)
+	}
+}
+
+// TestPrepareRetry checks that a claim which failed to prepare after it was
+// checkpointed is prepared completely when the kubelet retries, rather than
+// answered from the checkpoint.
+func TestPrepareRetry(t *testing.T) {
+	td := newTestDriver(t, 1)
+	td.metadata = NewMetadataServer(td.driver)
+	ctx := context.Background()
+	t.Cleanup(func() { td.metadata.StopAll(ctx) })
+	claim := td.addClaim(t, "test")
+	key := preparedClaimKey(claim.Uid, "")
+
+	// A directory in place of the metadata socket makes serving it fail.
+	path, err := td.metadata.metadataSocketPath(claim.Uid, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.MkdirAll(filepath.Join(path, "blocker"), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := td.prepareClaims(ctx, []*nodeClaim{claim})[claim.Uid]
+	if !strings.Contains(result.Error, "metadata socket") {
+		t.Fatalf("expected serving the metadata socket to fail, got %+v", result)
+	}
+	if _, ok := td.checkpoint.Get(key); ok {
+		t.Errorf("expected no checkpoint entry for the failed claim")
+	}
+
+	err = os.RemoveAll(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result = td.prepareClaims(ctx, []*nodeClaim{claim})[claim.Uid]
+	if result.Error != "" {
+		t.Fatalf("unable to prepare claim on retry: %s", result.Error)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		t.Errorf("expected the retry to serve the metadata socket, got %v, %v", info, err)
+	}
+	if _, ok := td.checkpoint.Get(key); !ok {
+		t.Errorf("expected the claim to be checkpointed after the retry")
+	}
+}
+
+// TestPrepareAllocationDetails checks that the plugin holds to the cluster
+// and expiration time the controller put into the resource handle.
+func TestPrepareAllocationDetails(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(handle *spacecrd.ResourceHandle)
+		wantErr string
+		// wantSeconds is the lifetime requested for the token.
+		wantSeconds int64
+	}{
+		{
+			name:        "unbounded",
+			modify:      func(handle *spacecrd.ResourceHandle) {},
+			wantSeconds: tokenExpirationSeconds,
+		},
+		{
+			name: "bounded",
+			modify: func(handle *spacecrd.ResourceHandle) {
+				expiration := metav1.NewTime(time.Now().Add(20 * time.Minute).Truncate(time.Second))
+				handle.ExpirationTime = &expiration
+			},
+			// Rounded up to whole seconds from just below 20 minutes.
+			wantSeconds: 1200,
+		},
+		{
+			name: "expired",
+			modify: func(handle *spacecrd.ResourceHandle) {
+				expiration := metav1.NewTime(time.Now().Add(-time.Minute))
+				handle.ExpirationTime = &expiration
+			},
+			wantErr: "space expired",
+		},
+		{
+			name: "cluster of the plugin",
+			modify: func(handle *spacecrd.ResourceHandle) {
+				handle.Cluster = testAPIServer
+			},
+			wantSeconds: tokenExpirationSeconds,
+		},
+		{
+			name: "other cluster",
+			modify: func(handle *spacecrd.ResourceHandle) {
+				handle.Cluster = "https://lb.example.com:6443"
+			},
+			wantErr: "allocated for cluster",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			td := newTestDriver(t, 1)
+			var requested []int64
+			td.core.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				request := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenRequest)
+				requested = append(requested, *request.Spec.ExpirationSeconds)
+				return false, nil, nil
+			})
+			claim := td.addClaim(t, "test")
+			handle, err := spacecrd.DecodeResourceHandle(claim.ResourceHandle)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.modify(handle)
+			claim.ResourceHandle, err = spacecrd.EncodeResourceHandle(handle)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result := td.prepareClaims(context.Background(), []*nodeClaim{claim})[claim.Uid]
+			if tc.wantErr != "" {
+				if !strings.Contains(result.Error, tc.wantErr) {
+					t.Fatalf("expected error containing %q, got %+v", tc.wantErr, result)
+				}
+				if len(requested) != 0 || len(td.checkpoint.List()) != 0 {
+					t.Errorf("expected no token and no checkpoint entry, got %v and %d entries", requested, len(td.checkpoint.List()))
+				}
+				return
+			}
+			if result.Error != "" {
+				t.Fatalf("unable to prepare claim: %s", result.Error)
+			}
+			if len(requested) != 1 || requested[0] < tc.wantSeconds-1 || requested[0] > tc.wantSeconds {
+				t.Errorf("expected one token for %d seconds, got %v", tc.wantSeconds, requested)
+			}
+			pc, ok := td.checkpoint.Get(preparedClaimKey(claim.Uid, ""))
+			if !ok {
+				t.Fatalf("expected the claim to be checkpointed")
+			}
+			if !reflect.DeepEqual(pc.ExpirationTime, handle.ExpirationTime) {
+				t.Errorf("expected expiration time %v to be checkpointed, got %v", handle.ExpirationTime, pc.ExpirationTime)
+			}
+		})
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// BenchmarkPrepareClaims prepares and unprepares a batch of claims, with one
+// worker and with several. Tokens come from a fake API server which answers
+// after tokenLatency, like a real one would after a round trip, so the
+// benchmark shows how much of that latency the workers hide in addition to
+// the work on the node.
+func BenchmarkPrepareClaims(b *testing.B) {
+	const (
+		numClaims    = 64
+		tokenLatency = 5 * time.Millisecond
+	)
+
+	for _, workers := range []int{1, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			td := newTestDriver(b, workers)
+			td.core.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				time.Sleep(tokenLatency)
+				return false, nil, nil
+			})
+			var claims []*nodeClaim
+			for i := 0; i < numClaims; i++ {
+				claims = append(claims, td.addClaim(b, fmt.Sprintf("claim-%d", i)))
+			}
+			ctx := context.Background()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for uid, result := range td.prepareClaims(ctx, claims) {
+					if result.Error != "" {
+						b.Fatalf("unable to prepare claim %s: %s", uid, result.Error)
+					}
+				}
+
+				b.StopTimer()
+				for uid, result := range td.unprepareClaims(ctx, claims) {
+					if result.Error != "" {
+						b.Fatalf("unable to unprepare claim %s: %s", uid, result.Error)
+					}
+				}
+				b.StartTimer()
+			}
+		})
+	}
+}
@@ -0,0 +1,194 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	resourcev1 "k8s.io/api/resource/v1alpha2"
+	"k8s.io/klog/v2"
+
+	spacecrd "sigs.k8s.io/dra-example-driver/api/example.com/resource/space/v1alpha1"
+)
+
+// envName turns a DNS label into the corresponding environment variable
+// name fragment.
+func envName(name string) string {
+	return strings.ReplaceAll(strings.ToUpper(name), "-", "_")
+}
+
+// spaceEnvPrefix returns the prefix of the environment variables of one
+// space of a claim whose env prefix is base. Spaces of a multi-space claim
+// are distinguished by their name.
+func spaceEnvPrefix(base string, spaceName string) string {
+	if spaceName == "" {
+		return base
+	}
+	return base + "_" + envName(spaceName)
+}
+
+// claimSpaceEnvPrefixes returns the env prefixes of all spaces allocated for
+// a claim whose env prefix is base. Claims which are not allocated yet are
+// taken to have a single space.
+func claimSpaceEnvPrefixes(claim *resourcev1.ResourceClaim, base string) ([]string, error) {
+	var prefixes []string
+	if claim.Status.Allocation != nil {
+		for _, handle := range claim.Status.Allocation.ResourceHandles {
+			if handle.DriverName != DriverName {
+				continue
+			}
+			spaces, err := spacecrd.DecodeResourceHandles(handle.Data)
+			if err != nil {
+				return nil, fmt.Errorf("unable to decode resource handle of claim %s: %v", claim.Name, err)
+			}
+			for _, space := range spaces {
+				prefixes = append(prefixes, spaceEnvPrefix(base, space.SpaceName))
+			}
+		}
+	}
+	if len(prefixes) == 0 {
+		prefixes = []string{base}
+	}
+	return prefixes, nil
+}
+
+// podClaimName returns the name under which a pod refers to a
+// ResourceClaim in spec.resourceClaims, or "" if it does not use the claim.
+func podClaimName(pod *corev1.Pod, claimName string) string {
+	for _, podClaim := range pod.Spec.ResourceClaims {
+		if podResourceClaimName(pod, &podClaim) == claimName {
+			return podClaim.Name
+		}
+	}
+	return ""
+}
+
+// podResourceClaimName resolves an entry of spec.resourceClaims to the name
+// of the ResourceClaim object, which for claims created from a template is
+// only known from the pod status.
+func podResourceClaimName(pod *corev1.Pod, podClaim *corev1.PodResourceClaim) string {
+	if podClaim.Source.ResourceClaimName != nil {
+		return *podClaim.Source.ResourceClaimName
+	}
+	for _, status := range pod.Status.ResourceClaimStatuses {
+		if status.Name == podClaim.Name && status.ResourceClaimName != nil {
+			return *status.ResourceClaimName
+		}
+	}
+	return ""
+}
+
+// explicitEnvPrefix returns the env prefix set in the claim parameters, if
+// any.
+func (d *driver) explicitEnvPrefix(claim *resourcev1.ResourceClaim) (string, error) {
+	params, err := d.cache.GetClaimParametersFor(claim)
+	if err != nil {
+		return "", err
+	}
+	return params.EnvPrefix, nil
+}
+
+// claimEnvPrefix determines the prefix of the environment variables exposed
+// for a claim. An explicit prefix from the claim parameters takes precedence
+// over the name the pods use to refer to the claim, which in turn takes
+// precedence over the claim name. The latter is random for claims created
+// from a template, so it is only used if the pods disagree.
+//
+// A prefix which is also used by another claim of one of the pods is
+// rejected, since the containers would only see one of the claims.
+func (d *driver) claimEnvPrefix(ctx context.Context, rc *resourcev1.ResourceClaim, pods []ReservingPod) (string, error) {
+	logger := klog.FromContext(ctx)
+
+	prefix, err := d.explicitEnvPrefix(rc)
+	if err != nil {
+		return "", err
+	}
+	if prefix == "" {
+		prefix = envName(rc.Name)
+		names := make(map[string]bool)
+		for _, pod := range pods {
+			if pod.PodClaimName != "" {
+				names[pod.PodClaimName] = true
+			}
+		}
+		switch {
+		case len(names) == 1:
+			for name := range names {
+				prefix = envName(name)
+			}
+		case len(names) > 1:
+			logger.Info("Pods refer to claim by different names, using claim name as env prefix", "claim", klog.KObj(rc))
+		}
+	}
+
+	for _, pod := range pods {
+		err := d.checkEnvPrefixCollision(ctx, pod.Pod, rc, prefix)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return prefix, nil
+}
+
+// checkEnvPrefixCollision verifies that no space of another claim of the
+// pod handled by this driver ends up with the same env prefix as one of the
+// spaces of the claim. The prefixes of the spaces are compared rather than
+// those of the claims, since e.g. the space "dev" of a claim with prefix
+// SPACE and a single-space claim with prefix SPACE_DEV collide.
+func (d *driver) checkEnvPrefixCollision(ctx context.Context, pod *corev1.Pod, claim *resourcev1.ResourceClaim, prefix string) error {
+	prefixes, err := claimSpaceEnvPrefixes(claim, prefix)
+	if err != nil {
+		return err
+	}
+	for _, podClaim := range pod.Spec.ResourceClaims {
+		name := podResourceClaimName(pod, &podClaim)
+		if name == "" || name == claim.Name {
+			continue
+		}
+
+		other, err := d.cache.GetClaimByName(ctx, pod.Namespace, name)
+		if err != nil {
+			return err
+		}
+		if other.Status.DriverName != DriverName {
+			continue
+		}
+
+		otherPrefix, err := d.explicitEnvPrefix(other)
+		if err != nil {
+			return err
+		}
+		if otherPrefix == "" {
+			otherPrefix = envName(podClaim.Name)
+		}
+		otherPrefixes, err := claimSpaceEnvPrefixes(other, otherPrefix)
+		if err != nil {
+			return err
+		}
+		for _, p := range prefixes {
+			if slices.Contains(otherPrefixes, p) {
+				return fmt.Errorf("env prefix %s of claim %s collides with claim %s of pod %s/%s", p, claim.Name, other.Name, pod.Namespace, pod.Name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	resourcev1 "k8s.io/api/resource/v1alpha2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	spacecrd "sigs.k8s.io/dra-example-driver/api/example.com/resource/space/v1alpha1"
+)
+
+// envPrefixClaim is a claim of the test pods, with the name the pods use
+// for it, an optional explicit env prefix and the names of its spaces if
+// it is allocated.
+type envPrefixClaim struct {
+	name         string
+	podClaimName string
+	envPrefix    string
+	driverName   string
+	spaces       []string
+}
+
+// createEnvPrefixClaim stores a claim and its parameters and waits until
+// the cache of the driver has them.
+func (td *testDriver) createEnvPrefixClaim(t *testing.T, claim envPrefixClaim) *resourcev1.ResourceClaim {
+	t.Helper()
+	ctx := context.Background()
+
+	rc := &resourcev1.ResourceClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: claim.name, Namespace: testNamespace},
+		Spec:       resourcev1.ResourceClaimSpec{ResourceClassName: testClassName},
+		Status:     resourcev1.ResourceClaimStatus{DriverName: DriverName},
+	}
+	if claim.driverName != "" {
+		rc.Status.DriverName = claim.driverName
+	}
+	if len(claim.spaces) > 0 {
+		var handles []*spacecrd.ResourceHandle
+		for _, spaceName := range claim.spaces {
+			handles = append(handles, &spacecrd.ResourceHandle{SpaceName: spaceName, Namespace: claim.name + "-" + spaceName})
+		}
+		data, err := spacecrd.EncodeResourceHandles(handles)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc.Status.Allocation = &resourcev1.AllocationResult{
+			ResourceHandles: []resourcev1.ResourceHandle{{DriverName: rc.Status.DriverName, Data: data}},
+		}
+	}
+	if claim.envPrefix != "" {
+		params := &spacecrd.SpaceClaimParameters{
+			ObjectMeta: metav1.ObjectMeta{Name: claim.name, Namespace: testNamespace},
+			Spec:       spacecrd.SpaceClaimParametersSpec{EnvPrefix: claim.envPrefix},
+		}
+		_, err := td.clientsets.Example.SpaceV1alpha1().SpaceClaimParameters(testNamespace).Create(ctx, params, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc.Spec.ParametersRef = &resourcev1.ResourceClaimParametersReference{
+			APIGroup: spacecrd.GroupName,
+			Kind:     spacecrd.SpaceClaimParametersKind,
+			Name:     claim.name,
+		}
+	}
+	rc, err := td.core.ResourceV1alpha2().ResourceClaims(testNamespace).Create(ctx, rc, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 10*time.Second, true, func(context.Context) (bool, error) {
+		if _, err := td.cache.GetClaimByName(ctx, testNamespace, claim.name); err != nil {
+			return false, nil
+		}
+		if _, err := td.cache.GetClaimParametersFor(rc); err != nil {
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("claim %s did not show up in the cache: %v", claim.name, err)
+	}
+	return rc
+}
+
+func TestClaimEnvPrefix(t *testing.T) {
+	tests := []struct {
+		name string
+		// claim is the claim whose prefix is determined.
+		claim envPrefixClaim
+		// pods lists for every pod the name it uses for the claim. Each
+		// pod also consumes the claims in others.
+		pods   []string
+		others []envPrefixClaim
+		prefix string
+		valid  bool
+	}{
+		{
+			name:   "pod claim name",
+			claim:  envPrefixClaim{name: "shared-namespace-x7k2p"},
+			pods:   []string{"shared-namespace"},
+			prefix: "SHARED_NAMESPACE",
+			valid:  true,
+		},
+		{
+			name:   "pods agree on the name",
+			claim:  envPrefixClaim{name: "test-claim"},
+			pods:   []string{"space", "space"},
+			prefix: "SPACE",
+			valid:  true,
+		},
+		{
+			name:   "pods disagree on the name",
+			claim:  envPrefixClaim{name: "test-claim"},
+			pods:   []string{"space", "shared"},
+			prefix: "TEST_CLAIM",
+			valid:  true,
+		},
+		{
+			name:   "explicit prefix",
+			claim:  envPrefixClaim{name: "test-claim", envPrefix: "MY_SPACE"},
+			pods:   []string{"space"},
+			prefix: "MY_SPACE",
+			valid:  true,
+		},
+		{
+			name:   "other claim with another name",
+			claim:  envPrefixClaim{name: "test-claim"},
+			pods:   []string{"space"},
+			others: []envPrefixClaim{{name: "other-claim", podClaimName: "other"}},
+			prefix: "SPACE",
+			valid:  true,
+		},
+		{
+			name:   "explicit prefix collides with name of other claim",
+			claim:  envPrefixClaim{name: "test-claim", envPrefix: "OTHER"},
+			pods:   []string{"space"},
+			others: []envPrefixClaim{{name: "other-claim", podClaimName: "other"}},
+		},
+		{
+			name:   "name collides with explicit prefix of other claim",
+			claim:  envPrefixClaim{name: "test-claim"},
+			pods:   []string{"space"},
+			others: []envPrefixClaim{{name: "other-claim", podClaimName: "other", envPrefix: "SPACE"}},
+		},
+		{
+			name:   "explicit prefixes collide",
+			claim:  envPrefixClaim{name: "test-claim", envPrefix: "SAME"},
+			pods:   []string{"space"},
+			others: []envPrefixClaim{{name: "other-claim", podClaimName: "other", envPrefix: "SAME"}},
+		},
+		{
+			name:   "spaces of claims with distinct prefixes",
+			claim:  envPrefixClaim{name: "test-claim", spaces: []string{"dev", "prod"}},
+			pods:   []string{"space"},
+			others: []envPrefixClaim{{name: "other-claim", podClaimName: "other", spaces: []string{"dev", "prod"}}},
+			prefix: "SPACE",
+			valid:  true,
+		},
+		{
+			name:   "space prefix collides with explicit prefix of other claim",
+			claim:  envPrefixClaim{name: "test-claim", spaces: []string{"dev", "prod"}},
+			pods:   []string{"space"},
+			others: []envPrefixClaim{{name: "other-claim", podClaimName: "other", envPrefix: "SPACE_PROD"}},
+		},
+		{
+			name:   "explicit prefix collides with space prefix of other claim",
+			claim:  envPrefixClaim{name: "test-claim", envPrefix: "OTHER_DEV"},
+			pods:   []string{"space"},
+			others: []envPrefixClaim{{name: "other-claim", podClaimName: "other", spaces: []string{"dev", "prod"}}},
+		},
+		{
+			name:   "space prefixes collide",
+			claim:  envPrefixClaim{name: "test-claim", envPrefix: "TEAM", spaces: []string{"a-dev"}},
+			pods:   []string{"space"},
+			others: []envPrefixClaim{{name: "other-claim", podClaimName: "other", envPrefix: "TEAM_A", spaces: []string{"dev"}}},
+		},
+		{
+			name:   "other claim of another driver",
+			claim:  envPrefixClaim{name: "test-claim", envPrefix: "OTHER"},
+			pods:   []string{"space"},
+			others: []envPrefixClaim{{name: "other-claim", podClaimName: "other", driverName: "gpu.resource.example.com"}},
+			prefix: "OTHER",
+			valid:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			td := newTestDriver(t, 1)
+			rc := td.createEnvPrefixClaim(t, tc.claim)
+			for _, other := range tc.others {
+				td.createEnvPrefixClaim(t, other)
+			}
+
+			var pods []ReservingPod
+			for _, podClaimName := range tc.pods {
+				claimName := tc.claim.name
+				pod := &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: testNamespace},
+					Spec: corev1.PodSpec{
+						ResourceClaims: []corev1.PodResourceClaim{{
+							Name:   podClaimName,
+							Source: corev1.ClaimSource{ResourceClaimName: &claimName},
+						}},
+					},
+				}
+				for _, other := range tc.others {
+					otherName := other.name
+					pod.Spec.ResourceClaims = append(pod.Spec.ResourceClaims, corev1.PodResourceClaim{
+						Name:   other.podClaimName,
+						Source: corev1.ClaimSource{ResourceClaimName: &otherName},
+					})
+				}
+				pods = append(pods, ReservingPod{Pod: pod, PodClaimName: podClaimName})
+			}
+
+			prefix, err := td.claimEnvPrefix(context.Background(), rc, pods)
+			switch {
+			case !tc.valid && err == nil:
+				t.Errorf("expected env prefix to be rejected, got %s", prefix)
+			case tc.valid && err != nil:
+				t.Errorf("unable to determine env prefix: %v", err)
+			case tc.valid && prefix != tc.prefix:
+				t.Errorf("expected env prefix %s, got %s", tc.prefix, prefix)
+			}
+		})
+	}
+}
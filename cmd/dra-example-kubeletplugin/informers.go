@@ -0,0 +1,385 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	resourcev1 "k8s.io/api/resource/v1alpha2"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/informers"
+	resourceclient "k8s.io/client-go/kubernetes/typed/resource/v1alpha2"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	spacecrd "sigs.k8s.io/dra-example-driver/api/example.com/resource/space/v1alpha1"
+)
+
+const (
+	podClaimNameIndex = "podClaimName"
+
+	// reservingPodsTimeout bounds how long a prepare call waits for the
+	// informers to catch up with the pod being admitted by the kubelet.
+	reservingPodsTimeout = 5 * time.Second
+)
+
+// ReservingPod is a pod on this node which reserved a claim, together with
+// the name the pod uses for the claim in spec.resourceClaims.
+type ReservingPod struct {
+	Pod          *corev1.Pod
+	PodClaimName string
+}
+
+// PodClaimCache keeps informer-backed caches of the pods on this node, the
+// namespaces of spaces, ResourceClasses and the parameters, so that prepare
+// calls can look up pod context without talking to the API server.
+// ResourceClaims cannot be selected by node, so rather than caching those of
+// the whole cluster, the few used by pods on this node are read on demand.
+type PodClaimCache struct {
+	factory     informers.SharedInformerFactory
+	podFactory  informers.SharedInformerFactory
+	nsFactory   informers.SharedInformerFactory
+	claims      resourceclient.ResourceV1alpha2Interface
+	pods        cache.SharedIndexInformer
+	claimParams cache.SharedIndexInformer
+	classes     cache.SharedIndexInformer
+	classParams cache.SharedIndexInformer
+	namespaces  cache.SharedIndexInformer
+	nodeName    string
+}
+
+func NewPodClaimCache(config *Config) (*PodClaimCache, error) {
+	nodeName := config.flags.nodeName
+	factory := informers.NewSharedInformerFactory(config.clientsets.Core, 0 /* resync period */)
+	podFactory := informers.NewSharedInformerFactoryWithOptions(config.clientsets.Core, 0, /* resync period */
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("spec.nodeName", nodeName).String()
+		}))
+
+	pods := podFactory.Core().V1().Pods().Informer()
+	err := pods.AddIndexers(cache.Indexers{podClaimNameIndex: podClaimNameIndexFunc})
+	if err != nil {
+		return nil, fmt.Errorf("unable to add pod indexer: %v", err)
+	}
+
+	paramsClient := config.clientsets.Example.SpaceV1alpha1()
+	claimParams := cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return paramsClient.SpaceClaimParameters(metav1.NamespaceAll).List(context.Background(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return paramsClient.SpaceClaimParameters(metav1.NamespaceAll).Watch(context.Background(), options)
+			},
+		},
+		&spacecrd.SpaceClaimParameters{},
+		0, /* resync period */
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc},
+	)
+
+	classes := factory.Resource().V1alpha2().ResourceClasses().Informer()
+
+	classParams := cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return paramsClient.SpaceClassParameters().List(context.Background(), options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return paramsClient.SpaceClassParameters().Watch(context.Background(), options)
+			},
+		},
+		&spacecrd.SpaceClassParameters{},
+		0, /* resync period */
+		cache.Indexers{},
+	)
+
+	// Only namespaces labeled for a claim can be spaces.
+	nsSelector := ResourceClaimLabel
+	nsFactory := informers.NewSharedInformerFactoryWithOptions(config.clientsets.Core, 0, /* resync period */
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = nsSelector
+		}))
+	namespaces := nsFactory.Core().V1().Namespaces().Informer()
+
+	c := &PodClaimCache{
+		factory:     factory,
+		podFactory:  podFactory,
+		nsFactory:   nsFactory,
+		claims:      config.clientsets.Core.ResourceV1alpha2(),
+		pods:        pods,
+		claimParams: claimParams,
+		classes:     classes,
+		classParams: classParams,
+		namespaces:  namespaces,
+		nodeName:    nodeName,
+	}
+	return c, nil
+}
+
+// Start runs the informers until the context is cancelled and waits for
+// their caches to sync.
+func (c *PodClaimCache) Start(ctx context.Context) error {
+	logger := klog.FromContext(ctx)
+
+	c.factory.Start(ctx.Done())
+	c.podFactory.Start(ctx.Done())
+	c.nsFactory.Start(ctx.Done())
+	go c.claimParams.Run(ctx.Done())
+	go c.classParams.Run(ctx.Done())
+
+	logger.Info("Waiting for informer caches to sync", "node", c.nodeName)
+	if !cache.WaitForCacheSync(ctx.Done(), c.pods.HasSynced, c.claimParams.HasSynced, c.classes.HasSynced, c.classParams.HasSynced, c.namespaces.HasSynced) {
+		return fmt.Errorf("unable to sync informer caches")
+	}
+	return nil
+}
+
+// podClaimNameIndexFunc indexes pods by the namespaced names of the
+// ResourceClaims they use.
+func podClaimNameIndexFunc(obj any) ([]string, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return nil, nil
+	}
+	var keys []string
+	for i := range pod.Spec.ResourceClaims {
+		if name := podResourceClaimName(pod, &pod.Spec.ResourceClaims[i]); name != "" {
+			keys = append(keys, pod.Namespace+"/"+name)
+		}
+	}
+	return keys, nil
+}
+
+// GetClaim returns the ResourceClaim with the given name, provided it
+// still has the given UID.
+func (c *PodClaimCache) GetClaim(ctx context.Context, namespace string, name string, claimUid string) (*resourcev1.ResourceClaim, error) {
+	claim, err := c.GetClaimByName(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	if string(claim.UID) != claimUid {
+		return nil, fmt.Errorf("claim %s/%s has UID %s, not %s", namespace, name, claim.UID, claimUid)
+	}
+	return claim, nil
+}
+
+// GetClaimByName returns the ResourceClaim with the given name.
+func (c *PodClaimCache) GetClaimByName(ctx context.Context, namespace string, name string) (*resourcev1.ResourceClaim, error) {
+	claim, err := c.claims.ResourceClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get claim %s/%s: %v", namespace, name, err)
+	}
+	return claim, nil
+}
+
+// ListNodeClaims returns the ResourceClaims used by pods on this node.
+// Claims which no longer exist are skipped.
+func (c *PodClaimCache) ListNodeClaims(ctx context.Context) ([]*resourcev1.ResourceClaim, error) {
+	keys := sets.New[string]()
+	for _, obj := range c.pods.GetStore().List() {
+		podKeys, _ := podClaimNameIndexFunc(obj)
+		keys.Insert(podKeys...)
+	}
+
+	var claims []*resourcev1.ResourceClaim
+	for _, key := range sets.List(keys) {
+		namespace, name, _ := strings.Cut(key, "/")
+		claim, err := c.claims.ResourceClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to get claim %s: %v", key, err)
+		}
+		claims = append(claims, claim)
+	}
+	return claims, nil
+}
+
+// GetClaimParameters returns the cached SpaceClaimParameters with the given
+// name.
+func (c *PodClaimCache) GetClaimParameters(namespace string, name string) (*spacecrd.SpaceClaimParameters, error) {
+	obj, exists, err := c.claimParams.GetStore().GetByKey(namespace + "/" + name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("claim parameters %s/%s not found in cache", namespace, name)
+	}
+	return obj.(*spacecrd.SpaceClaimParameters), nil
+}
+
+// GetClassParameters returns the cached SpaceClassParameters of the given
+// resource class, or nil if the class has none.
+func (c *PodClaimCache) GetClassParameters(className string) (*spacecrd.SpaceClassParametersSpec, error) {
+	obj, exists, err := c.classes.GetStore().GetByKey(className)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("resource class %s not found in cache", className)
+	}
+	ref := obj.(*resourcev1.ResourceClass).ParametersRef
+	if ref == nil || ref.APIGroup != spacecrd.GroupName || ref.Kind != spacecrd.SpaceClassParametersKind {
+		return nil, nil
+	}
+
+	obj, exists, err = c.classParams.GetStore().GetByKey(ref.Name)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("class parameters %s not found in cache", ref.Name)
+	}
+	return &obj.(*spacecrd.SpaceClassParameters).Spec, nil
+}
+
+// GetClaimParametersFor returns the SpaceClaimParameters referenced by a
+// claim, or the defaults if it references none.
+func (c *PodClaimCache) GetClaimParametersFor(claim *resourcev1.ResourceClaim) (*spacecrd.SpaceClaimParametersSpec, error) {
+	ref := claim.Spec.ParametersRef
+	if ref == nil || ref.APIGroup != spacecrd.GroupName || ref.Kind != spacecrd.SpaceClaimParametersKind {
+		return spacecrd.DefaultSpaceClaimParametersSpec(), nil
+	}
+	params, err := c.GetClaimParameters(claim.Namespace, ref.Name)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get claim parameters: %v", err)
+	}
+	return &params.Spec, nil
+}
+
+// ReservingPods returns the pods on this node which reserved the claim.
+func (c *PodClaimCache) ReservingPods(claim *resourcev1.ResourceClaim) ([]ReservingPod, error) {
+	reserved := make(map[string]bool)
+	for _, consumer := range claim.Status.ReservedFor {
+		if consumer.APIGroup == "" && consumer.Resource == "pods" {
+			reserved[string(consumer.UID)] = true
+		}
+	}
+
+	objs, err := c.pods.GetIndexer().ByIndex(podClaimNameIndex, claim.Namespace+"/"+claim.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	var pods []ReservingPod
+	for _, obj := range objs {
+		pod := obj.(*corev1.Pod)
+		if !reserved[string(pod.UID)] {
+			continue
+		}
+		pods = append(pods, ReservingPod{
+			Pod:          pod,
+			PodClaimName: podClaimName(pod, claim.Name),
+		})
+	}
+	return pods, nil
+}
+
+// WaitForReservingPods returns the claim with the given name and UID and
+// the pods on this node which reserved it. The kubelet only prepares claims
+// for pods it admitted, so it waits briefly for the informers to observe at
+// least one.
+func (c *PodClaimCache) WaitForReservingPods(ctx context.Context, namespace string, name string, claimUid string) (*resourcev1.ResourceClaim, []ReservingPod, error) {
+	var claim *resourcev1.ResourceClaim
+	var pods []ReservingPod
+	var lastErr error
+	err := wait.PollUntilContextTimeout(ctx, 100*time.Millisecond, reservingPodsTimeout, true, func(ctx context.Context) (bool, error) {
+		claim, lastErr = c.GetClaim(ctx, namespace, name, claimUid)
+		if lastErr != nil {
+			return false, nil
+		}
+		pods, lastErr = c.ReservingPods(claim)
+		if lastErr != nil {
+			return false, nil
+		}
+		if len(pods) == 0 {
+			lastErr = fmt.Errorf("no pod on node %s reserved claim %s/%s", c.nodeName, claim.Namespace, claim.Name)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		if lastErr != nil {
+			return nil, nil, lastErr
+		}
+		return nil, nil, err
+	}
+	return claim, pods, nil
+}
+
+// WaitForClaimNamespace checks that the namespace exists, belongs to the
+// claim with the given UID according to its ResourceClaimLabel and is not
+// being deleted. It waits briefly for the namespace to show up in case the
+// informer has not caught up with its creation yet.
+func (c *PodClaimCache) WaitForClaimNamespace(ctx context.Context, claimUid string, name string) error {
+	var lastErr error
+	err := wait.PollUntilContextTimeout(ctx, 100*time.Millisecond, reservingPodsTimeout, true, func(context.Context) (bool, error) {
+		obj, exists, err := c.namespaces.GetStore().GetByKey(name)
+		if err != nil {
+			return false, err
+		}
+		if !exists {
+			lastErr = fmt.Errorf("namespace %s does not exist", name)
+			return false, nil
+		}
+		ns := obj.(*corev1.Namespace)
+		if owner := ns.Labels[ResourceClaimLabel]; owner != claimUid {
+			return false, fmt.Errorf("namespace %s is labeled for claim %q, not %s", name, owner, claimUid)
+		}
+		if ns.DeletionTimestamp != nil || ns.Status.Phase == corev1.NamespaceTerminating {
+			return false, fmt.Errorf("namespace %s is terminating", name)
+		}
+		return true, nil
+	})
+	if err != nil {
+		if wait.Interrupted(err) && lastErr != nil {
+			return lastErr
+		}
+		return err
+	}
+	return nil
+}
+
+// IsActive reports whether the claim is allocated by this driver and
+// reserved for a running pod on this node.
+func (c *PodClaimCache) IsActive(claim *resourcev1.ResourceClaim) bool {
+	if claim.Status.DriverName != DriverName || claim.Status.Allocation == nil {
+		return false
+	}
+	pods, err := c.ReservingPods(claim)
+	if err != nil {
+		// Err on the side of keeping what was prepared for the claim.
+		return true
+	}
+	for _, pod := range pods {
+		if pod.Pod.Status.Phase != corev1.PodSucceeded && pod.Pod.Status.Phase != corev1.PodFailed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	resourcev1 "k8s.io/api/resource/v1alpha2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	k8stesting "k8s.io/client-go/testing"
+
+	spacecrd "sigs.k8s.io/dra-example-driver/api/example.com/resource/space/v1alpha1"
+)
+
+// TestInformerScope checks that the plugin only watches the namespaces
+// labeled for a claim and no ResourceClaims at all.
+func TestInformerScope(t *testing.T) {
+	td := newTestDriver(t, 1)
+
+	var namespaceSelectors []string
+	for _, action := range td.core.Actions() {
+		switch action := action.(type) {
+		case k8stesting.ListAction:
+			if action.GetResource().Resource == "resourceclaims" {
+				t.Errorf("unexpected list of ResourceClaims")
+			}
+			if action.GetResource().Resource == "namespaces" {
+				namespaceSelectors = append(namespaceSelectors, action.GetListRestrictions().Labels.String())
+			}
+		case k8stesting.WatchAction:
+			if action.GetResource().Resource == "resourceclaims" {
+				t.Errorf("unexpected watch of ResourceClaims")
+			}
+			if action.GetResource().Resource == "namespaces" {
+				namespaceSelectors = append(namespaceSelectors, action.GetWatchRestrictions().Labels.String())
+			}
+		}
+	}
+	if want := []string{ResourceClaimLabel, ResourceClaimLabel}; !reflect.DeepEqual(namespaceSelectors, want) {
+		t.Errorf("expected namespaces to be listed and watched with selectors %v, got %v", want, namespaceSelectors)
+	}
+}
+
+func TestListNodeClaims(t *testing.T) {
+	td := newTestDriver(t, 1)
+	ctx := context.Background()
+	claim := td.addClaim(t, "test")
+
+	// Neither a claim without pods on the node nor a pod whose claim is
+	// gone adds to the claims of the node.
+	_, err := td.core.ResourceV1alpha2().ResourceClaims(testNamespace).Create(ctx, &resourcev1.ResourceClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "unused", Namespace: testNamespace, UID: "uid-unused"},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claimName := "missing"
+	_, err = td.core.CoreV1().Pods(testNamespace).Create(ctx, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "missing", Namespace: testNamespace},
+		Spec: corev1.PodSpec{
+			NodeName: testNodeName,
+			ResourceClaims: []corev1.PodResourceClaim{{
+				Name:   "space",
+				Source: corev1.ClaimSource{ResourceClaimName: &claimName},
+			}},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 10*time.Second, true, func(context.Context) (bool, error) {
+		_, exists, err := td.cache.pods.GetStore().GetByKey(testNamespace + "/missing")
+		return exists && err == nil, nil
+	})
+	if err != nil {
+		t.Fatalf("pod did not show up in the cache: %v", err)
+	}
+
+	claims, err := td.cache.ListNodeClaims(ctx)
+	if err != nil {
+		t.Fatalf("unable to list claims: %v", err)
+	}
+	var uids []string
+	for _, claim := range claims {
+		uids = append(uids, string(claim.UID))
+	}
+	if want := []string{claim.Uid}; !reflect.DeepEqual(uids, want) {
+		t.Errorf("expected claims %v, got %v", want, uids)
+	}
+}
+
+func TestWaitForClaimNamespace(t *testing.T) {
+	const claimUid = "uid-test"
+	now := metav1.Now()
+	// The test cases refer to the label before newTestDriver sets it.
+	setDriverName(spacecrd.GroupName)
+
+	tests := []struct {
+		name string
+		// namespace is created before waiting, unless it is nil.
+		namespace *corev1.Namespace
+		// delayed is created while waiting.
+		delayed *corev1.Namespace
+		wantErr string
+	}{
+		{
+			name: "labeled namespace",
+			namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name: "space", Labels: map[string]string{ResourceClaimLabel: claimUid},
+			}},
+		},
+		{
+			name: "namespace created while waiting",
+			delayed: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name: "space", Labels: map[string]string{ResourceClaimLabel: claimUid},
+			}},
+		},
+		{
+			name:    "missing namespace",
+			wantErr: "namespace space does not exist",
+		},
+		{
+			name: "missing label",
+			namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name: "space", Labels: map[string]string{"app": "other"},
+			}},
+			wantErr: "namespace space",
+		},
+		{
+			name: "labeled for another claim",
+			namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name: "space", Labels: map[string]string{ResourceClaimLabel: "uid-other"},
+			}},
+			wantErr: `namespace space is labeled for claim "uid-other", not uid-test`,
+		},
+		{
+			name: "terminating namespace",
+			namespace: &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{Name: "space", Labels: map[string]string{ResourceClaimLabel: claimUid}},
+				Status:     corev1.NamespaceStatus{Phase: corev1.NamespaceTerminating},
+			},
+			wantErr: "namespace space is terminating",
+		},
+		{
+			name: "namespace being deleted",
+			namespace: &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+				Name: "space", Labels: map[string]string{ResourceClaimLabel: claimUid},
+				DeletionTimestamp: &now, Finalizers: []string{"kubernetes"},
+			}},
+			wantErr: "namespace space is terminating",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			td := newTestDriver(t, 1)
+			if tc.namespace != nil {
+				_, err := td.core.CoreV1().Namespaces().Create(context.Background(), tc.namespace, metav1.CreateOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tc.delayed != nil {
+				go func() {
+					time.Sleep(200 * time.Millisecond)
+					_, err := td.core.CoreV1().Namespaces().Create(context.Background(), tc.delayed, metav1.CreateOptions{})
+					if err != nil {
+						t.Error(err)
+					}
+				}()
+			}
+
+			// Waiting is cut short rather than taking the full
+			// reservingPodsTimeout for namespaces which never show up.
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			err := td.cache.WaitForClaimNamespace(ctx, claimUid, "space")
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Errorf("expected error containing %q, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
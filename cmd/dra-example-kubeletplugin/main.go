@@ -19,36 +19,90 @@ package main
 import (
 	"context"
 	"fmt"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"path"
 	"syscall"
+	"time"
 
+	cdiapi "github.com/container-orchestrated-devices/container-device-interface/pkg/cdi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/urfave/cli/v2"
 
-	plugin "k8s.io/dynamic-resource-allocation/kubeletplugin"
+	"k8s.io/client-go/rest"
+	"k8s.io/component-base/metrics/legacyregistry"
 	"k8s.io/klog/v2"
 
-	spacecrd "sigs.k8s.io/dra-example-driver/api/example.com/resource/space/v1alpha1"
 	"sigs.k8s.io/dra-example-driver/pkg/flags"
 )
 
-const (
-	DriverName = spacecrd.GroupName
+// The driver name and everything derived from it are set from the
+// --driver-name flag by setDriverName before the plugin starts. Distinct
+// names give distinct sockets, checkpoints, CDI vendors and label keys, so
+// several instances of the driver can share a node.
+var (
+	DriverName string
+
+	PluginRegistrationPath string
+	DriverPluginPath       string
+	DriverPluginSocketPath string
+
+	ResourceClaimLabel string
+	// DefaultContextAnnotation on a pod names the context which is the
+	// current one in the pod's merged kubeconfig.
+	DefaultContextAnnotation string
+
+	cdiVendor string
+	cdiKind   string
+)
+
+func setDriverName(name string) {
+	DriverName = name
 
 	PluginRegistrationPath = "/var/lib/kubelet/plugins_registry/" + DriverName + ".sock"
-	DriverPluginPath       = "/var/lib/kubelet/plugins/" + DriverName
+	DriverPluginPath = "/var/lib/kubelet/plugins/" + DriverName
 	DriverPluginSocketPath = DriverPluginPath + "/plugin.sock"
-)
+
+	ResourceClaimLabel = DriverName + "/resourceclaim"
+	DefaultContextAnnotation = DriverName + "/default-context"
+
+	cdiVendor = "k8s." + DriverName
+	cdiKind = cdiVendor + "/" + cdiClass
+}
 
 type Flags struct {
 	kubeClientConfig flags.KubeClientConfig
 	loggingConfig    *flags.LoggingConfig
+	handleKeys       flags.HandleKeysConfig
+	driverName       flags.DriverNameConfig
+
+	nodeName           string
+	cdiRoot            string
+	claimArtifactsRoot string
+	prepareWorkers     int
+	nodeAPIVersions    *cli.StringSlice
 
-	cdiRoot string
+	tokenRefreshFraction float64
+	metadataSocket       bool
+
+	kubeconfigExecCredential bool
+	mergedKubeconfig         bool
+	spacesManifest           bool
+	credentialHelperPath     string
+
+	apiServerEndpoint string
+	apiServerCAFile   string
+
+	httpEndpoint string
+	metricsPath  string
 }
 
 type Config struct {
 	flags      *Flags
+	restConfig *rest.Config
 	clientsets flags.ClientSets
 }
 
@@ -61,9 +115,17 @@ func main() {
 
 func newApp() *cli.App {
 	flags := &Flags{
-		loggingConfig: flags.NewLoggingConfig(),
+		loggingConfig:   flags.NewLoggingConfig(),
+		nodeAPIVersions: cli.NewStringSlice(),
 	}
 	cliFlags := []cli.Flag{
+		&cli.StringFlag{
+			Name:        "node-name",
+			Usage:       "The name of the node this plugin runs on.",
+			Required:    true,
+			Destination: &flags.nodeName,
+			EnvVars:     []string{"NODE_NAME"},
+		},
 		&cli.StringFlag{
 			Name:        "cdi-root",
 			Usage:       "Absolute path to the directory where CDI files will be generated.",
@@ -71,8 +133,97 @@ func newApp() *cli.App {
 			Destination: &flags.cdiRoot,
 			EnvVars:     []string{"CDI_ROOT"},
 		},
+		&cli.StringFlag{
+			Name:        "claim-artifacts-root",
+			Usage:       "Absolute path to the directory where per-claim artifacts such as kubeconfigs will be written.",
+			Value:       "/var/run/claim-artifacts",
+			Destination: &flags.claimArtifactsRoot,
+			EnvVars:     []string{"CLAIM_ARTIFACTS_ROOT"},
+		},
+		&cli.StringFlag{
+			Name:        "api-server-endpoint",
+			Usage:       "The `URL` under which containers reach the API server. Defaults to the endpoint the plugin itself connects to.",
+			Destination: &flags.apiServerEndpoint,
+			EnvVars:     []string{"API_SERVER_ENDPOINT"},
+		},
+		&cli.StringFlag{
+			Name:        "api-server-ca-file",
+			Usage:       "Path to the PEM-encoded CA bundle for the API server endpoint used by containers. Defaults to the CA the plugin itself trusts.",
+			Destination: &flags.apiServerCAFile,
+			EnvVars:     []string{"API_SERVER_CA_FILE"},
+		},
+		&cli.Float64Flag{
+			Name:        "token-refresh-fraction",
+			Usage:       "Fraction of a space token's lifetime after which it is refreshed.",
+			Value:       0.8,
+			Destination: &flags.tokenRefreshFraction,
+			EnvVars:     []string{"TOKEN_REFRESH_FRACTION"},
+		},
+		&cli.BoolFlag{
+			Name:        "metadata-socket",
+			Usage:       "Serve space metadata and on-demand tokens on a Unix socket in the directory mounted for each claim.",
+			Destination: &flags.metadataSocket,
+			EnvVars:     []string{"METADATA_SOCKET"},
+		},
+		&cli.BoolFlag{
+			Name:        "kubeconfig-exec-credential",
+			Usage:       "Generate kubeconfigs which obtain tokens from the dra-space-credential exec plugin instead of reading the token file.",
+			Destination: &flags.kubeconfigExecCredential,
+			EnvVars:     []string{"KUBECONFIG_EXEC_CREDENTIAL"},
+		},
+		&cli.BoolFlag{
+			Name:        "merged-kubeconfig",
+			Usage:       "Write a kubeconfig for every pod with one context per space claim of the pod, mounted at /etc/dra/kubeconfig, and point KUBECONFIG at it.",
+			Destination: &flags.mergedKubeconfig,
+			EnvVars:     []string{"MERGED_KUBECONFIG"},
+		},
+		&cli.BoolFlag{
+			Name:        "spaces-manifest",
+			Usage:       "Write a JSON manifest for every pod listing all spaces of the pod's claims, mounted at /etc/dra/spaces.json.",
+			Destination: &flags.spacesManifest,
+			EnvVars:     []string{"SPACES_MANIFEST"},
+		},
+		&cli.StringFlag{
+			Name:        "credential-helper-path",
+			Usage:       "Path to the dra-space-credential binary, which is copied into the claim artifacts root and mounted into containers.",
+			Value:       "/usr/bin/dra-space-credential",
+			Destination: &flags.credentialHelperPath,
+			EnvVars:     []string{"CREDENTIAL_HELPER_PATH"},
+		},
+		&cli.StringSliceFlag{
+			Name:        "node-api-versions",
+			Usage:       "Versions of the kubelet DRA gRPC API to serve. The kubelet uses the newest one it supports.",
+			Value:       cli.NewStringSlice(supportedNodeAPIVersions...),
+			Destination: flags.nodeAPIVersions,
+			EnvVars:     []string{"NODE_API_VERSIONS"},
+		},
+		&cli.IntFlag{
+			Name:        "prepare-workers",
+			Usage:       "Concurrency to prepare and unprepare the claims of a single kubelet request",
+			Value:       10,
+			Destination: &flags.prepareWorkers,
+			EnvVars:     []string{"PREPARE_WORKERS"},
+		},
+
+		&cli.StringFlag{
+			Category:    "HTTP server:",
+			Name:        "http-endpoint",
+			Usage:       "The TCP network `address` where the HTTP server for metrics will listen (example: `:8080`). The default is the empty string, which means the server is disabled.",
+			Destination: &flags.httpEndpoint,
+			EnvVars:     []string{"HTTP_ENDPOINT"},
+		},
+		&cli.StringFlag{
+			Category:    "HTTP server:",
+			Name:        "metrics-path",
+			Usage:       "The HTTP `path` where Prometheus metrics will be exposed, disabled if empty.",
+			Value:       "/metrics",
+			Destination: &flags.metricsPath,
+			EnvVars:     []string{"METRICS_PATH"},
+		},
 	}
+	cliFlags = append(cliFlags, flags.driverName.Flags()...)
 	cliFlags = append(cliFlags, flags.kubeClientConfig.Flags()...)
+	cliFlags = append(cliFlags, flags.handleKeys.Flags()...)
 	cliFlags = append(cliFlags, flags.loggingConfig.Flags()...)
 
 	app := &cli.App{
@@ -85,10 +236,39 @@ func newApp() *cli.App {
 			if c.Args().Len() > 0 {
 				return fmt.Errorf("arguments not supported: %v", c.Args().Slice())
 			}
+			if flags.tokenRefreshFraction <= 0 || flags.tokenRefreshFraction >= 1 {
+				return fmt.Errorf("token-refresh-fraction must be between 0 and 1: %v", flags.tokenRefreshFraction)
+			}
+			if flags.prepareWorkers < 1 {
+				return fmt.Errorf("prepare-workers must be at least 1: %v", flags.prepareWorkers)
+			}
+			err := flags.driverName.Validate()
+			if err != nil {
+				return err
+			}
+			err = cdiapi.ValidateVendorName("k8s." + flags.driverName.Name)
+			if err != nil {
+				return fmt.Errorf("invalid driver name %q: %v", flags.driverName.Name, err)
+			}
+			setDriverName(flags.driverName.Name)
+			err = validateNodeAPIVersions(flags.nodeAPIVersions.Value())
+			if err != nil {
+				return err
+			}
+			if flags.handleKeys.Enabled() {
+				_, err := flags.handleKeys.LoadKeys()
+				if err != nil {
+					return err
+				}
+			}
 			return flags.loggingConfig.Apply()
 		},
 		Action: func(c *cli.Context) error {
 			ctx := c.Context
+			restConfig, err := flags.kubeClientConfig.NewClientSetConfig()
+			if err != nil {
+				return fmt.Errorf("create client configuration: %v", err)
+			}
 			clientSets, err := flags.kubeClientConfig.NewClientSets()
 			if err != nil {
 				return fmt.Errorf("create client: %v", err)
@@ -96,9 +276,17 @@ func newApp() *cli.App {
 
 			config := &Config{
 				flags:      flags,
+				restConfig: restConfig,
 				clientsets: clientSets,
 			}
 
+			if flags.httpEndpoint != "" {
+				err = SetupHTTPEndpoint(ctx, config)
+				if err != nil {
+					return fmt.Errorf("create http endpoint: %v", err)
+				}
+			}
+
 			return StartPlugin(ctx, config)
 		},
 	}
@@ -106,8 +294,52 @@ func newApp() *cli.App {
 	return app
 }
 
+func SetupHTTPEndpoint(ctx context.Context, config *Config) error {
+	logger := klog.FromContext(ctx)
+	logger = klog.LoggerWithName(logger, "http-server")
+	mux := http.NewServeMux()
+
+	if config.flags.metricsPath != "" {
+		// To collect metrics data from the metric handler itself, we
+		// let it register itself and then collect from that registry.
+		reg := prometheus.NewRegistry()
+		gatherers := prometheus.Gatherers{
+			legacyregistry.DefaultGatherer,
+			reg,
+		}
+
+		actualPath := path.Join("/", config.flags.metricsPath)
+		logger.Info("Starting metrics", "path", actualPath)
+		mux.Handle(actualPath,
+			promhttp.InstrumentMetricHandler(
+				reg,
+				promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{})))
+	}
+
+	listener, err := net.Listen("tcp", config.flags.httpEndpoint)
+	if err != nil {
+		return fmt.Errorf("listen on HTTP endpoint: %v", err)
+	}
+
+	go func() {
+		logger.Info("Starting HTTP server", "endpoint", config.flags.httpEndpoint)
+		err := http.Serve(listener, mux)
+		if err != nil {
+			logger.Error(err, "HTTP server failed")
+			klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+		}
+	}()
+
+	return nil
+}
+
 func StartPlugin(ctx context.Context, config *Config) error {
-	err := os.MkdirAll(DriverPluginPath, 0750)
+	err := checkRegistrationSocketUnused(PluginRegistrationPath)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(DriverPluginPath, 0750)
 	if err != nil {
 		return err
 	}
@@ -130,12 +362,7 @@ func StartPlugin(ctx context.Context, config *Config) error {
 		return err
 	}
 
-	dp, err := plugin.Start(
-		driver,
-		plugin.DriverName(DriverName),
-		plugin.RegistrarSocketPath(PluginRegistrationPath),
-		plugin.PluginSocketPath(DriverPluginSocketPath),
-		plugin.KubeletPluginSocketPath(DriverPluginSocketPath))
+	dp, err := startNodeServer(driver, config.flags.nodeAPIVersions.Value())
 	if err != nil {
 		return err
 	}
@@ -144,12 +371,27 @@ func StartPlugin(ctx context.Context, config *Config) error {
 	signal.Notify(sigc, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
 	<-sigc
 
-	dp.Stop()
-
+	// The metadata sockets and token refreshes are stopped before the
+	// plugin sockets, so nothing is left serving once the plugin is gone.
 	err = driver.Shutdown(ctx)
 	if err != nil {
 		klog.FromContext(ctx).Error(err, "Unable to cleanly shutdown driver")
 	}
 
+	dp.Stop()
+
 	return nil
 }
+
+// checkRegistrationSocketUnused fails if another plugin is still serving the
+// registration socket for the driver name. Starting anyway would replace the
+// socket of the other instance, and the kubelet would send its claims here.
+// A leftover socket of an instance which is gone is fine, it gets replaced.
+func checkRegistrationSocketUnused(path string) error {
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return nil
+	}
+	conn.Close()
+	return fmt.Errorf("another instance of driver %s is already registered with the kubelet at %s", DriverName, path)
+}
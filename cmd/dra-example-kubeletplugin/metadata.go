@@ -0,0 +1,198 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+const (
+	metadataSocketName = "metadata.sock"
+
+	// maxUnixSocketPathLength is the size of sun_path on Linux, including
+	// the terminating NUL byte.
+	maxUnixSocketPathLength = 108
+)
+
+// SpaceMetadata is served by GET /space.
+type SpaceMetadata struct {
+	Namespace            string `json:"namespace"`
+	Server               string `json:"server"`
+	CertificateAuthority string `json:"certificateAuthority,omitempty"`
+}
+
+// SpaceToken is served by GET /token.
+type SpaceToken struct {
+	Token               string      `json:"token"`
+	ExpirationTimestamp metav1.Time `json:"expirationTimestamp"`
+}
+
+// MetadataServer serves a small HTTP API on a Unix socket in the artifact
+// directory of every prepared space. Since the socket is only mounted into
+// containers consuming the claim, being able to connect to it is what
+// authenticates a request.
+type MetadataServer struct {
+	sync.Mutex
+	driver  *driver
+	servers map[string]*http.Server
+}
+
+func NewMetadataServer(d *driver) *MetadataServer {
+	return &MetadataServer{
+		driver:  d,
+		servers: make(map[string]*http.Server),
+	}
+}
+
+// metadataSocketPath returns the host path of the socket for one space of a
+// claim.
+func (m *MetadataServer) metadataSocketPath(claimUid string, spaceName string) (string, error) {
+	path := m.driver.cdi.artifacts.ClaimPath(claimUid, spaceName) + "/" + metadataSocketName
+	if len(path) >= maxUnixSocketPathLength {
+		return "", fmt.Errorf("metadata socket path %s exceeds %d bytes, use a shorter claim artifacts root or space name", path, maxUnixSocketPathLength-1)
+	}
+	return path, nil
+}
+
+// Start serves the metadata API for a prepared space, replacing any server
+// already running for it.
+func (m *MetadataServer) Start(ctx context.Context, key string, pc *PreparedClaim, apiServer *APIServerInfo) error {
+	logger := klog.FromContext(ctx)
+
+	path, err := m.metadataSocketPath(pc.ClaimUID, pc.SpaceName)
+	if err != nil {
+		return err
+	}
+
+	m.Stop(ctx, key)
+
+	err = os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to remove stale metadata socket: %v", err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("unable to listen on metadata socket: %v", err)
+	}
+	err = pc.Owner.apply(path, pc.Owner.mode(artifactFilePerm, 0060))
+	if err != nil {
+		listener.Close()
+		return fmt.Errorf("unable to set up metadata socket: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/space", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, &SpaceMetadata{
+			Namespace:            pc.Namespace,
+			Server:               apiServer.Endpoint,
+			CertificateAuthority: string(apiServer.CAData),
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		token, err := m.driver.requestToken(r.Context(), pc.Namespace, r.URL.Query()["audience"], pc.ExpirationTime)
+		if errors.Is(err, errSpaceExpired) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			logger.Error(err, "Unable to serve token", "key", key)
+			http.Error(w, "unable to request token", http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, &SpaceToken{
+			Token:               token.Status.Token,
+			ExpirationTimestamp: token.Status.ExpirationTimestamp,
+		})
+	})
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	m.Lock()
+	m.servers[key] = server
+	m.Unlock()
+
+	go func() {
+		logger.V(4).Info("Serving metadata socket", "key", key, "path", path)
+		err := server.Serve(listener)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error(err, "Metadata socket failed", "key", key)
+		}
+	}()
+
+	return nil
+}
+
+// Stop shuts down the metadata API of a space. The socket file goes away
+// together with the artifact directory.
+func (m *MetadataServer) Stop(ctx context.Context, key string) {
+	m.Lock()
+	server, ok := m.servers[key]
+	delete(m.servers, key)
+	m.Unlock()
+
+	if !ok {
+		return
+	}
+	err := server.Close()
+	if err != nil {
+		klog.FromContext(ctx).Error(err, "Unable to close metadata socket", "key", key)
+	}
+}
+
+// StopAll shuts down the metadata API of every space.
+func (m *MetadataServer) StopAll(ctx context.Context) {
+	m.Lock()
+	keys := make([]string, 0, len(m.servers))
+	for key := range m.servers {
+		keys = append(keys, key)
+	}
+	m.Unlock()
+
+	for _, key := range keys {
+		m.Stop(ctx, key)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
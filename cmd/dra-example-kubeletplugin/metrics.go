@@ -0,0 +1,69 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+const metricsSubsystem = "dra_example_kubeletplugin"
+
+var (
+	staleResourcesRemoved = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "stale_resources_removed_total",
+			Help:           "Number of stale CDI specs, claim artifacts and checkpoint entries removed during startup reconciliation.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"type"},
+	)
+	staleResourcesErrors = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "stale_resources_removal_errors_total",
+			Help:           "Number of stale resources which could not be removed during startup reconciliation.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"type"},
+	)
+	tokenRefreshes = metrics.NewCounterVec(
+		&metrics.CounterOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "token_refreshes_total",
+			Help:           "Number of attempts to refresh the token of a prepared claim, by result.",
+			StabilityLevel: metrics.ALPHA,
+		},
+		[]string{"result"},
+	)
+	expiredTokens = metrics.NewGauge(
+		&metrics.GaugeOpts{
+			Subsystem:      metricsSubsystem,
+			Name:           "expired_tokens",
+			Help:           "Number of prepared claims whose token has expired because it could not be refreshed.",
+			StabilityLevel: metrics.ALPHA,
+		},
+	)
+)
+
+func init() {
+	legacyregistry.MustRegister(staleResourcesRemoved)
+	legacyregistry.MustRegister(staleResourcesErrors)
+	legacyregistry.MustRegister(tokenRefreshes)
+	legacyregistry.MustRegister(expiredTokens)
+}
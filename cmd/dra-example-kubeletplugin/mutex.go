@@ -0,0 +1,75 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"sync"
+)
+
+// PerClaimMutex hands out a lock per claim. Entries are reference counted
+// by the callers holding or waiting for them and removed on the last
+// unlock, so the map does not grow with every claim ever prepared.
+type PerClaimMutex struct {
+	sync.Mutex
+	submutex map[string]*claimMutex
+}
+
+type claimMutex struct {
+	sync.Mutex
+	refs int
+}
+
+// claimLock locks the entry of one claim. It holds no state of its own, so
+// locking and unlocking may go through different values from Get.
+type claimLock struct {
+	m        *PerClaimMutex
+	claimUid string
+}
+
+func NewPerClaimMutex() *PerClaimMutex {
+	return &PerClaimMutex{
+		submutex: make(map[string]*claimMutex),
+	}
+}
+
+func (m *PerClaimMutex) Get(claimUid string) sync.Locker {
+	return &claimLock{m: m, claimUid: claimUid}
+}
+
+func (l *claimLock) Lock() {
+	l.m.Lock()
+	sub := l.m.submutex[l.claimUid]
+	if sub == nil {
+		sub = &claimMutex{}
+		l.m.submutex[l.claimUid] = sub
+	}
+	sub.refs++
+	l.m.Unlock()
+
+	sub.Lock()
+}
+
+func (l *claimLock) Unlock() {
+	l.m.Lock()
+	defer l.m.Unlock()
+	sub := l.m.submutex[l.claimUid]
+	sub.Unlock()
+	sub.refs--
+	if sub.refs == 0 {
+		delete(l.m.submutex, l.claimUid)
+	}
+}
@@ -0,0 +1,52 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestPerClaimMutex(t *testing.T) {
+	m := NewPerClaimMutex()
+
+	var wg sync.WaitGroup
+	// Each claim has its own counter, which only its lock protects.
+	counts := map[string]*int{"uid-1": new(int), "uid-2": new(int)}
+	for i := 0; i < 100; i++ {
+		for _, claimUid := range []string{"uid-1", "uid-2"} {
+			wg.Add(1)
+			go func(claimUid string) {
+				defer wg.Done()
+				lock := m.Get(claimUid)
+				lock.Lock()
+				defer lock.Unlock()
+				*counts[claimUid]++
+			}(claimUid)
+		}
+	}
+	wg.Wait()
+
+	for claimUid, count := range counts {
+		if *count != 100 {
+			t.Errorf("expected 100 locked increments for %s, got %d", claimUid, *count)
+		}
+	}
+	if len(m.submutex) != 0 {
+		t.Errorf("expected all entries to be removed after the last unlock, got %d", len(m.submutex))
+	}
+}
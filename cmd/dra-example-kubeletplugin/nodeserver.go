@@ -0,0 +1,93 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	plugin "k8s.io/dynamic-resource-allocation/kubeletplugin"
+)
+
+const (
+	nodeAPIV1alpha2 = "v1alpha2"
+	nodeAPIV1alpha3 = "v1alpha3"
+)
+
+// supportedNodeAPIVersions are the versions of the kubelet DRA gRPC API the
+// plugin can serve, newest first.
+var supportedNodeAPIVersions = []string{nodeAPIV1alpha3, nodeAPIV1alpha2}
+
+// nodeClaim is a claim passed by the kubelet for one resource handle,
+// independent of the version of the DRA node API it arrived through.
+type nodeClaim struct {
+	Namespace      string
+	Uid            string
+	Name           string
+	ResourceHandle string
+}
+
+// prepareResult is the outcome of preparing a claim.
+type prepareResult struct {
+	CDIDevices []string
+	Error      string
+}
+
+// unprepareResult is the outcome of unpreparing a claim.
+type unprepareResult struct {
+	Error string
+}
+
+// nodeServer adapts the driver to the versions of the kubelet DRA gRPC API.
+// Each version is implemented in its own file by converting requests into
+// nodeClaims and results back into responses.
+type nodeServer struct {
+	driver *driver
+}
+
+func validateNodeAPIVersions(versions []string) error {
+	if len(versions) == 0 {
+		return fmt.Errorf("at least one node API version must be enabled")
+	}
+	for _, version := range versions {
+		supported := false
+		for _, v := range supportedNodeAPIVersions {
+			if version == v {
+				supported = true
+			}
+		}
+		if !supported {
+			return fmt.Errorf("unsupported node API version %q, must be one of: %s", version, strings.Join(supportedNodeAPIVersions, ", "))
+		}
+	}
+	return nil
+}
+
+// startNodeServer serves the given versions of the node API on the plugin
+// socket and registers the plugin with the kubelet.
+func startNodeServer(driver *driver, versions []string) (plugin.DRAPlugin, error) {
+	enabled := sets.New(versions...)
+	return plugin.Start(
+		&nodeServer{driver: driver},
+		plugin.DriverName(DriverName),
+		plugin.RegistrarSocketPath(PluginRegistrationPath),
+		plugin.PluginSocketPath(DriverPluginSocketPath),
+		plugin.KubeletPluginSocketPath(DriverPluginSocketPath),
+		plugin.NodeV1alpha2(enabled.Has(nodeAPIV1alpha2)),
+		plugin.NodeV1alpha3(enabled.Has(nodeAPIV1alpha3)))
+}
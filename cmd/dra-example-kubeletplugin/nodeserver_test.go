@@ -0,0 +1,226 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	drapbv1alpha2 "k8s.io/kubelet/pkg/apis/dra/v1alpha2"
+	drapbv1alpha3 "k8s.io/kubelet/pkg/apis/dra/v1alpha3"
+	registerapi "k8s.io/kubelet/pkg/apis/pluginregistration/v1"
+)
+
+// dialKubeletPlugin connects to a socket of the plugin like the kubelet.
+func dialKubeletPlugin(t *testing.T, path string) *grpc.ClientConn {
+	t.Helper()
+	conn, err := grpc.Dial("unix://"+path, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("unable to connect to %s: %v", path, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestNodeServer(t *testing.T) {
+	tests := []struct {
+		version string
+		// prepare and unprepare call the plugin through its version of
+		// the node API and return the CDI devices or the error message
+		// of the claim.
+		prepare   func(ctx context.Context, conn *grpc.ClientConn, claim *nodeClaim) ([]string, error)
+		unprepare func(ctx context.Context, conn *grpc.ClientConn, claim *nodeClaim) error
+	}{
+		{
+			version: nodeAPIV1alpha3,
+			prepare: func(ctx context.Context, conn *grpc.ClientConn, claim *nodeClaim) ([]string, error) {
+				rsp, err := drapbv1alpha3.NewNodeClient(conn).NodePrepareResources(ctx, &drapbv1alpha3.NodePrepareResourcesRequest{
+					Claims: []*drapbv1alpha3.Claim{{
+						Namespace:      claim.Namespace,
+						Uid:            claim.Uid,
+						Name:           claim.Name,
+						ResourceHandle: claim.ResourceHandle,
+					}},
+				})
+				if err != nil {
+					return nil, err
+				}
+				if len(rsp.Claims) != 1 || rsp.Claims[claim.Uid] == nil {
+					return nil, status.Errorf(codes.Internal, "expected one result for claim %s, got %v", claim.Uid, rsp.Claims)
+				}
+				if msg := rsp.Claims[claim.Uid].Error; msg != "" {
+					return nil, status.Error(codes.Unknown, msg)
+				}
+				return rsp.Claims[claim.Uid].CDIDevices, nil
+			},
+			unprepare: func(ctx context.Context, conn *grpc.ClientConn, claim *nodeClaim) error {
+				rsp, err := drapbv1alpha3.NewNodeClient(conn).NodeUnprepareResources(ctx, &drapbv1alpha3.NodeUnprepareResourcesRequest{
+					Claims: []*drapbv1alpha3.Claim{{
+						Namespace:      claim.Namespace,
+						Uid:            claim.Uid,
+						Name:           claim.Name,
+						ResourceHandle: claim.ResourceHandle,
+					}},
+				})
+				if err != nil {
+					return err
+				}
+				if len(rsp.Claims) != 1 || rsp.Claims[claim.Uid] == nil {
+					return status.Errorf(codes.Internal, "expected one result for claim %s, got %v", claim.Uid, rsp.Claims)
+				}
+				if msg := rsp.Claims[claim.Uid].Error; msg != "" {
+					return status.Error(codes.Unknown, msg)
+				}
+				return nil
+			},
+		},
+		{
+			version: nodeAPIV1alpha2,
+			prepare: func(ctx context.Context, conn *grpc.ClientConn, claim *nodeClaim) ([]string, error) {
+				rsp, err := drapbv1alpha2.NewNodeClient(conn).NodePrepareResource(ctx, &drapbv1alpha2.NodePrepareResourceRequest{
+					Namespace:      claim.Namespace,
+					ClaimUid:       claim.Uid,
+					ClaimName:      claim.Name,
+					ResourceHandle: claim.ResourceHandle,
+				})
+				if err != nil {
+					return nil, err
+				}
+				return rsp.CdiDevices, nil
+			},
+			unprepare: func(ctx context.Context, conn *grpc.ClientConn, claim *nodeClaim) error {
+				_, err := drapbv1alpha2.NewNodeClient(conn).NodeUnprepareResource(ctx, &drapbv1alpha2.NodeUnprepareResourceRequest{
+					Namespace:      claim.Namespace,
+					ClaimUid:       claim.Uid,
+					ClaimName:      claim.Name,
+					ResourceHandle: claim.ResourceHandle,
+				})
+				return err
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.version, func(t *testing.T) {
+			ctx := context.Background()
+			td := newTestDriver(t, 2)
+			claim := td.addClaim(t, "test", "dev", "prod")
+
+			dp, err := startNodeServer(td.driver, []string{tc.version})
+			if err != nil {
+				t.Fatalf("unable to start node server: %v", err)
+			}
+			defer dp.Stop()
+
+			info, err := registerapi.NewRegistrationClient(dialKubeletPlugin(t, PluginRegistrationPath)).GetInfo(ctx, &registerapi.InfoRequest{})
+			if err != nil {
+				t.Fatalf("unable to get plugin info: %v", err)
+			}
+			if info.Type != registerapi.DRAPlugin || info.Name != DriverName || info.Endpoint != DriverPluginSocketPath {
+				t.Errorf("unexpected plugin info: %+v", info)
+			}
+			// Kubelets find the node API version by calling the newest
+			// one and falling back on Unimplemented, so the registration
+			// only carries the version of the registration protocol.
+			wantVersions := []string{"1.0.0"}
+			if !reflect.DeepEqual(info.SupportedVersions, wantVersions) {
+				t.Errorf("expected supported versions %v, got %v", wantVersions, info.SupportedVersions)
+			}
+
+			conn := dialKubeletPlugin(t, DriverPluginSocketPath)
+			devices, err := tc.prepare(ctx, conn, claim)
+			if err != nil {
+				t.Fatalf("unable to prepare claim: %v", err)
+			}
+			for _, ns := range []string{"space-test-dev", "space-test-prod"} {
+				device := td.cdi.GetClaimDevices(claim.Uid, ns)[1]
+				if !containsString(devices, device) {
+					t.Errorf("expected CDI device %s, got %v", device, devices)
+				}
+			}
+
+			// Preparing again is answered from the checkpoint.
+			again, err := tc.prepare(ctx, conn, claim)
+			if err != nil {
+				t.Fatalf("unable to prepare claim again: %v", err)
+			}
+			if !reflect.DeepEqual(again, devices) {
+				t.Errorf("expected the same CDI devices when preparing again, got %v and %v", devices, again)
+			}
+
+			err = tc.unprepare(ctx, conn, claim)
+			if err != nil {
+				t.Fatalf("unable to unprepare claim: %v", err)
+			}
+			if n := len(td.checkpoint.List()); n != 0 {
+				t.Errorf("expected no prepared spaces after unprepare, got %d", n)
+			}
+
+			// Only the enabled version is served.
+			for _, other := range tests {
+				if other.version == tc.version {
+					continue
+				}
+				_, err := other.prepare(ctx, conn, claim)
+				if status.Code(err) != codes.Unimplemented {
+					t.Errorf("expected %s to be unimplemented, got %v", other.version, err)
+				}
+			}
+		})
+	}
+}
+
+func TestNodeListAndWatchResources(t *testing.T) {
+	td := newTestDriver(t, 1)
+	dp, err := startNodeServer(td.driver, []string{nodeAPIV1alpha3})
+	if err != nil {
+		t.Fatalf("unable to start node server: %v", err)
+	}
+	defer dp.Stop()
+
+	client := drapbv1alpha3.NewNodeClient(dialKubeletPlugin(t, DriverPluginSocketPath))
+	stream, err := client.NodeListAndWatchResources(context.Background(), &drapbv1alpha3.NodeListAndWatchResourcesRequest{})
+	if err == nil {
+		_, err = stream.Recv()
+	}
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("expected the kubelet to be told there are no node resources, got %v", err)
+	}
+}
+
+func TestValidateNodeAPIVersions(t *testing.T) {
+	tests := []struct {
+		versions []string
+		valid    bool
+	}{
+		{versions: []string{nodeAPIV1alpha3, nodeAPIV1alpha2}, valid: true},
+		{versions: []string{nodeAPIV1alpha2}, valid: true},
+		{versions: nil, valid: false},
+		{versions: []string{"v1alpha4"}, valid: false},
+	}
+	for _, tc := range tests {
+		err := validateNodeAPIVersions(tc.versions)
+		if (err == nil) != tc.valid {
+			t.Errorf("validateNodeAPIVersions(%v): expected valid=%v, got %v", tc.versions, tc.valid, err)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+
+	"k8s.io/klog/v2"
+	drapbv1alpha2 "k8s.io/kubelet/pkg/apis/dra/v1alpha2"
+)
+
+var _ drapbv1alpha2.NodeServer = &nodeServer{}
+
+// The v1alpha2 API prepares one resource handle per call and reports
+// failures as gRPC errors rather than in the response.
+
+func (s *nodeServer) NodePrepareResource(ctx context.Context, req *drapbv1alpha2.NodePrepareResourceRequest) (*drapbv1alpha2.NodePrepareResourceResponse, error) {
+	logger := klog.FromContext(ctx)
+	logger.Info("NodePrepareResource", "claimUid", req.ClaimUid)
+
+	claim := nodeClaimV1alpha2(req.Namespace, req.ClaimUid, req.ClaimName, req.ResourceHandle)
+	result := s.driver.prepareClaims(ctx, []*nodeClaim{claim})[claim.Uid]
+	if result.Error != "" {
+		return nil, errors.New(result.Error)
+	}
+	return &drapbv1alpha2.NodePrepareResourceResponse{CdiDevices: result.CDIDevices}, nil
+}
+
+func (s *nodeServer) NodeUnprepareResource(ctx context.Context, req *drapbv1alpha2.NodeUnprepareResourceRequest) (*drapbv1alpha2.NodeUnprepareResourceResponse, error) {
+	logger := klog.FromContext(ctx)
+	logger.Info("NodeUnprepareResource", "claimUid", req.ClaimUid)
+
+	claim := nodeClaimV1alpha2(req.Namespace, req.ClaimUid, req.ClaimName, req.ResourceHandle)
+	result := s.driver.unprepareClaims(ctx, []*nodeClaim{claim})[claim.Uid]
+	if result.Error != "" {
+		return nil, errors.New(result.Error)
+	}
+	return &drapbv1alpha2.NodeUnprepareResourceResponse{}, nil
+}
+
+func nodeClaimV1alpha2(namespace string, uid string, name string, resourceHandle string) *nodeClaim {
+	return &nodeClaim{
+		Namespace:      namespace,
+		Uid:            uid,
+		Name:           name,
+		ResourceHandle: resourceHandle,
+	}
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	drapbv1alpha3 "k8s.io/kubelet/pkg/apis/dra/v1alpha3"
+)
+
+var _ drapbv1alpha3.NodeServer = &nodeServer{}
+
+func (s *nodeServer) NodePrepareResources(ctx context.Context, req *drapbv1alpha3.NodePrepareResourcesRequest) (*drapbv1alpha3.NodePrepareResourcesResponse, error) {
+	logger := klog.FromContext(ctx)
+	logger.Info("NodePrepareResources", "numClaims", len(req.Claims))
+
+	results := s.driver.prepareClaims(ctx, nodeClaimsV1alpha3(req.Claims))
+
+	rsp := &drapbv1alpha3.NodePrepareResourcesResponse{Claims: map[string]*drapbv1alpha3.NodePrepareResourceResponse{}}
+	for uid, result := range results {
+		rsp.Claims[uid] = &drapbv1alpha3.NodePrepareResourceResponse{
+			CDIDevices: result.CDIDevices,
+			Error:      result.Error,
+		}
+	}
+	return rsp, nil
+}
+
+func (s *nodeServer) NodeUnprepareResources(ctx context.Context, req *drapbv1alpha3.NodeUnprepareResourcesRequest) (*drapbv1alpha3.NodeUnprepareResourcesResponse, error) {
+	logger := klog.FromContext(ctx)
+	logger.Info("NodeUnprepareResources", "numClaims", len(req.Claims))
+
+	results := s.driver.unprepareClaims(ctx, nodeClaimsV1alpha3(req.Claims))
+
+	rsp := &drapbv1alpha3.NodeUnprepareResourcesResponse{Claims: map[string]*drapbv1alpha3.NodeUnprepareResourceResponse{}}
+	for uid, result := range results {
+		rsp.Claims[uid] = &drapbv1alpha3.NodeUnprepareResourceResponse{
+			Error: result.Error,
+		}
+	}
+	return rsp, nil
+}
+
+// NodeListAndWatchResources is how drivers with structured parameters
+// publish the resources of the node. Spaces are allocated by the
+// controller, so there is nothing to publish, which the kubelet expects to
+// be reported as Unimplemented.
+func (s *nodeServer) NodeListAndWatchResources(req *drapbv1alpha3.NodeListAndWatchResourcesRequest, stream drapbv1alpha3.Node_NodeListAndWatchResourcesServer) error {
+	return status.Error(codes.Unimplemented, "spaces are allocated by the controller, the node has no resources to publish")
+}
+
+func nodeClaimsV1alpha3(claims []*drapbv1alpha3.Claim) []*nodeClaim {
+	result := make([]*nodeClaim, 0, len(claims))
+	for _, claim := range claims {
+		result = append(result, &nodeClaim{
+			Namespace:      claim.Namespace,
+			Uid:            claim.Uid,
+			Name:           claim.Name,
+			ResourceHandle: claim.ResourceHandle,
+		})
+	}
+	return result
+}
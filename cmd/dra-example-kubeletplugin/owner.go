@@ -0,0 +1,203 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// selinuxXattr holds the SELinux label of a file.
+	selinuxXattr = "security.selinux"
+	// selinuxFileContext is the label container runtimes give to volumes
+	// relabeled with the "z" and "Z" options. Only the level is added.
+	selinuxFileContext = "system_u:object_r:container_file_t:"
+	// selinuxSharedLevel is the level of volumes shared between containers.
+	selinuxSharedLevel = "s0"
+)
+
+// selinuxEnforceFile exists if SELinux is enabled on the node. Tests point
+// it elsewhere.
+var selinuxEnforceFile = "/sys/fs/selinux/enforce"
+
+// ArtifactOwner describes who the containers consuming a claim run as, so
+// that the artifacts mounted into them can be made readable. Unset IDs
+// leave the artifacts owned by root.
+type ArtifactOwner struct {
+	UID *int64 `json:"uid,omitempty"`
+	GID *int64 `json:"gid,omitempty"`
+	// SELinuxLevel is the MCS level shared by the containers, if any.
+	SELinuxLevel string `json:"seLinuxLevel,omitempty"`
+}
+
+// claimArtifactOwner derives the owner of a claim's artifacts from the
+// security contexts of the containers which consume the claim. Only values
+// which all of them agree on are used: the user if every container sets the
+// same non-root runAsUser, the group from a common fsGroup or else a common
+// runAsGroup, and a common SELinux level. Pods which join a shared claim
+// later are expected to run with the same security context.
+func claimArtifactOwner(pods []ReservingPod) *ArtifactOwner {
+	var uids, gids, fsGroups []*int64
+	var levels []string
+	for _, rp := range pods {
+		podSC := rp.Pod.Spec.SecurityContext
+		if podSC == nil {
+			podSC = &corev1.PodSecurityContext{}
+		}
+		fsGroups = append(fsGroups, podSC.FSGroup)
+
+		for _, ctr := range consumingContainers(rp) {
+			uid, gid := podSC.RunAsUser, podSC.RunAsGroup
+			seLinux := podSC.SELinuxOptions
+			if sc := ctr.SecurityContext; sc != nil {
+				if sc.RunAsUser != nil {
+					uid = sc.RunAsUser
+				}
+				if sc.RunAsGroup != nil {
+					gid = sc.RunAsGroup
+				}
+				if sc.SELinuxOptions != nil {
+					seLinux = sc.SELinuxOptions
+				}
+			}
+			level := ""
+			if seLinux != nil {
+				level = seLinux.Level
+			}
+			uids = append(uids, uid)
+			gids = append(gids, gid)
+			levels = append(levels, level)
+		}
+	}
+
+	owner := &ArtifactOwner{
+		UID: commonID(uids),
+		GID: commonID(fsGroups),
+	}
+	if owner.UID != nil && *owner.UID == 0 {
+		owner.UID = nil
+	}
+	if owner.GID == nil {
+		owner.GID = commonID(gids)
+	}
+	if len(levels) > 0 {
+		owner.SELinuxLevel = levels[0]
+		for _, level := range levels[1:] {
+			if level != owner.SELinuxLevel {
+				owner.SELinuxLevel = ""
+				break
+			}
+		}
+	}
+	return owner
+}
+
+// consumingContainers returns the containers of the pod which list the
+// claim in their resources, since only those get the claim's CDI devices.
+func consumingContainers(rp ReservingPod) []corev1.Container {
+	var result []corev1.Container
+	for _, ctrs := range [][]corev1.Container{rp.Pod.Spec.InitContainers, rp.Pod.Spec.Containers} {
+		for _, ctr := range ctrs {
+			for _, claim := range ctr.Resources.Claims {
+				if claim.Name == rp.PodClaimName {
+					result = append(result, ctr)
+					break
+				}
+			}
+		}
+	}
+	return result
+}
+
+// commonID returns the ID if all entries are set and equal, nil otherwise.
+func commonID(ids []*int64) *int64 {
+	if len(ids) == 0 || ids[0] == nil {
+		return nil
+	}
+	for _, id := range ids[1:] {
+		if id == nil || *id != *ids[0] {
+			return nil
+		}
+	}
+	id := *ids[0]
+	return &id
+}
+
+// mode returns the permissions for an artifact given those of its owning
+// user. The group gets read access, plus write for sockets, if it is set.
+func (o *ArtifactOwner) mode(userPerm os.FileMode, groupPerm os.FileMode) os.FileMode {
+	if o == nil || o.GID == nil {
+		return userPerm
+	}
+	return userPerm | groupPerm
+}
+
+// apply sets the ownership, permissions and SELinux label of one artifact.
+func (o *ArtifactOwner) apply(path string, perm os.FileMode) error {
+	err := os.Chmod(path, perm)
+	if err != nil {
+		return fmt.Errorf("unable to set permissions of %s: %v", path, err)
+	}
+
+	uid, gid := -1, -1
+	if o != nil && o.UID != nil {
+		uid = int(*o.UID)
+	}
+	if o != nil && o.GID != nil {
+		gid = int(*o.GID)
+	}
+	if uid != -1 || gid != -1 {
+		err = os.Lchown(path, uid, gid)
+		if err != nil {
+			return fmt.Errorf("unable to set ownership of %s: %v", path, err)
+		}
+	}
+
+	return o.relabel(path)
+}
+
+// relabel gives an artifact the label container runtimes use for
+// relabeled volumes. OCI runtimes do not act on the "z" and "Z" mount
+// options, which container engines only handle for volumes they manage
+// themselves, so CDI mounts have to be labeled up front.
+func (o *ArtifactOwner) relabel(path string) error {
+	_, err := os.Stat(selinuxEnforceFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	err = syscall.Setxattr(path, selinuxXattr, []byte(o.selinuxLabel()), 0)
+	if err != nil {
+		return fmt.Errorf("unable to set SELinux label of %s: %v", path, err)
+	}
+	return nil
+}
+
+// selinuxLabel returns the label of the artifacts: that of relabeled
+// volumes, with the level of the containers or the shared level.
+func (o *ArtifactOwner) selinuxLabel() string {
+	level := selinuxSharedLevel
+	if o != nil && o.SELinuxLevel != "" {
+		level = o.SELinuxLevel
+	}
+	return selinuxFileContext + level
+}
@@ -0,0 +1,368 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"syscall"
+	"testing"
+	"time"
+
+	cdispec "github.com/container-orchestrated-devices/container-device-interface/specs-go"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/utils/ptr"
+
+	spacecrd "sigs.k8s.io/dra-example-driver/api/example.com/resource/space/v1alpha1"
+)
+
+// ownerPod returns a pod reserving the claim as "space" with the given pod
+// security context and containers.
+func ownerPod(sc *corev1.PodSecurityContext, ctrs ...corev1.Container) ReservingPod {
+	return ReservingPod{
+		Pod:          &corev1.Pod{Spec: corev1.PodSpec{SecurityContext: sc, Containers: ctrs}},
+		PodClaimName: "space",
+	}
+}
+
+// ownerContainer returns a container with the given security context which
+// consumes the claims with the given names.
+func ownerContainer(sc *corev1.SecurityContext, claims ...string) corev1.Container {
+	ctr := corev1.Container{SecurityContext: sc}
+	for _, claim := range claims {
+		ctr.Resources.Claims = append(ctr.Resources.Claims, corev1.ResourceClaim{Name: claim})
+	}
+	return ctr
+}
+
+func TestClaimArtifactOwner(t *testing.T) {
+	level := func(level string) *corev1.SELinuxOptions {
+		return &corev1.SELinuxOptions{Level: level}
+	}
+
+	tests := []struct {
+		name  string
+		pods  []ReservingPod
+		owner ArtifactOwner
+	}{
+		{
+			name: "no security context",
+			pods: []ReservingPod{ownerPod(nil, ownerContainer(nil, "space"))},
+		},
+		{
+			name: "common user and group",
+			pods: []ReservingPod{
+				ownerPod(&corev1.PodSecurityContext{RunAsUser: ptr.To[int64](1000), RunAsGroup: ptr.To[int64](2000)}, ownerContainer(nil, "space")),
+				ownerPod(&corev1.PodSecurityContext{RunAsUser: ptr.To[int64](1000), RunAsGroup: ptr.To[int64](2000)}, ownerContainer(nil, "space")),
+			},
+			owner: ArtifactOwner{UID: ptr.To[int64](1000), GID: ptr.To[int64](2000)},
+		},
+		{
+			name:  "root user",
+			pods:  []ReservingPod{ownerPod(&corev1.PodSecurityContext{RunAsUser: ptr.To[int64](0), RunAsGroup: ptr.To[int64](0)}, ownerContainer(nil, "space"))},
+			owner: ArtifactOwner{GID: ptr.To[int64](0)},
+		},
+		{
+			name:  "fsGroup takes precedence",
+			pods:  []ReservingPod{ownerPod(&corev1.PodSecurityContext{FSGroup: ptr.To[int64](3000), RunAsGroup: ptr.To[int64](2000)}, ownerContainer(nil, "space"))},
+			owner: ArtifactOwner{GID: ptr.To[int64](3000)},
+		},
+		{
+			name: "fsGroups differ",
+			pods: []ReservingPod{
+				ownerPod(&corev1.PodSecurityContext{FSGroup: ptr.To[int64](3000), RunAsGroup: ptr.To[int64](2000)}, ownerContainer(nil, "space")),
+				ownerPod(&corev1.PodSecurityContext{FSGroup: ptr.To[int64](3001), RunAsGroup: ptr.To[int64](2000)}, ownerContainer(nil, "space")),
+			},
+			owner: ArtifactOwner{GID: ptr.To[int64](2000)},
+		},
+		{
+			name: "users differ",
+			pods: []ReservingPod{
+				ownerPod(&corev1.PodSecurityContext{RunAsUser: ptr.To[int64](1000)}, ownerContainer(nil, "space")),
+				ownerPod(&corev1.PodSecurityContext{RunAsUser: ptr.To[int64](1001)}, ownerContainer(nil, "space")),
+			},
+		},
+		{
+			name: "user set by some pods only",
+			pods: []ReservingPod{
+				ownerPod(&corev1.PodSecurityContext{RunAsUser: ptr.To[int64](1000)}, ownerContainer(nil, "space")),
+				ownerPod(nil, ownerContainer(nil, "space")),
+			},
+		},
+		{
+			name: "container overrides pod",
+			pods: []ReservingPod{ownerPod(
+				&corev1.PodSecurityContext{RunAsUser: ptr.To[int64](1000), RunAsGroup: ptr.To[int64](2000), SELinuxOptions: level("s0:c1,c2")},
+				ownerContainer(&corev1.SecurityContext{RunAsUser: ptr.To[int64](1001), RunAsGroup: ptr.To[int64](2001), SELinuxOptions: level("s0:c3,c4")}, "space"),
+			)},
+			owner: ArtifactOwner{UID: ptr.To[int64](1001), GID: ptr.To[int64](2001), SELinuxLevel: "s0:c3,c4"},
+		},
+		{
+			name: "common SELinux level",
+			pods: []ReservingPod{
+				ownerPod(&corev1.PodSecurityContext{SELinuxOptions: level("s0:c1,c2")}, ownerContainer(nil, "space")),
+				ownerPod(nil, ownerContainer(&corev1.SecurityContext{SELinuxOptions: level("s0:c1,c2")}, "space")),
+			},
+			owner: ArtifactOwner{SELinuxLevel: "s0:c1,c2"},
+		},
+		{
+			name: "SELinux levels differ",
+			pods: []ReservingPod{
+				ownerPod(&corev1.PodSecurityContext{SELinuxOptions: level("s0:c1,c2")}, ownerContainer(nil, "space")),
+				ownerPod(&corev1.PodSecurityContext{SELinuxOptions: level("s0:c3,c4")}, ownerContainer(nil, "space")),
+			},
+		},
+		{
+			name: "SELinux level set by some containers only",
+			pods: []ReservingPod{ownerPod(nil,
+				ownerContainer(&corev1.SecurityContext{SELinuxOptions: level("s0:c1,c2")}, "space"),
+				ownerContainer(nil, "space"),
+			)},
+		},
+		{
+			name: "containers not consuming the claim are ignored",
+			pods: []ReservingPod{ownerPod(&corev1.PodSecurityContext{RunAsUser: ptr.To[int64](1000)},
+				ownerContainer(nil, "space"),
+				ownerContainer(&corev1.SecurityContext{RunAsUser: ptr.To[int64](1001), SELinuxOptions: level("s0:c3,c4")}, "other"),
+				ownerContainer(&corev1.SecurityContext{RunAsUser: ptr.To[int64](1002)}),
+			)},
+			owner: ArtifactOwner{UID: ptr.To[int64](1000)},
+		},
+		{
+			name: "init containers are included",
+			pods: []ReservingPod{func() ReservingPod {
+				rp := ownerPod(&corev1.PodSecurityContext{RunAsUser: ptr.To[int64](1000)}, ownerContainer(nil, "space"))
+				rp.Pod.Spec.InitContainers = []corev1.Container{ownerContainer(&corev1.SecurityContext{RunAsUser: ptr.To[int64](1001)}, "space")}
+				return rp
+			}()},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			owner := claimArtifactOwner(tc.pods)
+			if !equalID(owner.UID, tc.owner.UID) {
+				t.Errorf("expected UID %v, got %v", ptr.Deref(tc.owner.UID, -1), ptr.Deref(owner.UID, -1))
+			}
+			if !equalID(owner.GID, tc.owner.GID) {
+				t.Errorf("expected GID %v, got %v", ptr.Deref(tc.owner.GID, -1), ptr.Deref(owner.GID, -1))
+			}
+			if owner.SELinuxLevel != tc.owner.SELinuxLevel {
+				t.Errorf("expected SELinux level %q, got %q", tc.owner.SELinuxLevel, owner.SELinuxLevel)
+			}
+		})
+	}
+}
+
+func equalID(a, b *int64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func TestSELinuxLabel(t *testing.T) {
+	tests := []struct {
+		name  string
+		owner *ArtifactOwner
+		label string
+	}{
+		{
+			name:  "no owner",
+			label: "system_u:object_r:container_file_t:s0",
+		},
+		{
+			name:  "no level",
+			owner: &ArtifactOwner{UID: ptr.To[int64](1000)},
+			label: "system_u:object_r:container_file_t:s0",
+		},
+		{
+			name:  "level of the containers",
+			owner: &ArtifactOwner{SELinuxLevel: "s0:c1,c2"},
+			label: "system_u:object_r:container_file_t:s0:c1,c2",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if label := tc.owner.selinuxLabel(); label != tc.label {
+				t.Errorf("expected label %s, got %s", tc.label, label)
+			}
+		})
+	}
+}
+
+// setSELinuxEnforceFile points the check for SELinux at path for the
+// duration of the test.
+func setSELinuxEnforceFile(t *testing.T, path string) {
+	prev := selinuxEnforceFile
+	selinuxEnforceFile = path
+	t.Cleanup(func() { selinuxEnforceFile = prev })
+}
+
+func TestRelabel(t *testing.T) {
+	owner := &ArtifactOwner{SELinuxLevel: "s0:c1,c2"}
+
+	t.Run("SELinux disabled", func(t *testing.T) {
+		dir := t.TempDir()
+		setSELinuxEnforceFile(t, filepath.Join(dir, "enforce"))
+		file := filepath.Join(dir, "token")
+		if err := os.WriteFile(file, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := owner.apply(file, 0600); err != nil {
+			t.Fatalf("unable to apply owner: %v", err)
+		}
+		buf := make([]byte, 256)
+		n, err := syscall.Getxattr(file, selinuxXattr, buf)
+		if err == nil && string(buf[:n]) == owner.selinuxLabel() {
+			t.Errorf("file was relabeled although SELinux is disabled")
+		}
+	})
+
+	t.Run("SELinux enabled", func(t *testing.T) {
+		dir := t.TempDir()
+		enforce := filepath.Join(dir, "enforce")
+		if err := os.WriteFile(enforce, []byte("1"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		setSELinuxEnforceFile(t, enforce)
+		file := filepath.Join(dir, "token")
+		if err := os.WriteFile(file, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		err := owner.apply(file, 0600)
+		if errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) {
+			t.Skipf("file system does not support setting SELinux labels: %v", err)
+		}
+		if err != nil {
+			t.Fatalf("unable to apply owner: %v", err)
+		}
+
+		buf := make([]byte, 256)
+		n, err := syscall.Getxattr(file, selinuxXattr, buf)
+		if err != nil {
+			t.Fatalf("unable to read SELinux label: %v", err)
+		}
+		label := string(buf[:n])
+		if label != owner.selinuxLabel() {
+			t.Errorf("expected label %s, got %s", owner.selinuxLabel(), label)
+		}
+	})
+}
+
+// claimDeviceMounts waits for the CDI device to show up in the registry and
+// returns its mounts.
+func (td *testDriver) claimDeviceMounts(t *testing.T, device string) []*cdispec.Mount {
+	t.Helper()
+	var mounts []*cdispec.Mount
+	err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 10*time.Second, true, func(context.Context) (bool, error) {
+		dev := td.cdi.registry.DeviceDB().GetDevice(device)
+		if dev == nil {
+			return false, nil
+		}
+		mounts = dev.ContainerEdits.Mounts
+		return true, nil
+	})
+	if err != nil {
+		t.Fatalf("CDI device %s did not show up in the registry: %v", device, err)
+	}
+	return mounts
+}
+
+func TestClaimMountOptions(t *testing.T) {
+	helper := "/usr/bin/dra-space-credential"
+
+	tests := []struct {
+		layout spacecrd.CredentialLayout
+		// claimMounts is the number of mounts of the claim directory.
+		claimMounts int
+	}{
+		{layout: spacecrd.CredentialLayoutKubeconfig, claimMounts: 1},
+		{layout: spacecrd.CredentialLayoutServiceAccount, claimMounts: 1},
+		{layout: spacecrd.CredentialLayoutBoth, claimMounts: 2},
+	}
+
+	for _, tc := range tests {
+		t.Run(string(tc.layout), func(t *testing.T) {
+			td := newTestDriver(t, 1)
+			claimUid := "uid-" + string(tc.layout)
+			space := "space-" + string(tc.layout)
+			_, err := td.cdi.CreateClaimSpecFile(&ClaimSpecInfo{
+				ClaimUID:         claimUid,
+				ClaimName:        "test-claim",
+				EnvPrefix:        "SPACE",
+				Namespace:        space,
+				APIServer:        &APIServerInfo{Endpoint: testAPIServer},
+				Token:            []byte(testTokenValue),
+				CredentialLayout: tc.layout,
+				CredentialHelper: helper,
+			})
+			if err != nil {
+				t.Fatalf("unable to create claim spec: %v", err)
+			}
+
+			claimPath := td.cdi.artifacts.ClaimPath(claimUid, "")
+			var claimMounts int
+			for _, mount := range td.claimDeviceMounts(t, td.cdi.GetClaimDevices(claimUid, space)[1]) {
+				if slices.Contains(mount.Options, "z") || slices.Contains(mount.Options, "Z") || slices.Contains(mount.Options, "rw") {
+					t.Errorf("mount of %s has unexpected options %v", mount.HostPath, mount.Options)
+				}
+				switch mount.HostPath {
+				case claimPath:
+					claimMounts++
+					if !slices.Equal(mount.Options, claimMountOptions) {
+						t.Errorf("expected options %v for claim directory, got %v", claimMountOptions, mount.Options)
+					}
+				case helper:
+					if !slices.Contains(mount.Options, "ro") || slices.Contains(mount.Options, "noexec") {
+						t.Errorf("credential helper must be mounted read-only and executable, got %v", mount.Options)
+					}
+				default:
+					t.Errorf("unexpected mount of %s", mount.HostPath)
+				}
+			}
+			if claimMounts != tc.claimMounts {
+				t.Errorf("expected %d mounts of the claim directory, got %d", tc.claimMounts, claimMounts)
+			}
+		})
+	}
+
+	t.Run("pod artifacts", func(t *testing.T) {
+		td := newTestDriver(t, 1)
+		podUid := "pod-uid-mounts"
+		if err := td.cdi.CreatePodSpecFile(podUid, true); err != nil {
+			t.Fatalf("unable to create pod spec: %v", err)
+		}
+		mounts := td.claimDeviceMounts(t, td.cdi.GetPodDevice(podUid))
+		if len(mounts) != 1 {
+			t.Fatalf("expected one mount, got %d", len(mounts))
+		}
+		if mounts[0].HostPath != td.cdi.artifacts.PodPath(podUid) {
+			t.Errorf("expected mount of %s, got %s", td.cdi.artifacts.PodPath(podUid), mounts[0].HostPath)
+		}
+		if !slices.Equal(mounts[0].Options, claimMountOptions) {
+			t.Errorf("expected options %v for pod artifacts, got %v", claimMountOptions, mounts[0].Options)
+		}
+	})
+}
@@ -0,0 +1,382 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	resourcev1 "k8s.io/api/resource/v1alpha2"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/klog/v2"
+
+	spacecrd "sigs.k8s.io/dra-example-driver/api/example.com/resource/space/v1alpha1"
+)
+
+const (
+	// podArtifactsContainerPath is where the pod's directory of artifacts,
+	// the merged kubeconfig and spaces manifest, is mounted into containers.
+	podArtifactsContainerPath = "/etc/dra"
+)
+
+// podSpace is one prepared space of the claims of a pod.
+type podSpace struct {
+	// name is the name the pod uses for the claim, followed by the space
+	// name for multi-space claims.
+	name         string
+	podClaimName string
+	pc           *PreparedClaim
+}
+
+// podArtifactsPod returns the pod whose artifacts are mounted for a claim,
+// or nil if there is none. The kubelet prepares a claim once for all pods
+// on the node and passes the same CDI devices to each of them, so the
+// artifacts of the first pod are only mounted if every reserving pod would
+// get the same ones.
+func podArtifactsPod(ctx context.Context, rc *resourcev1.ResourceClaim, pods []ReservingPod) *corev1.Pod {
+	logger := klog.FromContext(ctx)
+
+	signature := podArtifactsSignature(pods[0].Pod)
+	for _, pod := range pods[1:] {
+		if podArtifactsSignature(pod.Pod) != signature {
+			logger.Info("Pods sharing claim use different claims or default contexts, not mounting pod artifacts", "claim", klog.KObj(rc))
+			return nil
+		}
+	}
+	return pods[0].Pod
+}
+
+// podArtifactsSignature describes what the artifacts of a pod are built
+// from: the claims its containers consume, by name, and its default
+// context.
+func podArtifactsSignature(pod *corev1.Pod) string {
+	var parts []string
+	for i := range pod.Spec.ResourceClaims {
+		podClaim := &pod.Spec.ResourceClaims[i]
+		if !podClaimUsed(pod, podClaim.Name) {
+			continue
+		}
+		parts = append(parts, podClaim.Name+"="+podResourceClaimName(pod, podClaim))
+	}
+	parts = append(parts, pod.Annotations[DefaultContextAnnotation])
+	return strings.Join(parts, "\n")
+}
+
+// podClaimUsed returns whether any container of the pod consumes the claim
+// with the given name in the pod spec. The kubelet does not prepare claims
+// which no container consumes.
+func podClaimUsed(pod *corev1.Pod, podClaimName string) bool {
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, container := range containers {
+			for _, claim := range container.Resources.Claims {
+				if claim.Name == podClaimName {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// containersShareSpaces returns whether every container of the pod
+// consumes all of its spaces.
+func containersShareSpaces(pod *corev1.Pod, spaces []podSpace) bool {
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for _, container := range containers {
+			consumed := sets.New[string]()
+			for _, claim := range container.Resources.Claims {
+				consumed.Insert(claim.Name)
+			}
+			for _, space := range spaces {
+				if !consumed.Has(space.podClaimName) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// podSpaces returns the spaces of the claims consumed by the pod's
+// containers which have been prepared on this node, in the order of the
+// claims in the pod spec.
+func (d *driver) podSpaces(ctx context.Context, pod *corev1.Pod) []podSpace {
+	prepared := d.checkpoint.List()
+	var spaces []podSpace
+	for i := range pod.Spec.ResourceClaims {
+		podClaim := &pod.Spec.ResourceClaims[i]
+		if !podClaimUsed(pod, podClaim.Name) {
+			continue
+		}
+		name := podResourceClaimName(pod, podClaim)
+		if name == "" {
+			continue
+		}
+		claim, err := d.cache.GetClaimByName(ctx, pod.Namespace, name)
+		if err != nil {
+			// Not every claim of the pod is necessarily a space.
+			continue
+		}
+
+		var claimSpaces []podSpace
+		for _, pc := range prepared {
+			if pc.ClaimUID != string(claim.UID) {
+				continue
+			}
+			space := podSpace{name: podClaim.Name, podClaimName: podClaim.Name, pc: pc}
+			if pc.SpaceName != "" {
+				space.name += "-" + pc.SpaceName
+			}
+			claimSpaces = append(claimSpaces, space)
+		}
+		sort.Slice(claimSpaces, func(i, j int) bool { return claimSpaces[i].name < claimSpaces[j].name })
+		spaces = append(spaces, claimSpaces...)
+	}
+	return spaces
+}
+
+// updatePodArtifacts writes the merged kubeconfig and spaces manifest,
+// whichever are enabled, into the artifact directory of the pod with the
+// given UID. They cover every space of the claims of pod which has been
+// prepared on this node. pod is one of the current consumers of the claims
+// and need not be the pod the directory is named after: the directory
+// stays mounted into running pods while the pods consuming a claim change.
+// It is called for each claim of the pod as it is prepared, so the
+// artifacts are complete once the last one is, and again whenever a claim
+// is prepared anew.
+func (d *driver) updatePodArtifacts(ctx context.Context, podUid string, pod *corev1.Pod, owner *ArtifactOwner) error {
+	lock := d.lock.Get(podLockKey(podUid))
+	lock.Lock()
+	defer lock.Unlock()
+
+	spaces := d.podSpaces(ctx, pod)
+
+	if d.mergedKubeconfig {
+		err := d.writePodKubeconfig(ctx, podUid, pod, spaces, owner)
+		if err != nil {
+			return err
+		}
+	}
+
+	if d.spacesManifest {
+		err := d.writeSpacesManifest(podUid, pod, spaces, owner)
+		if err != nil {
+			return err
+		}
+	}
+
+	return d.cdi.CreatePodSpecFile(podUid, d.mergedKubeconfig)
+}
+
+// refreshSpacesManifests rewrites the spaces manifests which include a
+// prepared space, after its descriptor has changed.
+func (d *driver) refreshSpacesManifests(ctx context.Context, pc *PreparedClaim) error {
+	if !d.spacesManifest || len(pc.PodUIDs) == 0 {
+		return nil
+	}
+	rc, err := d.cache.GetClaim(ctx, pc.ClaimNamespace, pc.ClaimName, pc.ClaimUID)
+	if err != nil {
+		return err
+	}
+	pods, err := d.cache.ReservingPods(rc)
+	if err != nil {
+		return err
+	}
+	if len(pods) == 0 {
+		return nil
+	}
+	pod := podArtifactsPod(ctx, rc, pods)
+	if pod == nil {
+		return nil
+	}
+	for _, podUid := range pc.PodUIDs {
+		err := d.updatePodArtifacts(ctx, podUid, pod, pc.Owner)
+		if err != nil {
+			return fmt.Errorf("unable to update artifacts for pod: %v", err)
+		}
+	}
+	return nil
+}
+
+// reservingPodUids returns the sorted UIDs of the given pods.
+func reservingPodUids(pods []ReservingPod) []string {
+	uids := make([]string, 0, len(pods))
+	for _, pod := range pods {
+		uids = append(uids, string(pod.Pod.UID))
+	}
+	sort.Strings(uids)
+	return uids
+}
+
+// podConsumersChanged returns whether the pods on this node consuming a
+// claim are not the ones its pod artifacts were built for, so that the
+// claim must be prepared anew rather than answered from the checkpoint.
+func (d *driver) podConsumersChanged(ctx context.Context, pc *PreparedClaim) bool {
+	if !d.mergedKubeconfig && !d.spacesManifest {
+		return false
+	}
+	_, pods, err := d.cache.WaitForReservingPods(ctx, pc.ClaimNamespace, pc.ClaimName, pc.ClaimUID)
+	if err != nil {
+		// Preparing anew reports the error.
+		return true
+	}
+	return !slices.Equal(reservingPodUids(pods), pc.Consumers)
+}
+
+// writePodKubeconfig writes the merged kubeconfig for a pod, with one
+// context for every space which exposes a kubeconfig, named after the
+// space. The first one is the current context unless the pod picks another.
+//
+// All containers of the pod mount the same kubeconfig, but each only mounts
+// the credentials of the claims it consumes itself, so in containers which
+// consume only some of the claims the other contexts do not work.
+func (d *driver) writePodKubeconfig(ctx context.Context, podUid string, pod *corev1.Pod, spaces []podSpace, owner *ArtifactOwner) error {
+	logger := klog.FromContext(ctx)
+
+	if !containersShareSpaces(pod, spaces) {
+		logger.Info("Containers of pod consume different space claims, contexts of claims a container does not consume lack credentials in it", "pod", klog.KObj(pod))
+	}
+
+	merged := clientcmdapi.NewConfig()
+	for _, space := range spaces {
+		if !space.pc.KubeconfigMounted {
+			continue
+		}
+		err := addClaimContext(merged, space.name, d.cdi.artifacts.ClaimPath(space.pc.ClaimUID, space.pc.SpaceName))
+		if err != nil {
+			return err
+		}
+		if merged.CurrentContext == "" {
+			merged.CurrentContext = space.name
+		}
+	}
+	if name := pod.Annotations[DefaultContextAnnotation]; name != "" {
+		if _, ok := merged.Contexts[name]; ok {
+			merged.CurrentContext = name
+		} else {
+			logger.Info("Default context of pod does not name a space claim", "pod", klog.KObj(pod), "context", name)
+		}
+	}
+
+	content, err := clientcmd.Write(*merged)
+	if err != nil {
+		return fmt.Errorf("unable to serialize merged kubeconfig: %v", err)
+	}
+	_, err = d.cdi.artifacts.WritePodFile(podUid, "kubeconfig", content, owner)
+	return err
+}
+
+// writeSpacesManifest writes the manifest listing all spaces of a pod,
+// assembled from the descriptors in the claim directories.
+func (d *driver) writeSpacesManifest(podUid string, pod *corev1.Pod, spaces []podSpace, owner *ArtifactOwner) error {
+	manifest := &spacecrd.SpacesManifest{
+		Version: spacecrd.SpacesManifestVersion,
+		Spaces:  []spacecrd.SpaceManifestEntry{},
+	}
+	for _, space := range spaces {
+		path := filepath.Join(d.cdi.artifacts.ClaimPath(space.pc.ClaimUID, space.pc.SpaceName), spaceDescriptorName)
+		content, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			// Claims prepared by older versions have no descriptor.
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read space descriptor: %v", err)
+		}
+		var entry spacecrd.SpaceManifestEntry
+		err = json.Unmarshal(content, &entry)
+		if err != nil {
+			return fmt.Errorf("unable to decode space descriptor %s: %v", path, err)
+		}
+		entry.ClaimName = space.podClaimName
+		manifest.Spaces = append(manifest.Spaces, entry)
+	}
+
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to encode spaces manifest: %v", err)
+	}
+	_, err = d.cdi.artifacts.WritePodFile(podUid, filepath.Base(spacecrd.SpacesManifestPath), content, owner)
+	return err
+}
+
+// addClaimContext copies the single context of a claim's kubeconfig into
+// the merged kubeconfig under the given name.
+func addClaimContext(merged *clientcmdapi.Config, name string, claimPath string) error {
+	kubeconfig, err := clientcmd.LoadFromFile(filepath.Join(claimPath, "kubeconfig"))
+	if err != nil {
+		return fmt.Errorf("unable to load claim kubeconfig: %v", err)
+	}
+	claimContext, ok := kubeconfig.Contexts[kubeconfig.CurrentContext]
+	if !ok {
+		return fmt.Errorf("claim kubeconfig in %s has no current context", claimPath)
+	}
+
+	merged.Clusters[name] = kubeconfig.Clusters[claimContext.Cluster]
+	merged.AuthInfos[name] = kubeconfig.AuthInfos[claimContext.AuthInfo]
+	merged.Contexts[name] = &clientcmdapi.Context{
+		Cluster:   name,
+		AuthInfo:  name,
+		Namespace: claimContext.Namespace,
+	}
+	return nil
+}
+
+// deletePodArtifacts removes the artifacts of the given pods once
+// none of their claims is prepared anymore.
+func (d *driver) deletePodArtifacts(ctx context.Context, podUids []string) error {
+	logger := klog.FromContext(ctx)
+
+	for _, podUid := range podUids {
+		lock := d.lock.Get(podLockKey(podUid))
+		lock.Lock()
+		inUse := d.preparedPodUids().Has(podUid)
+		var err error
+		if !inUse {
+			logger.Info("deleting pod artifacts", "podUid", podUid)
+			err = d.cdi.DeletePodSpecFile(podUid)
+		}
+		lock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// preparedPodUids returns the pods which have artifacts for one of the
+// prepared claims.
+func (d *driver) preparedPodUids() sets.Set[string] {
+	podUids := sets.New[string]()
+	for _, pc := range d.checkpoint.List() {
+		podUids.Insert(pc.PodUIDs...)
+	}
+	return podUids
+}
+
+func podLockKey(podUid string) string {
+	return "pod/" + podUid
+}
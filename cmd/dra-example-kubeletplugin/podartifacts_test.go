@@ -0,0 +1,309 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	resourcev1 "k8s.io/api/resource/v1alpha2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/clientcmd"
+
+	spacecrd "sigs.k8s.io/dra-example-driver/api/example.com/resource/space/v1alpha1"
+)
+
+func TestPodArtifactsPod(t *testing.T) {
+	setDriverName("space.resource.example.com")
+
+	// Claims are given as "<name in pod spec>=<claim name>".
+	newPod := func(name string, claims []string, defaultContext string, containerClaims ...string) *corev1.Pod {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", UID: types.UID("uid-" + name)}}
+		for _, claim := range claims {
+			podClaimName, claimName, _ := strings.Cut(claim, "=")
+			pod.Spec.ResourceClaims = append(pod.Spec.ResourceClaims, corev1.PodResourceClaim{
+				Name:   podClaimName,
+				Source: corev1.ClaimSource{ResourceClaimName: &claimName},
+			})
+		}
+		container := corev1.Container{Name: "ctr"}
+		for _, name := range containerClaims {
+			container.Resources.Claims = append(container.Resources.Claims, corev1.ResourceClaim{Name: name})
+		}
+		pod.Spec.Containers = []corev1.Container{container}
+		if defaultContext != "" {
+			pod.Annotations = map[string]string{DefaultContextAnnotation: defaultContext}
+		}
+		return pod
+	}
+
+	tests := []struct {
+		name string
+		pods []*corev1.Pod
+		want int
+	}{
+		{
+			name: "single pod",
+			pods: []*corev1.Pod{newPod("a", []string{"space=shared"}, "", "space")},
+			want: 0,
+		},
+		{
+			name: "pods with the same claims",
+			pods: []*corev1.Pod{
+				newPod("a", []string{"space=shared"}, "", "space"),
+				newPod("b", []string{"space=shared"}, "", "space"),
+			},
+			want: 0,
+		},
+		{
+			name: "unused claim is ignored",
+			pods: []*corev1.Pod{
+				newPod("a", []string{"space=shared"}, "", "space"),
+				newPod("b", []string{"space=shared", "other=unused"}, "", "space"),
+			},
+			want: 0,
+		},
+		{
+			name: "different claim names",
+			pods: []*corev1.Pod{
+				newPod("a", []string{"space=shared"}, "", "space"),
+				newPod("b", []string{"dev=shared"}, "", "dev"),
+			},
+			want: -1,
+		},
+		{
+			name: "additional claim",
+			pods: []*corev1.Pod{
+				newPod("a", []string{"space=shared"}, "", "space"),
+				newPod("b", []string{"space=shared", "other=own"}, "", "space", "other"),
+			},
+			want: -1,
+		},
+		{
+			name: "different default contexts",
+			pods: []*corev1.Pod{
+				newPod("a", []string{"space=shared"}, "space", "space"),
+				newPod("b", []string{"space=shared"}, "", "space"),
+			},
+			want: -1,
+		},
+	}
+
+	rc := &resourcev1.ResourceClaim{ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "default"}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var pods []ReservingPod
+			for _, pod := range tc.pods {
+				pods = append(pods, ReservingPod{Pod: pod})
+			}
+			got := podArtifactsPod(context.Background(), rc, pods)
+			if tc.want < 0 {
+				if got != nil {
+					t.Errorf("expected no pod artifacts, got those of pod %s", got.Name)
+				}
+				return
+			}
+			if got != tc.pods[tc.want] {
+				t.Errorf("expected the artifacts of pod %s, got %v", tc.pods[tc.want].Name, got)
+			}
+		})
+	}
+}
+
+func TestSpacesManifest(t *testing.T) {
+	td := newTestDriver(t, 1)
+	td.spacesManifest = true
+	expiration := metav1.NewTime(time.Now().Add(time.Hour).Truncate(time.Second))
+	td.core.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		return true, &authenticationv1.TokenRequest{
+			Status: authenticationv1.TokenRequestStatus{
+				Token:               testTokenValue,
+				ExpirationTimestamp: expiration,
+			},
+		}, nil
+	})
+	claim := td.addClaim(t, "test", "dev", "prod")
+	ctx := context.Background()
+
+	result := td.prepareClaims(ctx, []*nodeClaim{claim})[claim.Uid]
+	if result.Error != "" {
+		t.Fatalf("unable to prepare claim: %s", result.Error)
+	}
+
+	readManifest := func() *spacecrd.SpacesManifest {
+		t.Helper()
+		content, err := os.ReadFile(filepath.Join(td.cdi.artifacts.PodPath("pod-uid-test"), filepath.Base(spacecrd.SpacesManifestPath)))
+		if err != nil {
+			t.Fatalf("unable to read spaces manifest: %v", err)
+		}
+		var manifest spacecrd.SpacesManifest
+		err = json.Unmarshal(content, &manifest)
+		if err != nil {
+			t.Fatalf("unable to decode spaces manifest: %v", err)
+		}
+		return &manifest
+	}
+	checkExpiration := func(manifest *spacecrd.SpacesManifest, want metav1.Time) {
+		t.Helper()
+		for i := range manifest.Spaces {
+			entry := &manifest.Spaces[i]
+			if entry.ExpirationTime == nil || !entry.ExpirationTime.Equal(&want) {
+				t.Errorf("expected space %s to expire at %v, got %v", entry.SpaceName, want, entry.ExpirationTime)
+			}
+			// Compared separately, since decoding changes the location.
+			entry.ExpirationTime = nil
+		}
+	}
+
+	manifest := readManifest()
+	checkExpiration(manifest, expiration)
+	want := &spacecrd.SpacesManifest{Version: spacecrd.SpacesManifestVersion}
+	for _, spaceName := range []string{"dev", "prod"} {
+		want.Spaces = append(want.Spaces, spacecrd.SpaceManifestEntry{
+			ClaimName:         "space",
+			ResourceClaimName: "test",
+			SpaceName:         spaceName,
+			Namespace:         "space-test-" + spaceName,
+			Server:            testAPIServer,
+			Credentials: spacecrd.SpaceCredentialPaths{
+				Kubeconfig: "/etc/test/" + spaceName + "/kubeconfig",
+				Token:      "/etc/test/" + spaceName + "/token",
+				CACert:     "/etc/test/" + spaceName + "/ca.crt",
+			},
+		})
+	}
+	if !reflect.DeepEqual(manifest, want) {
+		t.Errorf("expected spaces manifest %+v, got %+v", want, manifest)
+	}
+
+	// A refreshed token moves the expiration time of its space only.
+	refreshed := metav1.NewTime(expiration.Add(time.Hour))
+	expiration = refreshed
+	_, err := td.refreshToken(ctx, preparedClaimKey(claim.Uid, "dev"))
+	if err != nil {
+		t.Fatalf("unable to refresh token: %v", err)
+	}
+	manifest = readManifest()
+	if len(manifest.Spaces) != 2 {
+		t.Fatalf("expected two spaces after the refresh, got %+v", manifest.Spaces)
+	}
+	dev, prod := manifest.Spaces[0], manifest.Spaces[1]
+	if dev.ExpirationTime == nil || !dev.ExpirationTime.Equal(&refreshed) {
+		t.Errorf("expected refreshed space to expire at %v, got %v", refreshed, dev.ExpirationTime)
+	}
+	if prod.ExpirationTime == nil || prod.ExpirationTime.Equal(&refreshed) {
+		t.Errorf("expected other space to keep its expiration time, got %v", prod.ExpirationTime)
+	}
+}
+
+// TestPodArtifactsConsumers checks that the pod artifacts of a claim are
+// rebuilt from the pods which currently consume it, in the directory which
+// running pods already mount.
+func TestPodArtifactsConsumers(t *testing.T) {
+	td := newTestDriver(t, 1)
+	td.mergedKubeconfig = true
+	claim := td.addClaim(t, "test", "dev", "prod")
+	ctx := context.Background()
+
+	currentContext := func() string {
+		t.Helper()
+		config, err := clientcmd.LoadFromFile(filepath.Join(td.cdi.artifacts.PodPath("pod-uid-test"), "kubeconfig"))
+		if err != nil {
+			t.Fatalf("unable to read merged kubeconfig: %v", err)
+		}
+		return config.CurrentContext
+	}
+
+	result := td.prepareClaims(ctx, []*nodeClaim{claim})[claim.Uid]
+	if result.Error != "" {
+		t.Fatalf("unable to prepare claim: %s", result.Error)
+	}
+	if got := currentContext(); got != "space-dev" {
+		t.Errorf("expected current context space-dev, got %s", got)
+	}
+
+	// The first pod is gone and another one picking the other space
+	// consumes the claim now.
+	pod, err := td.core.CoreV1().Pods(testNamespace).Get(ctx, "pod-test", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod = pod.DeepCopy()
+	pod.ResourceVersion = ""
+	pod.Name = "pod-other"
+	pod.UID = "pod-uid-other"
+	pod.Annotations = map[string]string{DefaultContextAnnotation: "space-prod"}
+	_, err = td.core.CoreV1().Pods(testNamespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := td.core.ResourceV1alpha2().ResourceClaims(testNamespace).Get(ctx, claim.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc.Status.ReservedFor = []resourcev1.ResourceClaimConsumerReference{{Resource: "pods", Name: pod.Name, UID: pod.UID}}
+	_, err = td.core.ResourceV1alpha2().ResourceClaims(testNamespace).UpdateStatus(ctx, rc, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 10*time.Second, true, func(context.Context) (bool, error) {
+		rc, err := td.cache.GetClaim(ctx, claim.Namespace, claim.Name, claim.Uid)
+		if err != nil {
+			return false, nil
+		}
+		pods, err := td.cache.ReservingPods(rc)
+		return err == nil && len(pods) == 1 && pods[0].Pod.UID == pod.UID, nil
+	})
+	if err != nil {
+		t.Fatalf("informers did not observe the new consumer: %v", err)
+	}
+
+	result = td.prepareClaims(ctx, []*nodeClaim{claim})[claim.Uid]
+	if result.Error != "" {
+		t.Fatalf("unable to prepare claim again: %s", result.Error)
+	}
+	if !containsString(result.CDIDevices, td.cdi.GetPodDevice("pod-uid-test")) {
+		t.Errorf("expected the artifact directory mounted before to be kept, got %v", result.CDIDevices)
+	}
+	if got := currentContext(); got != "space-prod" {
+		t.Errorf("expected the new consumer to pick current context space-prod, got %s", got)
+	}
+	for _, spaceName := range []string{"dev", "prod"} {
+		pc, ok := td.checkpoint.Get(preparedClaimKey(claim.Uid, spaceName))
+		if !ok {
+			t.Fatalf("expected space %s to be checkpointed", spaceName)
+		}
+		if want := []string{"pod-uid-other"}; !reflect.DeepEqual(pc.Consumers, want) {
+			t.Errorf("expected consumers %v of space %s, got %v", want, spaceName, pc.Consumers)
+		}
+	}
+}
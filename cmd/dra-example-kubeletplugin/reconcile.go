@@ -0,0 +1,135 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+)
+
+const (
+	staleTypeCDISpec        = "cdi_spec"
+	staleTypeClaimArtifacts = "claim_artifacts"
+	staleTypeCheckpoint     = "checkpoint"
+	staleTypePodArtifacts   = "pod_artifacts"
+
+	// claimUIDLength is the length of the UUIDs the API server assigns to
+	// ResourceClaims. Claim specs are named after the UID, optionally
+	// followed by "-<space name>".
+	claimUIDLength = 36
+)
+
+// reconcileStaleClaims removes CDI specs, claim and pod artifacts and
+// checkpoint entries left behind for claims which are no longer in use on this node,
+// e.g. because the node rebooted or the plugin crashed while unpreparing.
+// It must run before the plugin registers with the kubelet.
+func (d *driver) reconcileStaleClaims(ctx context.Context) error {
+	logger := klog.FromContext(ctx)
+
+	// Without knowing which claims are in use, nothing can be told to be
+	// stale.
+	claims, err := d.cache.ListNodeClaims(ctx)
+	if err != nil {
+		return err
+	}
+	active := sets.New[string]()
+	for _, claim := range claims {
+		if d.cache.IsActive(claim) {
+			active.Insert(string(claim.UID))
+		}
+	}
+
+	var removed, failed int
+	record := func(staleType string, err error, keysAndValues ...any) {
+		if err != nil {
+			failed++
+			staleResourcesErrors.WithLabelValues(staleType).Inc()
+			logger.Error(err, "Unable to remove stale resource", append([]any{"type", staleType}, keysAndValues...)...)
+			return
+		}
+		removed++
+		staleResourcesRemoved.WithLabelValues(staleType).Inc()
+		logger.Info("Removed stale resource", append([]any{"type", staleType}, keysAndValues...)...)
+	}
+
+	// The CDI registry only notices removed specs asynchronously, so
+	// those removed along with their checkpoint entry are still listed.
+	removedSpecs := sets.New[string]()
+	for key, pc := range d.checkpoint.List() {
+		if active.Has(pc.ClaimUID) {
+			continue
+		}
+		err := d.cdi.DeletePreparedClaim(pc)
+		if err == nil {
+			removedSpecs.Insert(pc.CDISpecName)
+			err = d.checkpoint.Remove(key)
+		}
+		record(staleTypeCheckpoint, err, "claimUid", pc.ClaimUID, "space", pc.SpaceName)
+	}
+
+	for _, specName := range d.cdi.ListClaimSpecNames() {
+		if removedSpecs.Has(specName) {
+			continue
+		}
+		claimUid := specName[len(claimSpecName("", "")):]
+		if len(claimUid) >= claimUIDLength {
+			claimUid = claimUid[:claimUIDLength]
+		}
+		if active.Has(claimUid) {
+			continue
+		}
+		err := d.cdi.removeSpec(specName)
+		record(staleTypeCDISpec, err, "claimUid", claimUid, "specName", specName)
+	}
+
+	claimUids, err := d.cdi.artifacts.List()
+	if err != nil {
+		return err
+	}
+	for _, claimUid := range claimUids {
+		if active.Has(claimUid) {
+			continue
+		}
+		err := d.cdi.artifacts.Delete(claimUid, "")
+		record(staleTypeClaimArtifacts, err, "claimUid", claimUid, "hostPath", d.cdi.artifacts.ClaimPath(claimUid, ""))
+	}
+
+	// Pod artifacts are stale once none of the remaining claims refers to
+	// the pod.
+	podUids, err := d.cdi.artifacts.ListPods()
+	if err != nil {
+		return err
+	}
+	podUids = append(podUids, d.cdi.ListPodSpecUids()...)
+	inUse := d.preparedPodUids()
+	for _, podUid := range sets.List(sets.New(podUids...)) {
+		if inUse.Has(podUid) {
+			continue
+		}
+		err := d.cdi.DeletePodSpecFile(podUid)
+		record(staleTypePodArtifacts, err, "podUid", podUid, "hostPath", d.cdi.artifacts.PodPath(podUid))
+	}
+
+	logger.Info("Reconciled stale claims", "node", d.nodeName, "activeClaims", active.Len(), "removed", removed, "failed", failed)
+	if failed > 0 {
+		return fmt.Errorf("unable to remove %d stale resources", failed)
+	}
+	return nil
+}
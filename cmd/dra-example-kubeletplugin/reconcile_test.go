@@ -0,0 +1,217 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+// staleCounts returns the values of the stale resource counters by type.
+func staleCounts(t *testing.T) map[string]float64 {
+	t.Helper()
+	counts := make(map[string]float64)
+	for _, staleType := range []string{staleTypeCDISpec, staleTypeClaimArtifacts, staleTypeCheckpoint, staleTypePodArtifacts} {
+		removed, err := testutil.GetCounterMetricValue(staleResourcesRemoved.WithLabelValues(staleType))
+		if err != nil {
+			t.Fatal(err)
+		}
+		failed, err := testutil.GetCounterMetricValue(staleResourcesErrors.WithLabelValues(staleType))
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[staleType] = removed
+		counts[staleType+"_errors"] = failed
+	}
+	return counts
+}
+
+// waitForSpecs waits until the CDI registry lists exactly the given claim
+// specs and pod specs.
+func waitForSpecs(t *testing.T, td *testDriver, claimSpecs []string, podUids []string) {
+	t.Helper()
+	var gotClaimSpecs, gotPodUids []string
+	err := wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 10*time.Second, true, func(context.Context) (bool, error) {
+		gotClaimSpecs = td.cdi.ListClaimSpecNames()
+		gotPodUids = td.cdi.ListPodSpecUids()
+		sort.Strings(gotClaimSpecs)
+		sort.Strings(gotPodUids)
+		return reflect.DeepEqual(gotClaimSpecs, claimSpecs) && reflect.DeepEqual(gotPodUids, podUids), nil
+	})
+	if err != nil {
+		t.Errorf("expected claim specs %v and pod specs %v, got %v and %v", claimSpecs, podUids, gotClaimSpecs, gotPodUids)
+	}
+}
+
+func TestReconcileStaleClaims(t *testing.T) {
+	td := newTestDriver(t, 1)
+	td.mergedKubeconfig = true
+	ctx := context.Background()
+
+	var claims []*nodeClaim
+	for _, name := range []string{"active", "finished", "crashed"} {
+		claim := td.addClaim(t, name)
+		result := td.prepareClaims(ctx, []*nodeClaim{claim})[claim.Uid]
+		if result.Error != "" {
+			t.Fatalf("unable to prepare claim %s: %s", name, result.Error)
+		}
+		claims = append(claims, claim)
+	}
+	active, finished, crashed := claims[0], claims[1], claims[2]
+
+	// The pod of one claim has finished, so the claim is no longer in
+	// use on the node.
+	pod, err := td.core.CoreV1().Pods(testNamespace).Get(ctx, "pod-finished", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pod.Status.Phase = corev1.PodSucceeded
+	_, err = td.core.CoreV1().Pods(testNamespace).UpdateStatus(ctx, pod, metav1.UpdateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 10*time.Second, true, func(ctx context.Context) (bool, error) {
+		rc, err := td.cache.GetClaimByName(ctx, testNamespace, finished.Name)
+		return err == nil && !td.cache.IsActive(rc), nil
+	})
+	if err != nil {
+		t.Fatalf("informers did not observe the finished pod: %v", err)
+	}
+
+	// The plugin crashed while unpreparing another claim, after it
+	// removed the checkpoint entry but before the spec and artifacts.
+	err = td.checkpoint.Remove(preparedClaimKey(crashed.Uid, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = td.core.CoreV1().Pods(testNamespace).Delete(ctx, "pod-crashed", metav1.DeleteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 10*time.Second, true, func(context.Context) (bool, error) {
+		_, exists, err := td.cache.pods.GetStore().GetByKey(testNamespace + "/pod-crashed")
+		return !exists && err == nil, nil
+	})
+	if err != nil {
+		t.Fatalf("informers did not observe the deleted pod: %v", err)
+	}
+
+	// Leftovers of a claim and a pod of which nothing else is known.
+	for _, dir := range []string{td.cdi.artifacts.ClaimPath("orphan", ""), td.cdi.artifacts.PodPath("orphan-pod")} {
+		err := os.MkdirAll(dir, 0750)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	before := staleCounts(t)
+	err = td.reconcileStaleClaims(ctx)
+	if err != nil {
+		t.Fatalf("unable to reconcile stale claims: %v", err)
+	}
+	after := staleCounts(t)
+	removed := make(map[string]float64)
+	for key, value := range after {
+		removed[key] = value - before[key]
+	}
+	want := map[string]float64{
+		// The entry of the finished claim, which takes its CDI spec
+		// and artifacts along.
+		staleTypeCheckpoint:                 1,
+		staleTypeCDISpec:                    1,
+		staleTypeClaimArtifacts:             2,
+		staleTypePodArtifacts:               3,
+		staleTypeCheckpoint + "_errors":     0,
+		staleTypeCDISpec + "_errors":        0,
+		staleTypeClaimArtifacts + "_errors": 0,
+		staleTypePodArtifacts + "_errors":   0,
+	}
+	if !reflect.DeepEqual(removed, want) {
+		t.Errorf("expected removals %v, got %v", want, removed)
+	}
+
+	var keys []string
+	for key := range td.checkpoint.List() {
+		keys = append(keys, key)
+	}
+	if want := []string{preparedClaimKey(active.Uid, "")}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("expected checkpoint entries %v, got %v", want, keys)
+	}
+	// The CDI registry picks up removed specs asynchronously.
+	waitForSpecs(t, td, []string{claimSpecName(active.Uid, "")}, []string{"pod-uid-active"})
+	claimUids, err := td.cdi.artifacts.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{active.Uid}; !reflect.DeepEqual(claimUids, want) {
+		t.Errorf("expected claim artifacts %v, got %v", want, claimUids)
+	}
+	podUids, err := td.cdi.artifacts.ListPods()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"pod-uid-active"}; !reflect.DeepEqual(podUids, want) {
+		t.Errorf("expected pod artifacts %v, got %v", want, podUids)
+	}
+
+	// Nothing is left to remove the second time.
+	err = td.reconcileStaleClaims(ctx)
+	if err != nil {
+		t.Fatalf("unable to reconcile stale claims again: %v", err)
+	}
+	if again := staleCounts(t); !reflect.DeepEqual(again, after) {
+		t.Errorf("expected no further removals, got %v after %v", again, after)
+	}
+}
+
+func TestReconcileStaleClaimsLookupFailure(t *testing.T) {
+	td := newTestDriver(t, 1)
+	ctx := context.Background()
+	claim := td.addClaim(t, "test")
+	result := td.prepareClaims(ctx, []*nodeClaim{claim})[claim.Uid]
+	if result.Error != "" {
+		t.Fatalf("unable to prepare claim: %s", result.Error)
+	}
+
+	// Claims which cannot be looked up might still be in use.
+	td.core.PrependReactor("get", "resourceclaims", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errors.New("API server unavailable")
+	})
+	before := staleCounts(t)
+	err := td.reconcileStaleClaims(ctx)
+	if err == nil {
+		t.Errorf("expected reconciling to fail")
+	}
+	if after := staleCounts(t); !reflect.DeepEqual(after, before) {
+		t.Errorf("expected nothing to be removed, got %v after %v", after, before)
+	}
+	if _, ok := td.checkpoint.Get(preparedClaimKey(claim.Uid, "")); !ok {
+		t.Errorf("expected the claim to stay prepared")
+	}
+}
@@ -0,0 +1,175 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+// TokenRefresher keeps the tokens of prepared claims fresh. Every prepared
+// space gets a goroutine which re-requests its token once the configured
+// fraction of the token's lifetime has elapsed, retrying with backoff on
+// failure, until the space is unprepared or has expired.
+type TokenRefresher struct {
+	sync.Mutex
+	ctx      context.Context
+	fraction float64
+	refresh  func(ctx context.Context, key string) (time.Time, error)
+	cancel   map[string]context.CancelFunc
+	clock    clock.Clock
+	backoff  wait.Backoff
+}
+
+// NewTokenRefresher creates a refresher whose goroutines run until ctx is
+// cancelled. The refresh callback requests and writes a new token for the
+// given checkpoint key and returns its expiration time.
+func NewTokenRefresher(ctx context.Context, fraction float64, refresh func(ctx context.Context, key string) (time.Time, error)) *TokenRefresher {
+	return &TokenRefresher{
+		ctx:      ctx,
+		fraction: fraction,
+		refresh:  refresh,
+		cancel:   make(map[string]context.CancelFunc),
+		clock:    clock.RealClock{},
+		backoff: wait.Backoff{
+			Duration: time.Second,
+			Factor:   2,
+			Jitter:   0.1,
+			Steps:    math.MaxInt32,
+			Cap:      time.Minute,
+		},
+	}
+}
+
+// Start tracks the token of a prepared space, replacing any previous
+// tracking for the same key. A zero expiration refreshes immediately, which
+// is used for claims restored from the checkpoint.
+func (r *TokenRefresher) Start(key string, issued time.Time, expiration time.Time) {
+	r.Lock()
+	defer r.Unlock()
+
+	if cancel, ok := r.cancel[key]; ok {
+		cancel()
+	}
+	ctx, cancel := context.WithCancel(r.ctx)
+	r.cancel[key] = cancel
+	go r.run(ctx, key, issued, expiration)
+}
+
+// Stop ends the tracking of a space.
+func (r *TokenRefresher) Stop(key string) {
+	r.Lock()
+	defer r.Unlock()
+
+	if cancel, ok := r.cancel[key]; ok {
+		cancel()
+		delete(r.cancel, key)
+	}
+}
+
+// StopAll ends the tracking of every space.
+func (r *TokenRefresher) StopAll() {
+	r.Lock()
+	defer r.Unlock()
+
+	for key, cancel := range r.cancel {
+		cancel()
+		delete(r.cancel, key)
+	}
+}
+
+func (r *TokenRefresher) run(ctx context.Context, key string, issued time.Time, expiration time.Time) {
+	logger := klog.FromContext(ctx).WithValues("key", key)
+
+	// expired is set while the last token has expired and could not be
+	// replaced, so that containers can no longer authenticate with it.
+	expired := false
+	setExpired := func(value bool) {
+		switch {
+		case value && !expired:
+			expiredTokens.Inc()
+		case !value && expired:
+			expiredTokens.Dec()
+		}
+		expired = value
+	}
+	defer setExpired(false)
+
+	for {
+		lifetime := expiration.Sub(issued)
+		refreshAt := issued.Add(time.Duration(float64(lifetime) * r.fraction))
+		if !r.sleep(ctx, refreshAt.Sub(r.clock.Now())) {
+			return
+		}
+
+		backoff := r.backoff
+		for {
+			now := r.clock.Now()
+			exp, err := r.refresh(ctx, key)
+			if err != nil && ctx.Err() != nil {
+				// Stopped while refreshing.
+				return
+			}
+			if errors.Is(err, errSpaceExpired) {
+				// The last token lasts as long as the space.
+				logger.Info("Space has expired, no longer refreshing its token", "err", err)
+				return
+			}
+			if err == nil {
+				tokenRefreshes.WithLabelValues("success").Inc()
+				logger.V(4).Info("Refreshed token", "expiration", exp)
+				setExpired(false)
+				issued, expiration = now, exp
+				break
+			}
+
+			tokenRefreshes.WithLabelValues("error").Inc()
+			if !expired && !now.Before(expiration) {
+				setExpired(true)
+				logger.Error(err, "Token has expired and could not be refreshed, retrying", "expiration", expiration)
+			} else {
+				logger.Error(err, "Unable to refresh token, retrying")
+			}
+			if !r.sleep(ctx, backoff.Step()) {
+				return
+			}
+		}
+	}
+}
+
+// sleep waits for the given duration and returns false if tracking was
+// stopped in the meantime.
+func (r *TokenRefresher) sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := r.clock.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C():
+		return true
+	}
+}
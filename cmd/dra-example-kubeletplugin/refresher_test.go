@@ -0,0 +1,317 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/component-base/metrics/testutil"
+	"k8s.io/utils/clock"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+// countingClock is a fake clock which counts the timers created on it, so
+// that tests can wait for the refresher goroutines to go to sleep.
+type countingClock struct {
+	*testingclock.FakeClock
+	timers atomic.Int32
+}
+
+func (c *countingClock) NewTimer(d time.Duration) clock.Timer {
+	c.timers.Add(1)
+	return c.FakeClock.NewTimer(d)
+}
+
+// refreshCall is a call of the refresh callback, which answers it by
+// sending the expiration time of the new token or an error.
+type refreshCall struct {
+	key    string
+	now    time.Time
+	result chan<- refreshResult
+}
+
+type refreshResult struct {
+	expiration time.Time
+	err        error
+}
+
+type refresherTest struct {
+	t       *testing.T
+	clock   *countingClock
+	calls   chan refreshCall
+	timers  int32
+	refresh *TokenRefresher
+}
+
+func newRefresherTest(t *testing.T, fraction float64) *refresherTest {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	rt := &refresherTest{
+		t:     t,
+		clock: &countingClock{FakeClock: testingclock.NewFakeClock(time.Now())},
+		calls: make(chan refreshCall),
+	}
+	rt.refresh = NewTokenRefresher(ctx, fraction, func(ctx context.Context, key string) (time.Time, error) {
+		result := make(chan refreshResult)
+		select {
+		case rt.calls <- refreshCall{key: key, now: rt.clock.Now(), result: result}:
+		case <-ctx.Done():
+			return time.Time{}, ctx.Err()
+		}
+		r := <-result
+		return r.expiration, r.err
+	})
+	rt.refresh.clock = rt.clock
+	rt.refresh.backoff = wait.Backoff{Duration: time.Second, Factor: 2, Steps: 10, Cap: time.Minute}
+	return rt
+}
+
+// waitForTimers waits until n more timers have been created since the
+// last call, i.e. until the goroutines went to sleep.
+func (rt *refresherTest) waitForTimers(n int32) {
+	rt.t.Helper()
+	rt.timers += n
+	err := wait.PollUntilContextTimeout(context.Background(), time.Millisecond, 10*time.Second, true, func(context.Context) (bool, error) {
+		return rt.clock.timers.Load() >= rt.timers, nil
+	})
+	if err != nil {
+		rt.t.Fatalf("expected %d timers, got %d", rt.timers, rt.clock.timers.Load())
+	}
+}
+
+// expectCall waits for the refresh callback to be called for key and
+// answers it.
+func (rt *refresherTest) expectCall(key string, expiration time.Time, err error) refreshCall {
+	rt.t.Helper()
+	select {
+	case call := <-rt.calls:
+		if call.key != key {
+			rt.t.Fatalf("expected refresh of %s, got %s", key, call.key)
+		}
+		call.result <- refreshResult{expiration: expiration, err: err}
+		return call
+	case <-time.After(10 * time.Second):
+		rt.t.Fatalf("expected refresh of %s", key)
+		return refreshCall{}
+	}
+}
+
+// expectNoCall checks that the refresh callback is not called.
+func (rt *refresherTest) expectNoCall() {
+	rt.t.Helper()
+	select {
+	case call := <-rt.calls:
+		rt.t.Fatalf("unexpected refresh of %s at %v", call.key, call.now)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestTokenRefresherSchedule(t *testing.T) {
+	rt := newRefresherTest(t, 0.8)
+	start := rt.clock.Now()
+
+	rt.refresh.Start("a", start, start.Add(100*time.Second))
+	rt.waitForTimers(1)
+	rt.clock.Step(79 * time.Second)
+	rt.expectNoCall()
+
+	// The token is refreshed once 80% of its lifetime have elapsed, and
+	// the new token again once 80% of its own lifetime have.
+	rt.clock.Step(time.Second)
+	call := rt.expectCall("a", start.Add(200*time.Second), nil)
+	if want := start.Add(80 * time.Second); !call.now.Equal(want) {
+		t.Errorf("expected refresh at %v, got %v", want, call.now)
+	}
+	rt.waitForTimers(1)
+	rt.clock.Step(95 * time.Second)
+	rt.expectNoCall()
+	rt.clock.Step(time.Second)
+	call = rt.expectCall("a", start.Add(300*time.Second), nil)
+	if want := start.Add(176 * time.Second); !call.now.Equal(want) {
+		t.Errorf("expected second refresh at %v, got %v", want, call.now)
+	}
+}
+
+func TestTokenRefresherFraction(t *testing.T) {
+	for _, fraction := range []float64{0.25, 0.5, 0.9} {
+		rt := newRefresherTest(t, fraction)
+		start := rt.clock.Now()
+		lifetime := 1000 * time.Second
+		refreshAfter := time.Duration(float64(lifetime) * fraction)
+
+		rt.refresh.Start("a", start, start.Add(lifetime))
+		rt.waitForTimers(1)
+		rt.clock.Step(refreshAfter - time.Second)
+		rt.expectNoCall()
+		rt.clock.Step(time.Second)
+		rt.expectCall("a", start.Add(2*lifetime), nil)
+		rt.refresh.StopAll()
+	}
+}
+
+func TestTokenRefresherRestored(t *testing.T) {
+	rt := newRefresherTest(t, 0.8)
+
+	// Claims restored from the checkpoint have no known expiration time
+	// and are refreshed right away.
+	rt.refresh.Start("a", rt.clock.Now(), time.Time{})
+	rt.expectCall("a", rt.clock.Now().Add(time.Hour), nil)
+}
+
+func TestTokenRefresherRetry(t *testing.T) {
+	rt := newRefresherTest(t, 0.5)
+	start := rt.clock.Now()
+	expiration := start.Add(10 * time.Second)
+	refreshErr := errors.New("API server unavailable")
+	errorsBefore, err := testutil.GetCounterMetricValue(tokenRefreshes.WithLabelValues("error"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiredBefore, err := testutil.GetGaugeMetricValue(expiredTokens)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkExpired := func(want float64) {
+		t.Helper()
+		got, err := testutil.GetGaugeMetricValue(expiredTokens)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got-expiredBefore != want {
+			t.Errorf("expected %v expired tokens, got %v", want, got-expiredBefore)
+		}
+	}
+
+	rt.refresh.Start("a", start, expiration)
+	rt.waitForTimers(1)
+	rt.clock.Step(5 * time.Second)
+
+	// Failures are retried after 1s, 2s, 4s, ... The token expires at
+	// 10s, so the failure at 12s is reported as an expired token.
+	var at []time.Duration
+	for _, backoff := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		call := rt.expectCall("a", time.Time{}, refreshErr)
+		at = append(at, call.now.Sub(start))
+		checkExpired(0)
+		rt.waitForTimers(1)
+		rt.clock.Step(backoff)
+	}
+	call := rt.expectCall("a", time.Time{}, refreshErr)
+	at = append(at, call.now.Sub(start))
+	rt.waitForTimers(1)
+	checkExpired(1)
+	rt.clock.Step(8 * time.Second)
+	call = rt.expectCall("a", rt.clock.Now().Add(time.Hour), nil)
+	at = append(at, call.now.Sub(start))
+	rt.waitForTimers(1)
+	checkExpired(0)
+
+	want := []time.Duration{5 * time.Second, 6 * time.Second, 8 * time.Second, 12 * time.Second, 20 * time.Second}
+	if len(at) != len(want) {
+		t.Fatalf("expected refreshes at %v, got %v", want, at)
+	}
+	for i := range want {
+		if at[i] != want[i] {
+			t.Errorf("expected refreshes at %v, got %v", want, at)
+			break
+		}
+	}
+	errorsAfter, err := testutil.GetCounterMetricValue(tokenRefreshes.WithLabelValues("error"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := errorsAfter - errorsBefore; n != 4 {
+		t.Errorf("expected 4 failed refreshes to be counted, got %v", n)
+	}
+
+	// A token which expired while tracking is stopped no longer counts.
+	rt.clock.Step(time.Hour)
+	rt.expectCall("a", time.Time{}, refreshErr)
+	rt.waitForTimers(1)
+	checkExpired(1)
+	rt.refresh.Stop("a")
+	err = wait.PollUntilContextTimeout(context.Background(), time.Millisecond, 10*time.Second, true, func(context.Context) (bool, error) {
+		got, err := testutil.GetGaugeMetricValue(expiredTokens)
+		return err == nil && got == expiredBefore, nil
+	})
+	if err != nil {
+		t.Errorf("expected the expired token to no longer count once stopped")
+	}
+}
+
+func TestTokenRefresherSpaceExpired(t *testing.T) {
+	rt := newRefresherTest(t, 0.5)
+	start := rt.clock.Now()
+
+	rt.refresh.Start("a", start, start.Add(10*time.Second))
+	rt.waitForTimers(1)
+	rt.clock.Step(5 * time.Second)
+	rt.expectCall("a", time.Time{}, errSpaceExpired)
+
+	// Neither retried nor scheduled again.
+	rt.clock.Step(time.Hour)
+	rt.expectNoCall()
+	if n := rt.clock.timers.Load(); n != rt.timers {
+		t.Errorf("expected no further timers, got %d", n-rt.timers)
+	}
+}
+
+func TestTokenRefresherStop(t *testing.T) {
+	rt := newRefresherTest(t, 0.5)
+	start := rt.clock.Now()
+
+	for _, key := range []string{"a", "b", "c"} {
+		rt.refresh.Start(key, start, start.Add(10*time.Second))
+	}
+	rt.waitForTimers(3)
+
+	rt.refresh.Stop("b")
+	rt.clock.Step(5 * time.Second)
+	var keys []string
+	for range 2 {
+		select {
+		case call := <-rt.calls:
+			keys = append(keys, call.key)
+			call.result <- refreshResult{expiration: rt.clock.Now().Add(10 * time.Second)}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("expected refreshes of a and c, got %v", keys)
+		}
+	}
+	rt.expectNoCall()
+	if !containsString(keys, "a") || !containsString(keys, "c") {
+		t.Errorf("expected refreshes of a and c, got %v", keys)
+	}
+
+	rt.waitForTimers(2)
+	rt.refresh.StopAll()
+	rt.clock.Step(time.Hour)
+	rt.expectNoCall()
+
+	// Starting a key again replaces its previous tracking.
+	rt.refresh.Start("a", rt.clock.Now(), rt.clock.Now().Add(10*time.Second))
+	rt.refresh.Start("a", rt.clock.Now(), rt.clock.Now().Add(100*time.Second))
+	rt.waitForTimers(2)
+	rt.clock.Step(5 * time.Second)
+	rt.expectNoCall()
+	rt.clock.Step(45 * time.Second)
+	rt.expectCall("a", rt.clock.Now().Add(time.Hour), nil)
+}
@@ -0,0 +1,192 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientauthenticationv1 "k8s.io/client-go/pkg/apis/clientauthentication/v1"
+)
+
+const (
+	tokenFileName      = "token"
+	metadataSocketName = "metadata.sock"
+
+	socketTimeout = 10 * time.Second
+)
+
+type Flags struct {
+	dir string
+}
+
+// spaceToken mirrors the response of the kubelet plugin's GET /token.
+type spaceToken struct {
+	Token               string      `json:"token"`
+	ExpirationTimestamp metav1.Time `json:"expirationTimestamp"`
+}
+
+func main() {
+	if err := newApp().Run(os.Args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func newApp() *cli.App {
+	flags := &Flags{}
+
+	cliFlags := []cli.Flag{
+		&cli.StringFlag{
+			Name:        "dir",
+			Usage:       "The `directory` the claim's credentials are mounted at.",
+			Required:    true,
+			Destination: &flags.dir,
+			EnvVars:     []string{"SPACE_CREDENTIAL_DIR"},
+		},
+	}
+
+	app := &cli.App{
+		Name:            "dra-space-credential",
+		Usage:           "dra-space-credential is a client-go credential plugin returning the current token of a space.",
+		ArgsUsage:       " ",
+		HideHelpCommand: true,
+		Flags:           cliFlags,
+		Before: func(c *cli.Context) error {
+			if c.Args().Len() > 0 {
+				return fmt.Errorf("arguments not supported: %v", c.Args().Slice())
+			}
+			return nil
+		},
+		Action: func(c *cli.Context) error {
+			token, err := getToken(c.Context, flags.dir)
+			if err != nil {
+				return err
+			}
+
+			credential := &clientauthenticationv1.ExecCredential{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: clientauthenticationv1.SchemeGroupVersion.String(),
+					Kind:       "ExecCredential",
+				},
+				Status: &clientauthenticationv1.ExecCredentialStatus{
+					Token: token.Token,
+				},
+			}
+			if !token.ExpirationTimestamp.IsZero() {
+				credential.Status.ExpirationTimestamp = &token.ExpirationTimestamp
+			}
+
+			return json.NewEncoder(c.App.Writer).Encode(credential)
+		},
+	}
+
+	return app
+}
+
+// getToken prefers a freshly minted token from the metadata socket and
+// falls back to the token file, which the kubelet plugin keeps rotated.
+func getToken(ctx context.Context, dir string) (*spaceToken, error) {
+	socket := filepath.Join(dir, metadataSocketName)
+	if _, err := os.Stat(socket); err == nil {
+		token, err := getSocketToken(ctx, socket)
+		if err == nil {
+			return token, nil
+		}
+		fmt.Fprintf(os.Stderr, "Warning: falling back to token file: %v\n", err)
+	}
+	return getFileToken(filepath.Join(dir, tokenFileName))
+}
+
+func getSocketToken(ctx context.Context, socket string) (*spaceToken, error) {
+	client := &http.Client{
+		Timeout: socketTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to request token from metadata socket: %v", err)
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to request token from metadata socket: %v", rsp.Status)
+	}
+
+	var token spaceToken
+	err = json.NewDecoder(rsp.Body).Decode(&token)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode token from metadata socket: %v", err)
+	}
+	return &token, nil
+}
+
+func getFileToken(path string) (*spaceToken, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read token file: %v", err)
+	}
+	token := &spaceToken{Token: strings.TrimSpace(string(content))}
+
+	// The expiry lets client-go know when to call again. A token whose
+	// expiry cannot be determined is returned without one, so client-go
+	// only calls again once the token is rejected.
+	if exp, ok := tokenExpiration(token.Token); ok {
+		token.ExpirationTimestamp = metav1.NewTime(exp)
+	}
+	return token, nil
+}
+
+// tokenExpiration reads the exp claim of a JWT without verifying it.
+func tokenExpiration(token string) (time.Time, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}, false
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	err = json.Unmarshal(payload, &claims)
+	if err != nil || claims.Exp == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(claims.Exp, 0), true
+}
@@ -0,0 +1,228 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientauthenticationv1 "k8s.io/client-go/pkg/apis/clientauthentication/v1"
+)
+
+// testJWT returns an unsigned token with the given exp claim.
+func testJWT(exp int64) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	payload, _ := json.Marshal(map[string]int64{"exp": exp})
+	return header + "." + base64.RawURLEncoding.EncodeToString(payload) + ".sig"
+}
+
+// serveMetadata serves GET /token on the metadata socket in dir with the
+// given handler.
+func serveMetadata(t *testing.T, dir string, handler http.HandlerFunc) {
+	t.Helper()
+	listener, err := net.Listen("unix", filepath.Join(dir, metadataSocketName))
+	if err != nil {
+		t.Fatalf("unable to listen on metadata socket: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", handler)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: socketTimeout}
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(func() { server.Close() })
+}
+
+func writeTokenFile(t *testing.T, dir string, token string) {
+	t.Helper()
+	err := os.WriteFile(filepath.Join(dir, tokenFileName), []byte(token+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// runApp runs the helper with the given arguments and decodes what it
+// printed.
+func runApp(t *testing.T, args ...string) (*clientauthenticationv1.ExecCredential, error) {
+	t.Helper()
+	var out bytes.Buffer
+	app := newApp()
+	app.Writer = &out
+	err := app.RunContext(context.Background(), append([]string{"dra-space-credential"}, args...))
+	if err != nil {
+		return nil, err
+	}
+	var credential clientauthenticationv1.ExecCredential
+	err = json.Unmarshal(out.Bytes(), &credential)
+	if err != nil {
+		t.Fatalf("unable to decode output %q: %v", out.String(), err)
+	}
+	return &credential, nil
+}
+
+func TestExecCredential(t *testing.T) {
+	expiration := time.Now().Add(time.Hour).Truncate(time.Second)
+	fileToken := testJWT(expiration.Add(-time.Minute).Unix())
+
+	tests := []struct {
+		name       string
+		socket     http.HandlerFunc
+		fileToken  string
+		wantToken  string
+		wantExpiry *time.Time
+	}{
+		{
+			name: "metadata socket",
+			socket: func(w http.ResponseWriter, r *http.Request) {
+				_ = json.NewEncoder(w).Encode(&spaceToken{Token: "socket-token", ExpirationTimestamp: metav1.NewTime(expiration)})
+			},
+			fileToken:  fileToken,
+			wantToken:  "socket-token",
+			wantExpiry: &expiration,
+		},
+		{
+			name: "metadata socket without expiration",
+			socket: func(w http.ResponseWriter, r *http.Request) {
+				_ = json.NewEncoder(w).Encode(&spaceToken{Token: "socket-token"})
+			},
+			wantToken: "socket-token",
+		},
+		{
+			name: "fallback to token file",
+			socket: func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "space expired", http.StatusForbidden)
+			},
+			fileToken:  fileToken,
+			wantToken:  fileToken,
+			wantExpiry: func() *time.Time { t := expiration.Add(-time.Minute); return &t }(),
+		},
+		{
+			name: "invalid response from metadata socket",
+			socket: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("not json"))
+			},
+			fileToken:  fileToken,
+			wantToken:  fileToken,
+			wantExpiry: func() *time.Time { t := expiration.Add(-time.Minute); return &t }(),
+		},
+		{
+			name:      "token file without expiration",
+			fileToken: "opaque-token",
+			wantToken: "opaque-token",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if test.socket != nil {
+				serveMetadata(t, dir, test.socket)
+			}
+			if test.fileToken != "" {
+				writeTokenFile(t, dir, test.fileToken)
+			}
+
+			credential, err := runApp(t, "--dir", dir)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if credential.APIVersion != clientauthenticationv1.SchemeGroupVersion.String() || credential.Kind != "ExecCredential" {
+				t.Errorf("expected an ExecCredential, got %v", credential.TypeMeta)
+			}
+			if credential.Status == nil {
+				t.Fatalf("expected a status")
+			}
+			if credential.Status.Token != test.wantToken {
+				t.Errorf("expected token %q, got %q", test.wantToken, credential.Status.Token)
+			}
+			switch got := credential.Status.ExpirationTimestamp; {
+			case test.wantExpiry == nil && got != nil:
+				t.Errorf("expected no expiration timestamp, got %v", got)
+			case test.wantExpiry != nil && (got == nil || !got.Time.Equal(*test.wantExpiry)):
+				t.Errorf("expected expiration timestamp %v, got %v", *test.wantExpiry, got)
+			}
+		})
+	}
+}
+
+func TestExecCredentialErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    func(dir string) []string
+		wantErr string
+	}{
+		{
+			name:    "missing directory flag",
+			args:    func(string) []string { return nil },
+			wantErr: "dir",
+		},
+		{
+			name:    "arguments",
+			args:    func(dir string) []string { return []string{"--dir", dir, "extra"} },
+			wantErr: "arguments not supported",
+		},
+		{
+			name:    "no socket and no token file",
+			args:    func(dir string) []string { return []string{"--dir", dir} },
+			wantErr: "unable to read token file",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := runApp(t, test.args(t.TempDir())...)
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("expected error containing %q, got %v", test.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestTokenExpiration(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		want   int64
+		wantOk bool
+	}{
+		{name: "exp claim", token: testJWT(1700000000), want: 1700000000, wantOk: true},
+		{name: "no exp claim", token: testJWT(0)},
+		{name: "not a JWT", token: "opaque-token"},
+		{name: "invalid encoding", token: "a.!!!.c"},
+		{name: "invalid payload", token: "a." + base64.RawURLEncoding.EncodeToString([]byte("not json")) + ".c"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exp, ok := tokenExpiration(test.token)
+			if ok != test.wantOk {
+				t.Fatalf("expected ok %v, got %v", test.wantOk, ok)
+			}
+			if ok && exp.Unix() != test.want {
+				t.Errorf("expected expiration %d, got %d", test.want, exp.Unix())
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	corev1 "k8s.io/api/core/v1"
+	resourcev1 "k8s.io/api/resource/v1alpha2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	spacecrd "sigs.k8s.io/dra-example-driver/api/example.com/resource/space/v1alpha1"
+)
+
+func Describe(ctx context.Context, config *Config, out io.Writer, name string) error {
+	claim, err := getClaim(ctx, config, name)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", claim.Name)
+	fmt.Fprintf(w, "Namespace:\t%s\n", claim.Namespace)
+	fmt.Fprintf(w, "UID:\t%s\n", claim.UID)
+	fmt.Fprintf(w, "Class:\t%s\n", claim.Spec.ResourceClassName)
+	fmt.Fprintf(w, "Status:\t%s\n", claimStatus(claim))
+
+	err = describeParameters(ctx, w, config, claim)
+	if err != nil {
+		return err
+	}
+
+	namespaces, err := getSpaceNamespaces(ctx, config, string(claim.UID))
+	if err != nil {
+		return err
+	}
+	if len(namespaces) == 0 {
+		fmt.Fprintf(w, "Space:\t<none>\n")
+	}
+	for _, ns := range namespaces {
+		if name := ns.Labels[SpaceNameLabel]; name != "" {
+			fmt.Fprintf(w, "Space:\t%s (%s, %s)\n", ns.Name, name, ns.Status.Phase)
+		} else {
+			fmt.Fprintf(w, "Space:\t%s (%s)\n", ns.Name, ns.Status.Phase)
+		}
+		err = describeRBAC(ctx, w, config, ns.Name)
+		if err != nil {
+			return err
+		}
+		err = describeQuota(ctx, w, config, ns.Name)
+		if err != nil {
+			return err
+		}
+	}
+
+	describeConsumers(w, claim)
+
+	return w.Flush()
+}
+
+func describeParameters(ctx context.Context, w io.Writer, config *Config, claim *resourcev1.ResourceClaim) error {
+	spec := spacecrd.DefaultSpaceClaimParametersSpec()
+	source := "<defaults>"
+
+	ref := claim.Spec.ParametersRef
+	if ref != nil {
+		source = fmt.Sprintf("%s/%s", ref.Kind, ref.Name)
+		if ref.APIGroup == spacecrd.GroupName && ref.Kind == spacecrd.SpaceClaimParametersKind {
+			params, err := config.clientsets.Example.SpaceV1alpha1().SpaceClaimParameters(claim.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+			if err != nil {
+				return fmt.Errorf("error getting SpaceClaimParameters called '%v' in namespace '%v': %v", ref.Name, claim.Namespace, err)
+			}
+			spec = &params.Spec
+		}
+	}
+
+	out, err := yaml.Marshal(spec)
+	if err != nil {
+		return fmt.Errorf("unable to format parameters: %v", err)
+	}
+
+	fmt.Fprintf(w, "Parameters:\t%s\n", source)
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		fmt.Fprintf(w, "  %s\n", line)
+	}
+	return nil
+}
+
+func describeRBAC(ctx context.Context, w io.Writer, config *Config, namespace string) error {
+	bindings, err := config.clientsets.Core.RbacV1().RoleBindings(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to list role bindings: %v", err)
+	}
+
+	fmt.Fprintf(w, "RBAC:\n")
+	if len(bindings.Items) == 0 {
+		fmt.Fprintf(w, "  <none>\n")
+	}
+	for _, binding := range bindings.Items {
+		var subjects []string
+		for _, subject := range binding.Subjects {
+			if subject.Namespace != "" {
+				subjects = append(subjects, fmt.Sprintf("%s:%s/%s", subject.Kind, subject.Namespace, subject.Name))
+			} else {
+				subjects = append(subjects, fmt.Sprintf("%s:%s", subject.Kind, subject.Name))
+			}
+		}
+		fmt.Fprintf(w, "  %s\t%s/%s\t%s\n", binding.Name, binding.RoleRef.Kind, binding.RoleRef.Name, strings.Join(subjects, ","))
+	}
+	return nil
+}
+
+func describeQuota(ctx context.Context, w io.Writer, config *Config, namespace string) error {
+	quotas, err := config.clientsets.Core.CoreV1().ResourceQuotas(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to list resource quotas: %v", err)
+	}
+
+	fmt.Fprintf(w, "Quota:\n")
+	if len(quotas.Items) == 0 {
+		fmt.Fprintf(w, "  <none>\n")
+	}
+	for _, quota := range quotas.Items {
+		fmt.Fprintf(w, "  %s:\n", quota.Name)
+		var resources []string
+		for resource := range quota.Status.Hard {
+			resources = append(resources, string(resource))
+		}
+		sort.Strings(resources)
+		for _, resource := range resources {
+			hard := quota.Status.Hard[corev1.ResourceName(resource)]
+			used := quota.Status.Used[corev1.ResourceName(resource)]
+			fmt.Fprintf(w, "    %s\t%s/%s\n", resource, used.String(), hard.String())
+		}
+	}
+	return nil
+}
+
+func describeConsumers(w io.Writer, claim *resourcev1.ResourceClaim) {
+	fmt.Fprintf(w, "Consumers:\n")
+	if len(claim.Status.ReservedFor) == 0 {
+		fmt.Fprintf(w, "  <none>\n")
+	}
+	for _, consumer := range claim.Status.ReservedFor {
+		fmt.Fprintf(w, "  %s/%s\n", consumer.Resource, consumer.Name)
+	}
+}
@@ -0,0 +1,315 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	resourcev1 "k8s.io/api/resource/v1alpha2"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	corefake "k8s.io/client-go/kubernetes/fake"
+
+	spacecrd "sigs.k8s.io/dra-example-driver/api/example.com/resource/space/v1alpha1"
+	examplefake "sigs.k8s.io/dra-example-driver/pkg/example.com/resource/clientset/versioned/fake"
+	"sigs.k8s.io/dra-example-driver/pkg/flags"
+)
+
+const testNamespace = "default"
+
+// newTestConfig returns a configuration for the default driver name which
+// uses fake clientsets with the given objects.
+func newTestConfig(t *testing.T, coreObjects []runtime.Object, params []*spacecrd.SpaceClaimParameters) *Config {
+	DriverName = spacecrd.GroupName
+	ResourceClaimLabel = DriverName + "/resourceclaim"
+	SpaceNameLabel = DriverName + "/space"
+
+	// The object tracker of the fake clientset guesses the wrong resource
+	// for SpaceClaimParameters, so they are created through the client.
+	example := examplefake.NewSimpleClientset()
+	for _, p := range params {
+		_, err := example.SpaceV1alpha1().SpaceClaimParameters(p.Namespace).Create(context.Background(), p, metav1.CreateOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return &Config{
+		flags: &Flags{},
+		clientsets: flags.ClientSets{
+			Core:    corefake.NewSimpleClientset(coreObjects...),
+			Example: example,
+		},
+		namespace: testNamespace,
+	}
+}
+
+// normalizeOutput collapses the padding inserted by the tabwriter, so that
+// the expected output does not depend on the column widths.
+func normalizeOutput(out string) string {
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		indent := line[:len(line)-len(strings.TrimLeft(line, " "))]
+		lines = append(lines, indent+strings.Join(strings.Fields(line), " "))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestDescribeParameters(t *testing.T) {
+	paramsRef := &resourcev1.ResourceClaimParametersReference{
+		APIGroup: spacecrd.GroupName,
+		Kind:     spacecrd.SpaceClaimParametersKind,
+		Name:     "team-space",
+	}
+	params := &spacecrd.SpaceClaimParameters{
+		ObjectMeta: metav1.ObjectMeta{Name: "team-space", Namespace: testNamespace},
+		Spec: spacecrd.SpaceClaimParametersSpec{
+			GenerateName:     "team-",
+			EnvPrefix:        "TEAM",
+			CredentialLayout: spacecrd.CredentialLayoutBoth,
+		},
+	}
+
+	tests := []struct {
+		name   string
+		ref    *resourcev1.ResourceClaimParametersReference
+		params []*spacecrd.SpaceClaimParameters
+		output string
+		valid  bool
+	}{
+		{
+			name: "defaults",
+			output: `Parameters: <defaults>
+  generateName: space-`,
+			valid: true,
+		},
+		{
+			name:   "SpaceClaimParameters",
+			ref:    paramsRef,
+			params: []*spacecrd.SpaceClaimParameters{params},
+			output: `Parameters: SpaceClaimParameters/team-space
+  credentialLayout: both
+  envPrefix: TEAM
+  generateName: team-`,
+			valid: true,
+		},
+		{
+			name: "missing SpaceClaimParameters",
+			ref:  paramsRef,
+		},
+		{
+			name: "parameters of another driver",
+			ref: &resourcev1.ResourceClaimParametersReference{
+				APIGroup: "gpu.resource.example.com",
+				Kind:     "GpuClaimParameters",
+				Name:     "gpu",
+			},
+			output: `Parameters: GpuClaimParameters/gpu
+  generateName: space-`,
+			valid: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			config := newTestConfig(t, nil, tc.params)
+			claim := &resourcev1.ResourceClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-claim", Namespace: testNamespace},
+				Spec:       resourcev1.ResourceClaimSpec{ParametersRef: tc.ref},
+			}
+
+			var out bytes.Buffer
+			err := describeParameters(context.Background(), &out, config, claim)
+			switch {
+			case !tc.valid && err == nil:
+				t.Fatalf("expected an error, got output:\n%s", out.String())
+			case tc.valid && err != nil:
+				t.Fatalf("unable to describe parameters: %v", err)
+			}
+			if !tc.valid {
+				return
+			}
+			if output := normalizeOutput(out.String()); output != tc.output {
+				t.Errorf("expected output:\n%s\ngot:\n%s", tc.output, output)
+			}
+		})
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	claim := func() *resourcev1.ResourceClaim {
+		return &resourcev1.ResourceClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-claim", Namespace: testNamespace, UID: "uid-1"},
+			Spec:       resourcev1.ResourceClaimSpec{ResourceClassName: "space.example.com"},
+			Status:     resourcev1.ResourceClaimStatus{DriverName: spacecrd.GroupName},
+		}
+	}
+	allocated := claim()
+	allocated.Status.Allocation = &resourcev1.AllocationResult{}
+	allocated.Status.ReservedFor = []resourcev1.ResourceClaimConsumerReference{
+		{Resource: "pods", Name: "pod-a", UID: "pod-uid-a"},
+		{Resource: "pods", Name: "pod-b", UID: "pod-uid-b"},
+	}
+
+	spaceNamespace := func(name string, claimUid string, spaceName string) *corev1.Namespace {
+		ns := &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   name,
+				Labels: map[string]string{spacecrd.GroupName + "/resourceclaim": claimUid},
+			},
+			Status: corev1.NamespaceStatus{Phase: corev1.NamespaceActive},
+		}
+		if spaceName != "" {
+			ns.Labels[spacecrd.GroupName+"/space"] = spaceName
+		}
+		return ns
+	}
+	binding := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: "space-admin", Namespace: "space-abc"},
+		RoleRef:    rbacv1.RoleRef{Kind: "ClusterRole", Name: "admin"},
+		Subjects: []rbacv1.Subject{
+			{Kind: "ServiceAccount", Namespace: "space-abc", Name: "space"},
+			{Kind: "Group", Name: "developers"},
+		},
+	}
+	quota := &corev1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: "space-quota", Namespace: "space-abc"},
+		Status: corev1.ResourceQuotaStatus{
+			Hard: corev1.ResourceList{
+				corev1.ResourcePods:   resource.MustParse("10"),
+				corev1.ResourceCPU:    resource.MustParse("4"),
+				corev1.ResourceMemory: resource.MustParse("8Gi"),
+			},
+			Used: corev1.ResourceList{
+				corev1.ResourcePods: resource.MustParse("2"),
+				corev1.ResourceCPU:  resource.MustParse("500m"),
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		output  string
+	}{
+		{
+			name:    "pending",
+			objects: []runtime.Object{claim()},
+			output: `Name: test-claim
+Namespace: default
+UID: uid-1
+Class: space.example.com
+Status: Pending
+Parameters: <defaults>
+  generateName: space-
+Space: <none>
+Consumers:
+  <none>`,
+		},
+		{
+			name: "space with RBAC, quota and consumers",
+			objects: []runtime.Object{
+				allocated,
+				spaceNamespace("space-abc", "uid-1", ""),
+				spaceNamespace("space-other", "uid-2", ""),
+				binding,
+				quota,
+			},
+			output: `Name: test-claim
+Namespace: default
+UID: uid-1
+Class: space.example.com
+Status: Reserved
+Parameters: <defaults>
+  generateName: space-
+Space: space-abc (Active)
+RBAC:
+  space-admin ClusterRole/admin ServiceAccount:space-abc/space,Group:developers
+Quota:
+  space-quota:
+    cpu 500m/4
+    memory 0/8Gi
+    pods 2/10
+Consumers:
+  pods/pod-a
+  pods/pod-b`,
+		},
+		{
+			name: "named spaces without RBAC and quota",
+			objects: []runtime.Object{
+				allocated,
+				spaceNamespace("space-dev", "uid-1", "dev"),
+				spaceNamespace("space-prod", "uid-1", "prod"),
+			},
+			output: `Name: test-claim
+Namespace: default
+UID: uid-1
+Class: space.example.com
+Status: Reserved
+Parameters: <defaults>
+  generateName: space-
+Space: space-dev (dev, Active)
+RBAC:
+  <none>
+Quota:
+  <none>
+Space: space-prod (prod, Active)
+RBAC:
+  <none>
+Quota:
+  <none>
+Consumers:
+  pods/pod-a
+  pods/pod-b`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			config := newTestConfig(t, tc.objects, nil)
+
+			var out bytes.Buffer
+			err := Describe(context.Background(), config, &out, "test-claim")
+			if err != nil {
+				t.Fatalf("unable to describe claim: %v", err)
+			}
+			if output := normalizeOutput(out.String()); output != tc.output {
+				t.Errorf("expected output:\n%s\ngot:\n%s", tc.output, output)
+			}
+		})
+	}
+}
+
+func TestDescribeOtherDriver(t *testing.T) {
+	claim := &resourcev1.ResourceClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-claim", Namespace: testNamespace},
+		Status:     resourcev1.ResourceClaimStatus{DriverName: "gpu.resource.example.com"},
+	}
+	config := newTestConfig(t, []runtime.Object{claim}, nil)
+
+	var out bytes.Buffer
+	err := Describe(context.Background(), config, &out, "test-claim")
+	if err == nil {
+		t.Fatalf("expected claim of another driver to be rejected, got output:\n%s", out.String())
+	}
+}
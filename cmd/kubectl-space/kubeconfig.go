@@ -0,0 +1,92 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// Kubeconfig prints a self-contained kubeconfig derived from the caller's
+// current context with one context per space of the claim. The caller's own
+// credentials are reused, so access is still governed by their RBAC
+// permissions.
+func Kubeconfig(ctx context.Context, config *Config, name string) error {
+	claim, err := getClaim(ctx, config, name)
+	if err != nil {
+		return err
+	}
+
+	namespaces, err := getSpaceNamespaces(ctx, config, string(claim.UID))
+	if err != nil {
+		return err
+	}
+	if len(namespaces) == 0 {
+		return fmt.Errorf("claim %s/%s has no space allocated", claim.Namespace, claim.Name)
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].Labels[SpaceNameLabel] < namespaces[j].Labels[SpaceNameLabel]
+	})
+
+	raw, err := config.clientConfig.RawConfig()
+	if err != nil {
+		return fmt.Errorf("unable to load kubeconfig: %v", err)
+	}
+	if config.flags.kubeContext != "" {
+		raw.CurrentContext = config.flags.kubeContext
+	}
+
+	err = clientcmdapi.MinifyConfig(&raw)
+	if err != nil {
+		return fmt.Errorf("unable to minify kubeconfig: %v", err)
+	}
+	err = clientcmdapi.FlattenConfig(&raw)
+	if err != nil {
+		return fmt.Errorf("unable to flatten kubeconfig: %v", err)
+	}
+
+	// Each space gets its own context; the first one becomes current.
+	current := raw.Contexts[raw.CurrentContext]
+	raw.Contexts = map[string]*clientcmdapi.Context{}
+	raw.CurrentContext = ""
+	for _, ns := range namespaces {
+		contextName := fmt.Sprintf("%s-%s", claim.Namespace, claim.Name)
+		if name := ns.Labels[SpaceNameLabel]; name != "" {
+			contextName += "-" + name
+		}
+
+		spaceContext := current.DeepCopy()
+		spaceContext.Namespace = ns.Name
+		raw.Contexts[contextName] = spaceContext
+		if raw.CurrentContext == "" {
+			raw.CurrentContext = contextName
+		}
+	}
+
+	out, err := clientcmd.Write(raw)
+	if err != nil {
+		return fmt.Errorf("unable to serialize kubeconfig: %v", err)
+	}
+
+	_, err = os.Stdout.Write(out)
+	return err
+}
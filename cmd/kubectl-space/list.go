@@ -0,0 +1,115 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	resourcev1 "k8s.io/api/resource/v1alpha2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+
+	spacecrd "sigs.k8s.io/dra-example-driver/api/example.com/resource/space/v1alpha1"
+)
+
+func List(ctx context.Context, config *Config) error {
+	namespace := config.namespace
+	if config.flags.allNamespaces {
+		namespace = metav1.NamespaceAll
+	}
+
+	classes, err := config.clientsets.Core.ResourceV1alpha2().ResourceClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to list resource classes: %v", err)
+	}
+	spaceClasses := make(map[string]bool)
+	for _, class := range classes.Items {
+		if class.DriverName == DriverName {
+			spaceClasses[class.Name] = true
+		}
+	}
+
+	claims, err := config.clientsets.Core.ResourceV1alpha2().ResourceClaims(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to list resource claims: %v", err)
+	}
+
+	spaceList, err := config.clientsets.Example.SpaceV1alpha1().Spaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("unable to list spaces: %v", err)
+	}
+	spaces := make(map[string][]*spacecrd.Space)
+	for i := range spaceList.Items {
+		space := &spaceList.Items[i]
+		if space.Status.ClaimRef != nil {
+			uid := string(space.Status.ClaimRef.UID)
+			spaces[uid] = append(spaces[uid], space)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(w, "NAMESPACE\tCLAIM\tSTATUS\tSPACE\tPHASE\tAGE")
+	for i := range claims.Items {
+		claim := &claims.Items[i]
+		if claim.Status.DriverName != DriverName && !spaceClasses[claim.Spec.ResourceClassName] {
+			continue
+		}
+
+		age := duration.HumanDuration(time.Since(claim.CreationTimestamp.Time))
+		claimSpaces := spaces[string(claim.UID)]
+		if len(claimSpaces) == 0 {
+			fmt.Fprintf(w, "%s\t%s\t%s\t<none>\t<none>\t%s\n", claim.Namespace, claim.Name, claimStatus(claim), age)
+			continue
+		}
+
+		// Claims with several spaces get one row per space.
+		sort.Slice(claimSpaces, func(i, j int) bool { return claimSpaces[i].Name < claimSpaces[j].Name })
+		for _, space := range claimSpaces {
+			spaceNamespace := space.Status.Namespace
+			if spaceNamespace == "" {
+				spaceNamespace = "<none>"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				claim.Namespace,
+				claim.Name,
+				claimStatus(claim),
+				spaceNamespace,
+				space.Status.Phase,
+				age)
+		}
+	}
+
+	return w.Flush()
+}
+
+func claimStatus(claim *resourcev1.ResourceClaim) string {
+	switch {
+	case claim.Status.DeallocationRequested:
+		return "Deallocating"
+	case claim.Status.Allocation == nil:
+		return "Pending"
+	case len(claim.Status.ReservedFor) > 0:
+		return "Reserved"
+	default:
+		return "Allocated"
+	}
+}
@@ -0,0 +1,216 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+
+	corev1 "k8s.io/api/core/v1"
+	resourcev1 "k8s.io/api/resource/v1alpha2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coreclientset "k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	exampleclientset "sigs.k8s.io/dra-example-driver/pkg/example.com/resource/clientset/versioned"
+	"sigs.k8s.io/dra-example-driver/pkg/flags"
+)
+
+// The driver name and the label keys derived from it are set from the
+// --driver-name flag, which selects the instance of the driver to inspect.
+var (
+	DriverName         string
+	ResourceClaimLabel string
+	SpaceNameLabel     string
+)
+
+type Flags struct {
+	kubeconfig    string
+	kubeContext   string
+	namespace     string
+	allNamespaces bool
+	driverName    flags.DriverNameConfig
+}
+
+type Config struct {
+	flags        *Flags
+	clientConfig clientcmd.ClientConfig
+	clientsets   flags.ClientSets
+	namespace    string
+}
+
+func main() {
+	if err := newApp().Run(os.Args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func newApp() *cli.App {
+	flags := &Flags{}
+	config := &Config{flags: flags}
+
+	cliFlags := []cli.Flag{
+		&cli.StringFlag{
+			Name:        "kubeconfig",
+			Usage:       "Path to the kubeconfig file to use for CLI requests.",
+			Destination: &flags.kubeconfig,
+		},
+		&cli.StringFlag{
+			Name:        "context",
+			Usage:       "The name of the kubeconfig context to use.",
+			Destination: &flags.kubeContext,
+		},
+		&cli.StringFlag{
+			Name:        "namespace",
+			Aliases:     []string{"n"},
+			Usage:       "The namespace of the resource claims. Defaults to the namespace of the current context.",
+			Destination: &flags.namespace,
+		},
+	}
+	cliFlags = append(cliFlags, flags.driverName.Flags()...)
+
+	app := &cli.App{
+		Name:            "kubectl-space",
+		Usage:           "kubectl-space inspects resource claims for spaces and the namespaces allocated to them.",
+		HideHelpCommand: true,
+		Flags:           cliFlags,
+		Before: func(c *cli.Context) error {
+			return config.init()
+		},
+		Commands: []*cli.Command{
+			{
+				Name:      "list",
+				Usage:     "List space claims and their spaces.",
+				ArgsUsage: " ",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:        "all-namespaces",
+						Aliases:     []string{"A"},
+						Usage:       "List claims across all namespaces.",
+						Destination: &flags.allNamespaces,
+					},
+				},
+				Action: func(c *cli.Context) error {
+					if c.Args().Len() > 0 {
+						return fmt.Errorf("arguments not supported: %v", c.Args().Slice())
+					}
+					return List(c.Context, config)
+				},
+			},
+			{
+				Name:      "describe",
+				Usage:     "Show the parameters, namespace, RBAC, quota and consuming pods of a claim.",
+				ArgsUsage: "<claim>",
+				Action: func(c *cli.Context) error {
+					if c.Args().Len() != 1 {
+						return fmt.Errorf("expected exactly one claim name")
+					}
+					return Describe(c.Context, config, c.App.Writer, c.Args().First())
+				},
+			},
+			{
+				Name:      "kubeconfig",
+				Usage:     "Print a kubeconfig whose current context targets the space of a claim.",
+				ArgsUsage: "<claim>",
+				Action: func(c *cli.Context) error {
+					if c.Args().Len() != 1 {
+						return fmt.Errorf("expected exactly one claim name")
+					}
+					return Kubeconfig(c.Context, config, c.Args().First())
+				},
+			},
+		},
+	}
+
+	return app
+}
+
+// init builds the clients the same way kubectl does, honouring KUBECONFIG
+// and the current context unless overridden on the command line.
+func (c *Config) init() error {
+	err := c.flags.driverName.Validate()
+	if err != nil {
+		return err
+	}
+	DriverName = c.flags.driverName.Name
+	ResourceClaimLabel = DriverName + "/resourceclaim"
+	SpaceNameLabel = DriverName + "/space"
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = c.flags.kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: c.flags.kubeContext}
+	c.clientConfig = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides)
+
+	csconfig, err := c.clientConfig.ClientConfig()
+	if err != nil {
+		return fmt.Errorf("create client configuration: %v", err)
+	}
+
+	coreclient, err := coreclientset.NewForConfig(csconfig)
+	if err != nil {
+		return fmt.Errorf("create core client: %v", err)
+	}
+
+	exampleclient, err := exampleclientset.NewForConfig(csconfig)
+	if err != nil {
+		return fmt.Errorf("create example.com client: %v", err)
+	}
+
+	c.clientsets = flags.ClientSets{
+		Core:    coreclient,
+		Example: exampleclient,
+	}
+
+	c.namespace = c.flags.namespace
+	if c.namespace == "" {
+		c.namespace, _, err = c.clientConfig.Namespace()
+		if err != nil {
+			return fmt.Errorf("determine namespace: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func getClaim(ctx context.Context, config *Config, name string) (*resourcev1.ResourceClaim, error) {
+	claims := config.clientsets.Core.ResourceV1alpha2().ResourceClaims(config.namespace)
+	claim, err := claims.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get claim: %v", err)
+	}
+	if claim.Status.DriverName != "" && claim.Status.DriverName != DriverName {
+		return nil, fmt.Errorf("claim %s/%s is not managed by %s", claim.Namespace, claim.Name, DriverName)
+	}
+	return claim, nil
+}
+
+// getSpaceNamespaces returns the namespaces allocated to a claim, which is
+// empty if the claim has not been allocated yet.
+func getSpaceNamespaces(ctx context.Context, config *Config, claimUid string) ([]corev1.Namespace, error) {
+	api := config.clientsets.Core.CoreV1().Namespaces()
+	selector := ResourceClaimLabel + "=" + claimUid
+	namespaces, err := api.List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list namespaces: %v", err)
+	}
+
+	return namespaces.Items, nil
+}
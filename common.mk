@@ -12,7 +12,7 @@
 # See the License for the specific language governing permissions and
 # limitations under the License.
 
-GOLANG_VERSION ?= 1.20.3
+GOLANG_VERSION ?= 1.22.12
 
 DRIVER_NAME := dra-example-driver
 MODULE := sigs.k8s.io/$(DRIVER_NAME)
@@ -24,6 +24,7 @@ VENDOR := example.com
 APIS := space/v1alpha1
 
 PLURAL_EXCEPTIONS  = SpaceClaimParameters:SpaceClaimParameters
+PLURAL_EXCEPTIONS += SpaceClassParameters:SpaceClassParameters
 
 ifeq ($(IMAGE_NAME),)
 REGISTRY ?= registry.example.com
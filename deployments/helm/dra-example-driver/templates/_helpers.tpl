@@ -95,3 +95,27 @@ Create the name of the service account to use
 {{- default "default" .Values.serviceAccount.name }}
 {{- end }}
 {{- end }}
+
+{{/*
+Create the name of the service account used by the controller
+*/}}
+{{- define "dra-example-driver.controllerServiceAccountName" -}}
+{{- $name := printf "%s-controller-service-account" (include "dra-example-driver.fullname" .) }}
+{{- if .Values.serviceAccount.create }}
+{{- default $name .Values.serviceAccount.controllerName }}
+{{- else }}
+{{- default "default" .Values.serviceAccount.controllerName }}
+{{- end }}
+{{- end }}
+
+{{/*
+Host path of the claim artifacts root. Every instance of the driver needs its
+own, so instances other than the default one get their name appended.
+*/}}
+{{- define "dra-example-driver.claimArtifactsRoot" -}}
+{{- if eq .Values.driverName "space.resource.example.com" -}}
+/var/run/claim-artifacts
+{{- else -}}
+/var/run/claim-artifacts-{{ .Values.driverName }}
+{{- end }}
+{{- end }}
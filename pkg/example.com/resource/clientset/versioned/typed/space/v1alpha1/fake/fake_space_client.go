@@ -28,10 +28,18 @@ type FakeSpaceV1alpha1 struct {
 	*testing.Fake
 }
 
+func (c *FakeSpaceV1alpha1) Spaces() v1alpha1.SpaceInterface {
+	return &FakeSpaces{c}
+}
+
 func (c *FakeSpaceV1alpha1) SpaceClaimParameters(namespace string) v1alpha1.SpaceClaimParametersInterface {
 	return &FakeSpaceClaimParameters{c, namespace}
 }
 
+func (c *FakeSpaceV1alpha1) SpaceClassParameters() v1alpha1.SpaceClassParametersInterface {
+	return &FakeSpaceClassParameters{c}
+}
+
 // RESTClient returns a RESTClient that is used to communicate
 // with API server by this client implementation.
 func (c *FakeSpaceV1alpha1) RESTClient() rest.Interface {
@@ -18,4 +18,8 @@
 
 package v1alpha1
 
+type SpaceExpansion interface{}
+
 type SpaceClaimParametersExpansion interface{}
+
+type SpaceClassParametersExpansion interface{}
@@ -28,7 +28,9 @@ import (
 
 type SpaceV1alpha1Interface interface {
 	RESTClient() rest.Interface
+	SpacesGetter
 	SpaceClaimParametersGetter
+	SpaceClassParametersGetter
 }
 
 // SpaceV1alpha1Client is used to interact with features provided by the space.resource.example.com group.
@@ -36,10 +38,18 @@ type SpaceV1alpha1Client struct {
 	restClient rest.Interface
 }
 
+func (c *SpaceV1alpha1Client) Spaces() SpaceInterface {
+	return newSpaces(c)
+}
+
 func (c *SpaceV1alpha1Client) SpaceClaimParameters(namespace string) SpaceClaimParametersInterface {
 	return newSpaceClaimParameters(c, namespace)
 }
 
+func (c *SpaceV1alpha1Client) SpaceClassParameters() SpaceClassParametersInterface {
+	return newSpaceClassParameters(c)
+}
+
 // NewForConfig creates a new SpaceV1alpha1Client for the given config.
 // NewForConfig is equivalent to NewForConfigAndClient(c, httpClient),
 // where httpClient was generated with rest.HTTPClientFor(c).
@@ -0,0 +1,56 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package flags
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	spacecrd "sigs.k8s.io/dra-example-driver/api/example.com/resource/space/v1alpha1"
+)
+
+type DriverNameConfig struct {
+	Name string
+}
+
+func (d *DriverNameConfig) Flags() []cli.Flag {
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Name:        "driver-name",
+			Usage:       "The `name` of the driver as referenced by resource classes. Socket paths, the CDI vendor and label keys are derived from it, so several instances of the driver can run side by side under different names.",
+			Value:       spacecrd.GroupName,
+			Destination: &d.Name,
+			EnvVars:     []string{"DRIVER_NAME"},
+		},
+	}
+
+	return flags
+}
+
+// Validate checks that the driver name is a DNS subdomain. This is what
+// resource classes require, and it also makes the name usable as the prefix
+// of label keys and as a path component.
+func (d *DriverNameConfig) Validate() error {
+	if errs := validation.IsDNS1123Subdomain(d.Name); len(errs) > 0 {
+		return fmt.Errorf("invalid driver name %q: %s", d.Name, strings.Join(errs, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright 2023 The Kubernetes Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package flags
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/urfave/cli/v2"
+)
+
+type HandleKeysConfig struct {
+	KeysDir string
+}
+
+func (h *HandleKeysConfig) Flags() []cli.Flag {
+	flags := []cli.Flag{
+		&cli.StringFlag{
+			Category:    "Resource handle signing:",
+			Name:        "handle-keys-dir",
+			Usage:       "`Directory` holding the keys used to sign resource handles, one file per key named by its key ID, typically a mounted Secret. Signing is disabled if empty.",
+			Destination: &h.KeysDir,
+			EnvVars:     []string{"HANDLE_KEYS_DIR"},
+		},
+	}
+
+	return flags
+}
+
+func (h *HandleKeysConfig) Enabled() bool {
+	return h.KeysDir != ""
+}
+
+// LoadKeys reads all keys from the keys directory, indexed by key ID. The
+// directory is read on every call so that keys rotated in a mounted Secret
+// take effect without a restart.
+func (h *HandleKeysConfig) LoadKeys() (map[string][]byte, error) {
+	entries, err := os.ReadDir(h.KeysDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read handle keys: %v", err)
+	}
+
+	keys := make(map[string][]byte)
+	for _, entry := range entries {
+		// Skip the ..data directory and other bookkeeping of Secret volumes.
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(h.KeysDir, entry.Name())
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read handle key %v: %v", entry.Name(), err)
+		}
+		if info.IsDir() {
+			continue
+		}
+		key, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read handle key %v: %v", entry.Name(), err)
+		}
+		if len(key) == 0 {
+			return nil, fmt.Errorf("handle key %v is empty", entry.Name())
+		}
+		keys[entry.Name()] = key
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no handle keys found in %v", h.KeysDir)
+	}
+	return keys, nil
+}